@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/signal"
 	"strings"
 	"syscall"
 
@@ -27,8 +26,9 @@ import (
 )
 
 func main() {
-	// Setup signal handling.
-	ctx, cancel := signal.NotifyContext(context.Background(),
+	// Setup signal handling. The first signal asks the command to shut down
+	// gracefully, the second one aborts it.
+	ctx, cancel := cmdutil.NotifyShutdown(context.Background(),
 		os.Interrupt,
 		os.Kill,
 		syscall.SIGTERM,
@@ -88,6 +88,10 @@ func main() {
 
 	// Finally execute the root command.
 	if cmd, err := rootCmd.ExecuteContextC(ctx); err != nil {
+		var exitErr cmdutil.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		printError(f.IO.ErrOut(), err, cmd)
 		os.Exit(1)
 	} else if root.HasFailed() {
@@ -33,7 +33,7 @@ func New(ctx context.Context, baseURL, accessToken, orgID string, insecure bool)
 	}
 
 	httpClient := &http.Client{
-		Transport: gzhttp.Transport(httpTransport),
+		Transport: retryAfterTransport{gzhttp.Transport(httpTransport)},
 	}
 
 	options := []axiom.Option{
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type retryAfterKey struct{}
+
+type retryAfter struct {
+	mu sync.Mutex
+	d  time.Duration
+}
+
+// WithRetryAfter returns a copy of the context which records the delay the
+// server asks for in the "Retry-After" header of responses to requests made
+// with it. The returned function reports the delay of the last response, zero
+// if it didn't carry the header.
+func WithRetryAfter(ctx context.Context) (context.Context, func() time.Duration) {
+	ra := new(retryAfter)
+	return context.WithValue(ctx, retryAfterKey{}, ra), func() time.Duration {
+		ra.mu.Lock()
+		defer ra.mu.Unlock()
+		return ra.d
+	}
+}
+
+// retryAfterTransport records the "Retry-After" header of responses in the
+// request context, if set up using WithRetryAfter.
+type retryAfterTransport struct {
+	http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+
+	if ra, ok := req.Context().Value(retryAfterKey{}).(*retryAfter); ok {
+		var d time.Duration
+		if resp != nil {
+			d = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+
+		ra.mu.Lock()
+		ra.d = d
+		ra.mu.Unlock()
+	}
+
+	return resp, err
+}
+
+// parseRetryAfter parses the value of a "Retry-After" header, which is either
+// a number of seconds or an HTTP date.
+func parseRetryAfter(s string) time.Duration {
+	if s == "" {
+		return 0
+	} else if sec, err := strconv.Atoi(s); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	} else if t, err := http.ParseTime(s); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -1,14 +1,18 @@
 package ingest
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/AlecAivazis/survey/v2"
@@ -27,6 +31,14 @@ var (
 		axiom.JSON.String(),
 		axiom.NDJSON.String(),
 		axiom.CSV.String(),
+		logfmtFormat.String(),
+		syslogRFC5424Format.String(),
+		syslogRFC3164Format.String(),
+		textFormat.String(),
+		elasticsearchImport.String(),
+		lokiImport.String(),
+		cloudwatchImport.String(),
+		journaldImport.String(),
 	}
 
 	validContentEncodings = []string{
@@ -44,23 +56,174 @@ type options struct {
 	Dataset string
 	// Filenames of the files to ingest. If not set, will read from Stdin.
 	Filenames []string
-	// TimestampField to take the ingestion time from.
-	TimestampField string
-	// TimestampFormat the timestamp is formatted in.
-	TimestampFormat string
+	// TimestampFields to take the ingestion time from. The first one an event
+	// holds is used.
+	TimestampFields []string
+	// TimestampFormats the timestamp is formatted in, tried in order.
+	TimestampFormats []string
+	// TimestampTimezone is the time zone of timestamps without one.
+	TimestampTimezone string
 	// Delimiter that separates CSV fields.
 	Delimiter string
-	// FlushEvery flushes the ingestion buffer after the specified duration. It
-	// is only valid when ingesting a stream of newline delimited JSON objects
-	// of unknown length.
+	// CSVHeader are the names of the CSV fields. If set, the first line of
+	// CSV content is not the header.
+	CSVHeader []string
+	// CSVSkipRows is the amount of lines to skip at the beginning of CSV
+	// content.
+	CSVSkipRows int
+	// CSVTypes are the types of CSV fields, each as a field=type assignment.
+	// The type of other fields is inferred from their values.
+	CSVTypes []string
+	// CSVComment is the prefix of CSV lines to skip.
+	CSVComment string
+	// FlushEvery flushes the ingestion buffer after the specified duration.
 	FlushEvery time.Duration
+	// BatchBytes is the size of uncompressed data after which a batch is
+	// sent, regardless of the flush interval.
+	BatchBytes byteSize
+	// BatchEvents is the amount of events after which a batch is sent,
+	// regardless of the flush interval. Zero means no limit.
+	BatchEvents int
+	// MaxLineSize is the maximum size of a line.
+	MaxLineSize byteSize
+	// OversizedLines configures how lines exceeding the maximum line size are
+	// handled: "fail", "truncate" or "skip".
+	OversizedLines string
+	// MaxEventsPerSecond limits the rate at which events are ingested. Zero
+	// means no limit.
+	MaxEventsPerSecond int
+	// MaxBytesPerSecond limits the rate at which uncompressed data is
+	// ingested. Zero means no limit.
+	MaxBytesPerSecond byteSize
+	// Sample is the fraction of events to ingest. The others are dropped.
+	Sample float64
+	// SampleBy is the field whose value determines if an event is sampled, so
+	// events sharing a value are kept or dropped together.
+	SampleBy string
+	// Follow the files to ingest like "tail -F" does, instead of stopping at
+	// their end.
+	Follow bool
+	// Parallel is the amount of files ingested concurrently.
+	Parallel int
+	// CheckpointFile persists the offsets up to which files have been
+	// ingested.
+	CheckpointFile string
+	// MaxRetries is the maximum amount of times a batch is retried after a
+	// transient error.
+	MaxRetries int
+	// RetryTimeout is the maximum duration spent on retrying a single batch.
+	RetryTimeout time.Duration
+	// ShutdownTimeout is the maximum duration spent on sending pending events
+	// once ingestion is stopped.
+	ShutdownTimeout time.Duration
+	// SpoolDir is the directory batches that fail to be sent are written to,
+	// so they are sent by a later run.
+	SpoolDir string
+	// SpoolMaxSize is the maximum size of the spool. Once exceeded, the
+	// oldest batches are evicted.
+	SpoolMaxSize byteSize
+	// FailuresFile to write rejected events to.
+	FailuresFile string
+	// Exec runs the command given after "--" and ingests the lines it writes
+	// to its standard output and error.
+	Exec bool
+	// DryRun reads and validates the data to ingest and prints a report
+	// about it, without sending anything to the server.
+	DryRun bool
 	// ContentType of the data to ingest.
 	ContentType axiom.ContentType
+	// Format of line based data which is parsed on the client side. If set,
+	// the content type is NDJSON.
+	Format lineFormat
+	// Import is the export format of another tool the data is unwrapped from
+	// on the client side. If set, the content type is NDJSON.
+	Import importFormat
+	// Pattern is a grok pattern or a regular expression with named capture
+	// groups used to extract fields from each line.
+	Pattern string
+	// RejectFile to write lines to which don't match the pattern. If not set,
+	// they are ingested in the "_unparsed" field.
+	RejectFile string
+	// Schema is the file holding the JSON Schema events are validated
+	// against.
+	Schema string
+	// SchemaMode is how events which don't match the schema are handled:
+	// strict or warn.
+	SchemaMode string
+	// SchemaRejectFile to write events to which don't match the schema.
+	SchemaRejectFile string
+	// MultilineStart is a regular expression matching the first line of an
+	// event. Lines not matching it are merged into the preceding event.
+	MultilineStart string
+	// MultilineMaxLines is the maximum amount of lines merged into an event.
+	MultilineMaxLines int
+	// MultilineTimeout is the duration after which an event is considered
+	// complete, if no further lines are read.
+	MultilineTimeout time.Duration
+	// Set fields to a value, given as "field=value".
+	Set []string
+	// Drop the given fields.
+	Drop []string
+	// Rename fields, given as "old=new".
+	Rename []string
+	// Cast fields to a type, given as "field=type".
+	Cast []string
+	// Flatten nested fields by joining their names with a dot.
+	Flatten bool
+	// Redact values matched by the named built-in rules.
+	Redact []string
+	// RedactRegex redacts values matched by the regular expressions.
+	RedactRegex []string
+	// RedactMode is the mode of redaction: "mask", "hash" or "drop".
+	RedactMode string
+	// RedactSalt is prepended to redacted values before they are hashed.
+	RedactSalt string
+	// RedactProfile is the name of a redaction in the configuration file to
+	// load rules from.
+	RedactProfile string
+	// RouteBy is the field whose value determines the dataset an event is
+	// ingested into.
+	RouteBy string
+	// RouteTemplate is a Go template applied to an event to build the name of
+	// the dataset it is ingested into.
+	RouteTemplate string
+	// RouteDefault is the dataset events are ingested into which can't be
+	// routed. Defaults to the dataset given as argument.
+	RouteDefault string
+	// CreateDatasets creates the datasets events are routed to, if they don't
+	// exist.
+	CreateDatasets bool
+	// AddMetadata adds fields describing where an event comes from to every
+	// event.
+	AddMetadata bool
+	// MetadataFields configures the names of the metadata fields, given as
+	// "field=name".
+	MetadataFields []string
 	// ContentEncoding of the data to ingest.
 	ContentEncoding axiom.ContentEncoding
 
 	contentType     string
 	contentEncoding string
+	detectEncoding  bool
+	command         []string
+
+	pattern        *pattern
+	multilineStart *regexp.Regexp
+	processors     []eventFunc
+	timestamps     *timestamps
+	sampler        *sampler
+	throttle       *throttle
+	redactor       *redactor
+	schema         *schemaValidator
+	router         *router
+	metadata       *metadata
+	spool          *spool
+	failures       *failuresFile
+	rejects        *rejectsFile
+	schemaRejects  *rejectsFile
+	report         *dryRunReport
+	oversized      oversizedLines
+	invalid        invalidLines
 }
 
 // NewCmd creates and returns the ingest command.
@@ -70,22 +233,159 @@ func NewCmd(f *cmdutil.Factory) *cobra.Command {
 	}
 
 	cmd := &cobra.Command{
-		Use:   "ingest <dataset-name> [(-f|--file) <filename> [ ...]] [--timestamp-field <timestamp-field>] [--timestamp-format <timestamp-format>] [--flush-every <duration>] [(-t|--content-type <content-type>] [(-e|--content-encoding <content-encoding>]",
+		Use:   "ingest [<dataset-name>] [(-f|--file) <filename> [ ...]] [--parallel <count>] [--follow] [--exec -- <command> [<args> ...]] [--checkpoint-file <filename>] [--failures-file <filename>] [--pattern <pattern> [--reject-file <filename>]] [--multiline-start <regex> [--multiline-max-lines <count>] [--multiline-timeout <duration>]] [--set <field>=<value> ...] [--drop <field> ...] [--rename <old>=<new> ...] [--cast <field>=<type> ...] [--flatten] [--redact <rule>,...] [--redact-regex <regex> ...] [--redact-mode <mode>] [--redact-salt <salt>] [--redact-profile <name>] [--schema <filename> [--schema-mode <mode>] [--schema-reject-file <filename>]] [--route-by <field> [--route-template <template>] [--route-default <dataset>] [--create-datasets]] [--add-metadata [--metadata-field <field>=<name> ...]] [--max-retries <count>] [--retry-timeout <duration>] [--shutdown-timeout <duration>] [--spool-dir <directory> [--spool-max-size <size>]] [--dry-run] [--timestamp-field <timestamp-field>,...] [--timestamp-format <timestamp-format> ...] [--timestamp-timezone <timezone>] [--flush-every <duration>] [--batch-bytes <size>] [--batch-events <count>] [--max-line-size <size>] [--oversized-lines <mode>] [--max-events-per-second <count>] [--max-bytes-per-second <size>] [--sample <fraction> [--sample-by <field>]] [(-t|--content-type <content-type>] [(-e|--content-encoding <content-encoding>] [(-d|--delimiter) <delimiter>] [--csv-header <field>,...] [--csv-skip-rows <count>] [--csv-types <field>=<type>,...] [--csv-comment <prefix>]",
 		Short: "Ingest data",
 		Long: heredoc.Doc(`
 			Ingest data into an Axiom dataset.
 
 			Supported formats are: Newline delimited JSON (NDJSON), an array of
 			JSON objects (JSON) and a newline delimited list of comma separated
-			values (CSV). The first line of CSV content is assumed to be the
-			field names for the values in the following lines. The input format
-			is automatically detected.
-
-			Each object is assigned an event timestamp from the configured
-			timestamp field (default "_time"). If there is no timestamp field
-			Axiom will assign the server side time of reception. The timestamp
-			format can be configured by specifying a pattern with the reference
-			date:
+			values (CSV).
+
+			CSV is decoded on the client side and each record is sent as a JSON
+			object. The first line of CSV content is assumed to be the field
+			names for the values in the following lines, unless they are given
+			by "--csv-header". Lines to skip before the header can be set by
+			"--csv-skip-rows" and lines starting with the "--csv-comment"
+			prefix are skipped, as are empty lines. Quoted values can span
+			multiple lines. Values which look like numbers or booleans (true or
+			false) are sent as such, all others as strings. The type of a field
+			can be set by "--csv-types" to one of string, int, float, bool or
+			time instead. Empty values of fields with a type other than string
+			are omitted.
+
+			Line based formats are parsed on the client side and each line is
+			sent as a JSON object: Key/value pairs (logfmt), syslog messages as
+			specified by RFC 5424 (syslog-rfc5424) and BSD syslog messages as
+			described by RFC 3164 (syslog-rfc3164). Syslog timestamps are
+			written to the "_time" field. Any other text (text) is sent with
+			each line in the "message" field, as are lines that fail to parse.
+			Only the first 64 KiB of the first non-empty line are looked at to
+			detect the format and binary input is rejected.
+
+			Exports of other tools are unwrapped on the client side and each
+			entry is sent as a JSON object, with the timestamp of the tool
+			written to the "_time" field: Elasticsearch bulk requests and
+			scroll or search responses (elasticsearch), the output of Loki's
+			"logcli query --output=jsonl" (loki), CloudWatch Logs subscription
+			data and the output of "aws logs filter-log-events" (cloudwatch)
+			and journal entries written by "journalctl -o export" or
+			"journalctl -o json" (journald).
+
+			The input format is automatically detected from the first line,
+			except for exports of other tools.
+
+			Directories are ingested with all files they contain, recursively,
+			including symbolic links to files, but not to directories. Glob
+			patterns are expanded, with "**" matching any amount of
+			directories. Quote them, so they are expanded by the CLI and not by
+			the shell, e.g. -f 'logs/**/*.json.gz'. Files compressed with gzip
+			or zstd are detected by their first bytes, so they can be mixed with
+			uncompressed ones, unless "--content-encoding" is given. Files are
+			ingested one after another or, with "--parallel", concurrently.
+			When standard error is a terminal, the bytes and events read from
+			each file are displayed while ingesting. If more than one file is
+			ingested, the status of each file is printed at the end.
+
+			Fields can be extracted from free-form lines by specifying a grok
+			pattern using "--pattern". Named patterns are referenced with
+			%{NAME}. Their match is captured into a field with %{NAME:field} or
+			converted to a number with %{NAME:field:int} or %{NAME:field:float}.
+			Besides basic patterns like WORD, NOTSPACE, INT, NUMBER, IP,
+			HOSTNAME, URIPATH, URIPATHPARAM, QS, LOGLEVEL, HTTPDATE,
+			TIMESTAMP_ISO8601, DATA and GREEDYDATA, there are patterns for whole
+			lines of common log formats: APACHE_COMMON, APACHE_COMBINED,
+			APACHE_ERROR, NGINX_COMBINED and POSTGRES. A pattern can also be a
+			regular expression with named capture groups, which become fields.
+			Lines which don't match are ingested in the "_unparsed" field or, if
+			"--reject-file" is specified, appended to that file.
+
+			Events spanning multiple lines, like stack traces, are merged into a
+			single event by specifying a regular expression matching the first
+			line of an event using "--multiline-start". Lines not matching it
+			are appended to the preceding event, which is complete once the
+			next event starts, "--multiline-max-lines" is reached or no further
+			line is read for "--multiline-timeout". The lines of an event are
+			joined with a newline before they are parsed, so they end up in
+			the "message" field of text and in the last field of a pattern
+			matching everything. If no line based format is configured or
+			detected, the input is treated as text.
+
+			Events can be transformed before they are sent, regardless of the
+			input format: "--rename" renames a field, "--set" sets a field to a
+			string value, "--cast" converts a field to an int, float, bool or
+			time (formatted as RFC 3339), "--drop" removes a field and
+			"--flatten" turns nested fields into top-level fields named by
+			joining the names with a dot. Transformations are applied in that
+			order and all but "--flatten" can be repeated. Nested fields are
+			addressed by joining their names with a dot. Transformed events are
+			sent as newline delimited JSON. Lines of newline delimited JSON which
+			are not valid JSON objects are skipped and counted.
+
+			Sensitive data can be redacted from all string values of events
+			before they are sent, using the built-in rules given to "--redact"
+			(email, ip, card and token, which matches bearer tokens) and the
+			regular expressions given to "--redact-regex". Redacted values are
+			masked, hashed using salted SHA-256 or dropped along with the field
+			holding them, as configured by "--redact-mode". Rules can also be
+			loaded from a named redaction in the configuration file using
+			"--redact-profile", which are merged with the ones given by flags:
+
+				[redactions.compliance]
+				rules = ["email", "ip", "card", "token"]
+				regexes = ['secret=\S+']
+				mode = "hash"
+				salt = "..."
+
+			A summary of how many values each rule redacted is printed.
+
+			Events can be validated against a JSON Schema (draft 2020-12) given
+			by "--schema", after they have been transformed and redacted.
+			References are only resolved within the schema. With
+			"--schema-mode" set to "strict" (the default), events which don't
+			match the schema are not ingested and make the command exit with a
+			non-zero code. Set to "warn", they are ingested anyway. Either way,
+			they are counted and, if "--schema-reject-file" is specified,
+			appended to that file as a JSON object holding the JSON pointer to
+			the invalid value ("path"), the validation error ("error") and the
+			event ("event").
+
+			Events can be routed to different datasets by the value of the
+			field given to "--route-by", after they have been transformed and
+			redacted. By default, the value is the name of the dataset. If
+			"--route-template" is given, the name is built by executing the Go
+			template on the event instead, e.g. "logs-{{.service}}". Events
+			without the field are ingested into the dataset given to
+			"--route-default" or, if not set, the one given as argument, as are
+			events routed to an invalid dataset name or to more than 100
+			datasets. Dataset names consist of letters, digits, ".", "_" and
+			"-" and start with a letter or digit. Events are batched and
+			flushed per dataset. Datasets which don't exist are created, if
+			"--create-datasets" is specified. The ingest status of each
+			dataset is printed at the end.
+
+			To keep track of where events come from, "--add-metadata" adds the
+			file and line an event was read from (_source.file, _source.line),
+			the host name (_source.host), the ID of the ingest run
+			(_source.ingest_id) and the version of the CLI
+			(_source.cli_version) to every event, before it is transformed.
+			The ingest ID is random, shared by all events ingested by one
+			invocation and printed at the end, so the events of a single import
+			can be queried or deleted. Line numbers count the lines of the
+			data sent, so for JSON arrays and exports of other tools they are
+			the position of the event, and of CSV the line a record starts at.
+			When following a file, they are counted from the beginning of the
+			file, also after resuming or a rotation. Fields are renamed or
+			omitted using "--metadata-field", e.g. "host=hostname" or "line=".
+
+			Each object is assigned an event timestamp from the "_time" field.
+			If there is no timestamp field Axiom will assign the server side
+			time of reception. If "--timestamp-field", "--timestamp-format" or
+			"--timestamp-timezone" is given, timestamps are parsed on the client
+			side and written to the "_time" field in RFC 3339 format. They are
+			taken from the first of the timestamp fields (default "_time") an
+			event holds. The timestamp format can be configured by specifying a
+			pattern with the reference date:
 
 				Mon Jan 2 15:04:05 -0700 MST 2006
 
@@ -93,14 +393,117 @@ func NewCmd(f *cmdutil.Factory) *cobra.Command {
 			applicable. See the Go reference documentation for examples:
 			https://pkg.go.dev/time#pkg-constants
 
+			If multiple formats are given, they are tried in order. Without a
+			format, a heuristic parser is used. Timestamps which don't specify
+			a time zone are in the one given by "--timestamp-timezone", e.g.
+			"Europe/Berlin" (default UTC). The amount of events whose timestamp
+			can't be parsed is printed at the end.
+
 			For Unix timestamps, leave the timestamp format unspecified and just
 			provide the value as a number. Can be seconds, milliseconds,
-			microseconds or nanoseconds.
+			microseconds or nanoseconds, which is detected by its magnitude.
+
+			Data is sent in batches which are flushed every "--flush-every"
+			interval or once they reach "--batch-bytes" of uncompressed data or
+			hold "--batch-events" events, so every request is bounded in size,
+			no matter how big the input is. Lines are read up to
+			"--max-line-size". Longer lines make ingestion fail, unless
+			"--oversized-lines" is set to "truncate" or "skip" them. Truncated
+			lines are cut at the maximum line size, which makes JSON invalid.
+			The amount of truncated and skipped lines is printed at the end.
+
+			To stay within ingest limits, e.g. when replaying historical logs,
+			ingestion can be throttled to "--max-events-per-second" and
+			"--max-bytes-per-second" of uncompressed data. Batches are capped
+			to one second worth of data and sent as the limits allow. Only a
+			fraction of the events is ingested when specifying "--sample",
+			e.g. 0.1 for every tenth event. Events are sampled randomly, unless
+			"--sample-by" names a field whose value is hashed to decide,
+			so all events sharing the value are either kept or dropped
+			together. Events without the field are sampled randomly. Sampling
+			happens before events are transformed. The amount of dropped
+			events is printed at the end.
+
+			Batches that fail to be ingested because of a transient error (e.g.
+			a server error or a connection reset) are retried with exponential
+			backoff, up to "--max-retries" times and for no longer than
+			"--retry-timeout". Delays requested by the server are honoured.
+
+			When interrupted, reading stops, but everything read so far is
+			flushed within "--shutdown-timeout" and the summary is printed. A
+			second interrupt aborts ingestion immediately.
+
+			On hosts with an unreliable connection, batches that fail to be sent
+			because of a transient error, once all retries are exhausted, or
+			that are still pending when interrupted can be written to the
+			directory given by "--spool-dir" instead of being lost. They are
+			kept as zstd compressed segment files and sent in the order they
+			were written, at the start of the next run, once sending succeeds
+			again or by "axiom ingest drain". Once the spool exceeds
+			"--spool-max-size", the oldest segments are evicted. A spool is
+			drained by one process at a time, others keep writing to it. The
+			batches pending in a spool are shown by "axiom ingest spool status".
+
+			The offset up to which a file has been ingested is persisted to the
+			file given by "--checkpoint-file" after every batch. When ingesting
+			the same file into the same dataset again, ingestion resumes where
+			it left off, so batches already acknowledged by the server are not
+			sent twice when a failed import is rerun.
+
+			Files can be followed, like "tail -F" does, by specifying the
+			"--follow" flag. Truncation and rotation of the files are detected.
+			When following, checkpoints are always kept (by default in the user
+			cache directory), so ingestion resumes where it left off after a
+			restart. Checkpoints are saved at most once per second and can be
+			shared by several processes. Following is not supported for JSON
+			arrays.
+
+			Events rejected by the server are reported and cause a non-zero exit
+			code. To keep them for inspection or later re-ingestion, specify
+			"--failures-file". Each rejected event is appended to that file as a
+			JSON object holding its original line, the source filename, the
+			events timestamp and the error returned by the server. As the server
+			only reports the timestamp of a rejected event, its line is found by
+			that timestamp: It is omitted for events without a timestamp and
+			events sharing a timestamp might be attributed to the wrong line.
+
+			Instead of reading files, a command can be run by specifying
+			"--exec" and passing the command and its arguments after "--". Each
+			line it writes to its standard output and error becomes an event
+			holding the name of the stream in the "stream" field, the process
+			ID in the "pid" field and the command line in the "command" field.
+			Lines holding a JSON object are used as they are, other lines end
+			up in the "message" field, unless a line format or pattern is
+			given. The lines are passed through, too, and limited by
+			"--max-line-size" like lines of files. Once the command has exited,
+			a final event with the "exit" stream holding its exit code in the
+			"exit_code" field and its run time in seconds in the "duration"
+			field is ingested. Signals received are forwarded to the command,
+			except for interrupts sent by the terminal, which it receives
+			anyway, and the exit code of the command is the one of the CLI.
+
+			To check data before ingesting it, specify "--dry-run". The data is
+			read and parsed locally, but nothing is sent to the server. Instead,
+			a report is printed holding the amount of events, the names and
+			types of the fields found in them (nested fields are flattened
+			using dot notation), the events whose timestamp can't be parsed and
+			the total uncompressed and compressed size of the data.
 		`),
 
 		DisableFlagsInUseLine: true,
 
-		Args:              cmdutil.PopulateFromArgs(f, &opts.Dataset),
+		Args: func(cmd *cobra.Command, args []string) error {
+			// Everything after "--" is the command to execute.
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				opts.command, args = args[dash:], args[:dash]
+			}
+
+			// The dataset is optional, if events are routed.
+			if opts.RouteBy != "" && len(args) == 0 {
+				return nil
+			}
+			return cmdutil.PopulateFromArgs(f, &opts.Dataset)(cmd, args)
+		},
 		ValidArgsFunction: cmdutil.DatasetCompletionFunc(f),
 
 		Example: heredoc.Doc(`
@@ -111,28 +514,108 @@ func NewCmd(f *cmdutil.Factory) *cobra.Command {
 			# Pipe the contents of a log generator into a dataset named
 			# "gen-logs". If the length of the data stream is unknown, the
 			# "--flush-every" flag can be tweaked to optimize shipping the data
-			# to the server after the specified duration. This is only valid for
-			# newline delimited JSON.
+			# to the server after the specified duration.
 			$ ./loggen -ndjson | axiom ingest gen-logs
 
+			# Import a set of large files into a dataset named "archive". If
+			# the import fails midway, rerunning the command resumes it without
+			# sending already ingested data again:
+			$ axiom ingest archive -f 2022-*.ndjson --checkpoint-file=archive.checkpoints
+
+			# Continuously ship the lines appended to a logfile to a dataset
+			# named "nginx-logs", surviving log rotation and restarts:
+			$ axiom ingest nginx-logs -f /var/log/nginx/access.log --follow
+
+			# Ship a logfile from a host with an unreliable connection, keeping
+			# batches that fail to be sent to send them later:
+			$ axiom ingest edge-logs -f /var/log/app.log --follow --spool-dir=/var/spool/axiom
+
+			# Check a logfile before ingesting it into a dataset named
+			# "nginx-logs":
+			$ axiom ingest nginx-logs -f nginx-logs.json --dry-run
+
+			# Extract the fields of a nginx access log and take the event time
+			# from the request timestamp:
+			$ axiom ingest nginx-logs -f access.log --pattern='%{NGINX_COMBINED}' --timestamp-field=timestamp --timestamp-format='02/Jan/2006:15:04:05 -0700'
+
+			# Ingest events whose timestamps lack a time zone and are written in
+			# one of two formats:
+			$ axiom ingest app -f app.ndjson --timestamp-field=ts,time --timestamp-format='2006-01-02 15:04:05' --timestamp-format='02.01.2006 15:04' --timestamp-timezone=Europe/Berlin
+
+			# Extract fields using a custom pattern and keep lines which don't
+			# match for inspection:
+			$ axiom ingest app -f app.log --pattern='%{IP:client} %{WORD:method} %{URIPATH:path}' --reject-file=rejected.log
+
+			# Ship the logs of a Java application, keeping the lines of stack
+			# traces in a single event:
+			$ ./app | axiom ingest app --multiline-start='^\d{4}-\d{2}-\d{2}'
+
+			# Tag the events with the environment they originate from and
+			# drop their noisy headers:
+			$ axiom ingest app -f app.ndjson --set env=prod --drop request.headers --cast status=int
+
+			# Make sure emails, IP addresses and secrets never reach Axiom in
+			# cleartext:
+			$ axiom ingest app -f app.ndjson --redact=email,ip --redact-regex='secret=\S+'
+
+			# Only ingest events matching a schema and keep the others for
+			# inspection:
+			$ axiom ingest app -f app.ndjson --schema=event.schema.json --schema-reject-file=invalid.ndjson
+
+			# Ingest the events of each service into a dataset of its own,
+			# creating it if needed, and the ones without a service into a
+			# dataset named "logs-misc":
+			$ axiom ingest -f app.ndjson --route-by=service --route-template='logs-{{.service}}' --route-default=logs-misc --create-datasets
+
+			# Import a set of logfiles, recording the file and line every event
+			# was read from and the ID of the import:
+			$ axiom ingest archive -f 'logs/**/*.log' --add-metadata
+
+			# Run a batch job, ingest its output into a dataset named
+			# "jobs-logs" and exit with its exit code:
+			$ axiom ingest jobs-logs --exec -- ./nightly-job.sh --flag
+
+			# Ingest the syslog messages of a host into a dataset named
+			# "syslog":
+			$ axiom ingest syslog -f /var/log/messages -t=syslog-rfc3164
+
 			# Send a set of gzip compressed logs to a dataset called
 			# "my-logs". The content type is automatically detected. 
 			$ zcat log*.gz | axiom ingest my-logs
 			
 			# Send a set of gzip compressed JSON logs to a dataset called
 			# "my-logs":
-			$ cat log*.json.gz | axiom ingest my-logs -t=json -e=gzip
+			$ cat log*.json.gz | axiom ingest my-logs -e=gzip
 		`),
 
 		Annotations: map[string]string{
 			"IsCore": "true",
 		},
 
-		PreRunE: cmdutil.ChainRunFuncs(
-			cmdutil.AsksForSetup(f, auth.NewLoginCmd(f)),
-			cmdutil.NeedsActiveDeployment(f),
-			cmdutil.NeedsDatasets(f),
-		),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			// Executing a command doesn't read any files, so they aren't
+			// required when running interactively.
+			if opts.Exec {
+				delete(cmd.Flag("file").Annotations, cobra.BashCompOneRequiredFlag)
+			}
+
+			// A dry run doesn't talk to a deployment.
+			if opts.DryRun {
+				return nil
+			}
+			// Datasets are created as needed, if asked for.
+			if opts.CreateDatasets {
+				return cmdutil.ChainRunFuncs(
+					cmdutil.AsksForSetup(f, auth.NewLoginCmd(f)),
+					cmdutil.NeedsActiveDeployment(f),
+				)(cmd, args)
+			}
+			return cmdutil.ChainRunFuncs(
+				cmdutil.AsksForSetup(f, auth.NewLoginCmd(f)),
+				cmdutil.NeedsActiveDeployment(f),
+				cmdutil.NeedsDatasets(f),
+			)(cmd, args)
+		},
 
 		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			// When no files are specified, stdin is the file to use.
@@ -142,37 +625,281 @@ func NewCmd(f *cmdutil.Factory) *cobra.Command {
 
 			// If set, parse content type and content encoding from their string
 			// representation.
-			if opts.ContentType, err = contentTypeFromString(opts.contentType); err != nil && cmd.Flag("content-type").Changed {
+			if opts.Format, err = lineFormatFromString(opts.contentType); err == nil {
+				opts.ContentType = axiom.NDJSON
+			} else if opts.Import, err = importFormatFromString(opts.contentType); err == nil {
+				opts.ContentType = axiom.NDJSON
+			} else if opts.ContentType, err = contentTypeFromString(opts.contentType); err != nil && cmd.Flag("content-type").Changed {
 				return err
-			} else if opts.ContentEncoding, err = contentEncodingFromString(opts.contentEncoding); err != nil && cmd.Flag("content-encoding").Changed {
+			}
+			if opts.ContentEncoding, err = contentEncodingFromString(opts.contentEncoding); err != nil && cmd.Flag("content-encoding").Changed {
 				return err
 			}
 
-			// If the content encoding is set to anything else than "identity",
-			// make sure the content type is set, as well.
-			if opts.ContentEncoding != axiom.Identity && opts.ContentType == 0 {
-				return fmt.Errorf("content encoding set but content type not set")
+			// Compressed files are detected by their magic bytes, unless the
+			// content encoding is explicitly set.
+			opts.detectEncoding = !cmd.Flag("content-encoding").Changed
+
+			if opts.Exec {
+				if len(opts.command) == 0 {
+					return cmdutil.NewFlagErrorf("--exec requires a command after --")
+				} else if cmd.Flag("file").Changed || opts.Follow || opts.DryRun || opts.MultilineStart != "" {
+					return cmdutil.NewFlagErrorf("--exec not valid with --file, --follow, --dry-run or --multiline-start")
+				} else if opts.Import != 0 {
+					return cmdutil.NewFlagErrorf("--exec not valid when content type is %s", opts.Import)
+				}
+			} else if len(opts.command) > 0 {
+				return cmdutil.NewFlagErrorf("a command after -- requires --exec")
+			} else if opts.Filenames, err = expandFilenames(opts.Filenames); err != nil {
+				return err
 			}
 
-			if err := complete(cmd.Context(), opts); err != nil {
-				return err
+			if opts.Parallel < 1 {
+				return cmdutil.NewFlagErrorf("--parallel must be at least 1")
 			}
-			return run(cmd.Context(), opts, cmd.Flag("flush-every").Changed)
+
+			// Following only works on uncompressed files.
+			if opts.Follow {
+				for _, filename := range opts.Filenames {
+					if filename == "-" {
+						return cmdutil.NewFlagErrorf("--follow not valid when reading from stdin")
+					}
+				}
+				if opts.ContentEncoding != axiom.Identity {
+					return cmdutil.NewFlagErrorf("--follow not valid when content encoding is set")
+				} else if opts.Import != 0 {
+					return cmdutil.NewFlagErrorf("--follow not valid when content type is %s", opts.Import)
+				}
+			}
+
+			if opts.Pattern != "" {
+				if cmd.Flag("content-type").Changed && opts.Format != textFormat {
+					return cmdutil.NewFlagErrorf("--pattern not valid when content type is not text")
+				}
+				if opts.pattern, err = compilePattern(opts.Pattern); err != nil {
+					return cmdutil.NewFlagErrorf("invalid --pattern: %s", err)
+				}
+				opts.ContentType, opts.Format = axiom.NDJSON, textFormat
+			} else if opts.RejectFile != "" {
+				return cmdutil.NewFlagErrorf("--reject-file only valid with --pattern")
+			}
+
+			if opts.MultilineStart != "" {
+				if opts.ContentType != 0 && opts.Format == 0 {
+					return cmdutil.NewFlagErrorf("--multiline-start not valid when content type is not line based")
+				}
+				if opts.multilineStart, err = regexp.Compile(opts.MultilineStart); err != nil {
+					return cmdutil.NewFlagErrorf("invalid --multiline-start: %s", err)
+				}
+				if opts.MultilineMaxLines < 0 {
+					return cmdutil.NewFlagErrorf("--multiline-max-lines must not be negative")
+				}
+			}
+
+			if opts.sampler, err = newSampler(opts); err != nil {
+				return cmdutil.NewFlagErrorf("%s", err)
+			} else if opts.sampler != nil {
+				opts.processors = append(opts.processors, opts.sampler.apply)
+			}
+
+			if opts.timestamps, err = newTimestamps(opts); err != nil {
+				return cmdutil.NewFlagErrorf("%s", err)
+			} else if opts.timestamps != nil {
+				opts.processors = append(opts.processors, opts.timestamps.apply)
+			}
+
+			if t, err := newTransform(opts); err != nil {
+				return cmdutil.NewFlagErrorf("%s", err)
+			} else if t != nil {
+				opts.processors = append(opts.processors, t.apply)
+			}
+
+			if opts.redactor, err = newRedactor(opts); err != nil {
+				return cmdutil.NewFlagErrorf("%s", err)
+			} else if opts.redactor != nil {
+				opts.processors = append(opts.processors, opts.redactor.apply)
+			}
+
+			// Events are validated the way they are sent.
+			if cmd.Flag("schema-mode").Changed && opts.Schema == "" {
+				return cmdutil.NewFlagErrorf("--schema-mode only valid with --schema")
+			} else if opts.SchemaRejectFile != "" && opts.Schema == "" {
+				return cmdutil.NewFlagErrorf("--schema-reject-file only valid with --schema")
+			} else if opts.schema, err = newSchemaValidator(opts); err != nil {
+				return cmdutil.NewFlagErrorf("%s", err)
+			} else if opts.schema != nil {
+				opts.processors = append(opts.processors, func(event map[string]any) (map[string]any, error) {
+					return opts.schema.apply(event, opts.schemaRejects)
+				})
+			}
+
+			if opts.router, err = newRouter(opts); err != nil {
+				return cmdutil.NewFlagErrorf("%s", err)
+			}
+			if opts.metadata, err = newMetadata(opts); err != nil {
+				return cmdutil.NewFlagErrorf("%s", err)
+			}
+
+			if opts.CSVSkipRows < 0 {
+				return cmdutil.NewFlagErrorf("--csv-skip-rows must not be negative")
+			} else if _, err = newCSVDecoder(opts, 0); err != nil {
+				return cmdutil.NewFlagErrorf("%s", err)
+			}
+
+			if opts.MaxRetries < 0 {
+				return cmdutil.NewFlagErrorf("--max-retries must not be negative")
+			}
+
+			if opts.BatchBytes == 0 {
+				return cmdutil.NewFlagErrorf("--batch-bytes must be positive")
+			} else if opts.BatchEvents < 0 {
+				return cmdutil.NewFlagErrorf("--batch-events must not be negative")
+			} else if opts.MaxLineSize == 0 {
+				return cmdutil.NewFlagErrorf("--max-line-size must be positive")
+			} else if !contains(validOversizedModes, opts.OversizedLines) {
+				return cmdutil.NewFlagErrorf("invalid --oversized-lines %q: must be one of %s", opts.OversizedLines, strings.Join(validOversizedModes, ", "))
+			}
+
+			if opts.MaxEventsPerSecond < 0 {
+				return cmdutil.NewFlagErrorf("--max-events-per-second must not be negative")
+			}
+			opts.throttle = newThrottle(opts)
+
+			if opts.DryRun {
+				if opts.Follow {
+					return cmdutil.NewFlagErrorf("--follow not valid with --dry-run")
+				} else if opts.SpoolDir != "" {
+					return cmdutil.NewFlagErrorf("--spool-dir not valid with --dry-run")
+				}
+				return dryRun(cmd.Context(), opts)
+			}
+
+			if opts.SpoolDir != "" {
+				if opts.SpoolMaxSize == 0 {
+					return cmdutil.NewFlagErrorf("--spool-max-size must be positive")
+				}
+				if opts.spool, err = openSpool(opts.SpoolDir, int64(opts.SpoolMaxSize)); err != nil {
+					return fmt.Errorf("could not open spool %q: %w", opts.SpoolDir, err)
+				}
+			}
+
+			// Routed events don't need a dataset to be ingested into.
+			if opts.router == nil {
+				if err := complete(cmd.Context(), opts); err != nil {
+					return err
+				}
+			}
+			if opts.Exec {
+				return runExec(cmd.Context(), opts)
+			}
+			return run(cmd.Context(), opts)
 		},
 	}
 
 	cmd.Flags().StringSliceVarP(&opts.Filenames, "file", "f", nil, "File(s) to ingest (- to read from stdin). If stdin is a pipe the default value is -, otherwise this is a required parameter")
-	cmd.Flags().StringVar(&opts.TimestampField, "timestamp-field", "", "Field to take the ingestion time from (defaults to _time)")
-	cmd.Flags().StringVar(&opts.TimestampFormat, "timestamp-format", "", "Format used in the the timestamp field. Default uses a heuristic parser. Must be expressed using the reference time 'Mon Jan 2 15:04:05 -0700 MST 2006'")
+	cmd.Flags().StringSliceVar(&opts.TimestampFields, "timestamp-field", nil, "Fields to take the ingestion time from, the first one present is used (defaults to _time)")
+	cmd.Flags().StringArrayVar(&opts.TimestampFormats, "timestamp-format", nil, "Format used in the the timestamp field, can be repeated to try multiple formats in order. Default uses a heuristic parser. Must be expressed using the reference time 'Mon Jan 2 15:04:05 -0700 MST 2006'")
+	cmd.Flags().StringVar(&opts.TimestampTimezone, "timestamp-timezone", "", "Time zone of timestamps which don't specify one, e.g. Europe/Berlin (defaults to UTC)")
 	cmd.Flags().StringVarP(&opts.Delimiter, "delimiter", "d", "", "Delimiter that separates CSV fields (only valid when input is CSV")
-	cmd.Flags().DurationVar(&opts.FlushEvery, "flush-every", time.Second, "Buffer flush interval for newline delimited JSON streams of unknown length")
-	cmd.Flags().StringVarP(&opts.contentType, "content-type", "t", "", "Content type of the data to ingest (will auto-detect if not set, must be set if content encoding is set and content type is not identity)")
+	cmd.Flags().StringSliceVar(&opts.CSVHeader, "csv-header", nil, "Names of the CSV fields, if the first line is not the header (only valid when input is CSV)")
+	cmd.Flags().IntVar(&opts.CSVSkipRows, "csv-skip-rows", 0, "Amount of lines to skip at the beginning of CSV content (only valid when input is CSV)")
+	cmd.Flags().StringSliceVar(&opts.CSVTypes, "csv-types", nil, "Types of CSV fields, as field=type with type being one of string, int, float, bool or time (only valid when input is CSV)")
+	cmd.Flags().StringVar(&opts.CSVComment, "csv-comment", "", "Prefix of CSV lines to skip (only valid when input is CSV)")
+	cmd.Flags().DurationVar(&opts.FlushEvery, "flush-every", time.Second, "Buffer flush interval for data streams of unknown length")
+	opts.BatchBytes, opts.MaxLineSize = defaultBatchSize, defaultMaxLineSize
+	cmd.Flags().Var(&opts.BatchBytes, "batch-bytes", "Size of uncompressed data after which a batch is sent, regardless of the flush interval (e.g. 5MB)")
+	cmd.Flags().IntVar(&opts.BatchEvents, "batch-events", 0, "Amount of events after which a batch is sent, regardless of the flush interval (0 for no limit)")
+	cmd.Flags().Var(&opts.MaxLineSize, "max-line-size", "Maximum size of a line (e.g. 1MB), longer lines are handled as configured by --oversized-lines")
+	cmd.Flags().StringVar(&opts.OversizedLines, "oversized-lines", oversizedFail, "How to handle lines exceeding the maximum line size: fail, truncate or skip")
+	cmd.Flags().IntVar(&opts.MaxEventsPerSecond, "max-events-per-second", 0, "Maximum amount of events to ingest per second (0 for no limit)")
+	cmd.Flags().Var(&opts.MaxBytesPerSecond, "max-bytes-per-second", "Maximum size of uncompressed data to ingest per second (e.g. 1MB, 0 for no limit)")
+	cmd.Flags().Float64Var(&opts.Sample, "sample", 1, "Fraction of events to ingest, the others are dropped (e.g. 0.1)")
+	cmd.Flags().StringVar(&opts.SampleBy, "sample-by", "", "Field whose value is hashed to decide if an event is sampled, so related events are kept together")
+	cmd.Flags().BoolVar(&opts.Follow, "follow", false, "Keep reading the files as they grow, following truncation and rotation")
+	cmd.Flags().IntVar(&opts.Parallel, "parallel", 1, "Amount of files to ingest concurrently")
+	opts.SpoolMaxSize = defaultSpoolMaxSize
+	cmd.Flags().StringVar(&opts.SpoolDir, "spool-dir", "", "Directory to write batches to which fail to be sent, so they are sent later")
+	cmd.Flags().Var(&opts.SpoolMaxSize, "spool-max-size", "Maximum size of the spool (e.g. 500MB), the oldest batches are evicted once exceeded")
+	cmd.Flags().StringVar(&opts.FailuresFile, "failures-file", "", "File to append events rejected by the server to as newline delimited JSON")
+	cmd.Flags().StringVar(&opts.CheckpointFile, "checkpoint-file", "", "File to persist the offsets of ingested files to, in order to resume ingestion (defaults to a file in the user cache directory when following files)")
+	cmd.Flags().IntVar(&opts.MaxRetries, "max-retries", 5, "Maximum amount of retries of a batch that failed to be ingested because of a transient error")
+	cmd.Flags().DurationVar(&opts.RetryTimeout, "retry-timeout", time.Minute, "Maximum duration to spend on retrying a single batch (0 to only limit by --max-retries)")
+	cmd.Flags().DurationVar(&opts.ShutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "Maximum duration to spend on flushing pending events when interrupted")
+	cmd.Flags().StringVar(&opts.Pattern, "pattern", "", "Grok pattern or regular expression with named capture groups to extract fields from each line")
+	cmd.Flags().StringVar(&opts.RejectFile, "reject-file", "", "File to append lines which don't match the pattern to, instead of ingesting them")
+	cmd.Flags().StringVar(&opts.Schema, "schema", "", "File holding a JSON Schema (draft 2020-12) to validate events against")
+	cmd.Flags().StringVar(&opts.SchemaMode, "schema-mode", schemaStrict, "How to handle events which don't match the schema: strict (reject them) or warn (ingest them)")
+	cmd.Flags().StringVar(&opts.SchemaRejectFile, "schema-reject-file", "", "File to append events which don't match the schema to as newline delimited JSON")
+	cmd.Flags().StringVar(&opts.MultilineStart, "multiline-start", "", "Regular expression matching the first line of an event spanning multiple lines")
+	cmd.Flags().IntVar(&opts.MultilineMaxLines, "multiline-max-lines", 500, "Maximum amount of lines merged into a single event (0 for no limit)")
+	cmd.Flags().DurationVar(&opts.MultilineTimeout, "multiline-timeout", time.Second, "Duration after which an event spanning multiple lines is complete, if no further lines are read")
+	cmd.Flags().StringArrayVar(&opts.Set, "set", nil, "Set a field of every event to a value, given as field=value (can be repeated)")
+	cmd.Flags().StringArrayVar(&opts.Drop, "drop", nil, "Drop a field from every event (can be repeated)")
+	cmd.Flags().StringArrayVar(&opts.Rename, "rename", nil, "Rename a field of every event, given as old=new (can be repeated)")
+	cmd.Flags().StringArrayVar(&opts.Cast, "cast", nil, "Cast a field of every event to int, float, bool or time, given as field=type (can be repeated)")
+	cmd.Flags().BoolVar(&opts.Flatten, "flatten", false, "Flatten nested fields of every event into top-level fields named by joining the names with a dot")
+	cmd.Flags().StringSliceVar(&opts.Redact, "redact", nil, "Built-in rules to redact sensitive values with: email, ip, card or token")
+	cmd.Flags().StringArrayVar(&opts.RedactRegex, "redact-regex", nil, "Regular expression matching sensitive values to redact (can be repeated)")
+	cmd.Flags().StringVar(&opts.RedactMode, "redact-mode", "", "Mode of redaction: mask, hash (salted SHA-256) or drop the field (defaults to mask)")
+	cmd.Flags().StringVar(&opts.RedactSalt, "redact-salt", "", "Salt to prepend to redacted values before they are hashed")
+	cmd.Flags().StringVar(&opts.RedactProfile, "redact-profile", "", "Name of a redaction in the configuration file to load rules from")
+	cmd.Flags().StringVar(&opts.RouteBy, "route-by", "", "Field whose value determines the dataset an event is ingested into")
+	cmd.Flags().StringVar(&opts.RouteTemplate, "route-template", "", "Go template building the name of the dataset to route an event to (e.g. logs-{{.service}})")
+	cmd.Flags().StringVar(&opts.RouteDefault, "route-default", "", "Dataset to ingest events into which can't be routed (defaults to the dataset given as argument)")
+	cmd.Flags().BoolVar(&opts.CreateDatasets, "create-datasets", false, "Create the datasets events are routed to, if they don't exist")
+	cmd.Flags().BoolVar(&opts.AddMetadata, "add-metadata", false, "Add the source file, line, host, ingest ID and CLI version to every event")
+	cmd.Flags().StringArrayVar(&opts.MetadataFields, "metadata-field", nil, "Name of a metadata field: file, line, host, ingest_id or version, given as field=name, empty to omit it (can be repeated)")
+	cmd.Flags().BoolVar(&opts.Exec, "exec", false, "Run the command given after -- and ingest the lines it writes to its standard output and error")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Validate the data and print a report about it without ingesting it")
+	cmd.Flags().StringVarP(&opts.contentType, "content-type", "t", "", "Content type of the data to ingest (will auto-detect if not set)")
 	cmd.Flags().StringVarP(&opts.contentEncoding, "content-encoding", "e", axiom.Identity.String(), "Content encoding of the data to ingest")
 
 	_ = cmd.RegisterFlagCompletionFunc("timestamp-field", cmdutil.NoCompletion)
 	_ = cmd.RegisterFlagCompletionFunc("timestamp-format", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("timestamp-timezone", cmdutil.NoCompletion)
 	_ = cmd.RegisterFlagCompletionFunc("delimiter", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("csv-header", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("csv-skip-rows", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("csv-types", csvTypesCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("csv-comment", cmdutil.NoCompletion)
 	_ = cmd.RegisterFlagCompletionFunc("flush-every", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("batch-bytes", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("batch-events", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("max-line-size", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("oversized-lines", oversizedLinesCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("schema-mode", schemaModeCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("max-events-per-second", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("max-bytes-per-second", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("sample", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("sample-by", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("follow", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("parallel", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("max-retries", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("retry-timeout", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("shutdown-timeout", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("spool-max-size", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("exec", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("dry-run", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("pattern", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("multiline-start", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("multiline-max-lines", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("multiline-timeout", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("set", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("drop", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("rename", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("cast", castCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("flatten", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("redact", redactCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("redact-regex", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("redact-mode", redactModeCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("redact-salt", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("redact-profile", redactProfileCompletion(f))
+	_ = cmd.RegisterFlagCompletionFunc("route-by", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("route-template", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("route-default", cmdutil.DatasetCompletionFunc(f))
+	_ = cmd.RegisterFlagCompletionFunc("create-datasets", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("add-metadata", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("metadata-field", metadataFieldCompletion)
 	_ = cmd.RegisterFlagCompletionFunc("content-type", contentTypeCompletion)
 	_ = cmd.RegisterFlagCompletionFunc("content-encoding", contentEncodingCompletion)
 
@@ -180,6 +907,13 @@ func NewCmd(f *cmdutil.Factory) *cobra.Command {
 		_ = cmd.MarkFlagRequired("file")
 	}
 
+	cmd.AddCommand(newServeCmd(f))
+	cmd.AddCommand(newSyslogServerCmd(f))
+	cmd.AddCommand(newOTLPServerCmd(f))
+	cmd.AddCommand(newScrapeCmd(f))
+	cmd.AddCommand(newDrainCmd(f))
+	cmd.AddCommand(newSpoolCmd(f))
+
 	return cmd
 }
 
@@ -223,204 +957,979 @@ func complete(ctx context.Context, opts *options) error {
 	}, &opts.Dataset, opts.IO.SurveyIO())
 }
 
-func run(ctx context.Context, opts *options, flushEverySet bool) error {
+func run(ctx context.Context, opts *options) error {
 	client, err := opts.Client(ctx)
 	if err != nil {
 		return err
 	}
 
-	stop := opts.IO.StartActivityIndicator()
+	if opts.FailuresFile != "" {
+		if opts.failures, err = openFailuresFile(opts.FailuresFile); err != nil {
+			return err
+		}
+		defer opts.failures.Close()
+	}
+
+	if opts.RejectFile != "" {
+		if opts.rejects, err = openRejectsFile(opts.RejectFile); err != nil {
+			return err
+		}
+		defer opts.rejects.Close()
+	}
+
+	if opts.SchemaRejectFile != "" {
+		if opts.schemaRejects, err = openRejectsFile(opts.SchemaRejectFile); err != nil {
+			return err
+		}
+		defer opts.schemaRejects.Close()
+	}
+
+	// Checkpoints are always kept when following files. Otherwise they are
+	// only kept if explicitly asked for.
+	var cps *checkpoints
+	if opts.Follow || opts.CheckpointFile != "" {
+		checkpointFile := opts.CheckpointFile
+		if checkpointFile == "" {
+			checkpointFile = defaultCheckpointFile()
+		}
+		if cps, err = loadCheckpoints(checkpointFile); err != nil {
+			return fmt.Errorf("could not load checkpoints from %q: %w", checkpointFile, err)
+		}
+	}
+
+	files := make([]*fileProgress, len(opts.Filenames))
+	for i, filename := range opts.Filenames {
+		files[i] = newFileProgress(filename)
+	}
+
+	// Batches spooled by previous runs are sent first, so they are ingested
+	// in order.
+	opts.spool.tryDrain(ctx, client, opts)
+
+	// The progress of each file is displayed, unless following them.
+	var stop func()
+	if opts.IO.IsStderrTTY() && !opts.Follow {
+		stop = startProgress(opts.IO, files)
+	} else {
+		stop = opts.IO.StartActivityIndicator()
+	}
 	defer stop()
 
 	var (
 		res     = new(axiom.IngestStatus)
 		lastErr error
 	)
-	for _, filename := range opts.Filenames {
-		var rc io.ReadCloser
-		if filename == "-" {
-			rc = opts.IO.In()
-			filename = "stdin" // Enhance printed output
-		} else {
-			if rc, err = os.Open(filename); err != nil {
-				lastErr = err
-				break
-			}
-		}
+	if opts.Follow {
+		res, lastErr = follow(ctx, client, cps, opts)
+	} else {
+		res, lastErr = ingestFiles(ctx, client, files, cps, opts)
+	}
 
-		var (
-			r   io.Reader
-			typ axiom.ContentType
-		)
-		if opts.ContentEncoding == axiom.Identity && opts.ContentType == 0 {
-			if r, typ, err = axiom.DetectContentType(rc); err != nil {
-				_ = rc.Close()
-				lastErr = fmt.Errorf("could not detect %q content type: %w", filename, err)
-				break
-			}
-		} else {
-			r = rc
-			typ = opts.ContentType
-		}
+	if err := cps.flush(); err != nil && lastErr == nil {
+		lastErr = fmt.Errorf("could not save checkpoints: %w", err)
+	}
 
-		if flushEverySet && typ != axiom.NDJSON {
-			return cmdutil.NewFlagErrorf("--flush-every not valid when content type is not newline delimited JSON")
-		}
-		if opts.Delimiter != "" && typ != axiom.CSV {
-			return cmdutil.NewFlagErrorf("--delimier/-d not valid when content type is not CSV")
-		}
+	stop()
 
-		var ingestRes *axiom.IngestStatus
-		if filename == "stdin" && typ == axiom.NDJSON {
-			ingestRes, err = ingestEvery(ctx, client, r, opts)
-		} else {
-			ingestRes, err = ingest(ctx, client, r, typ, opts)
-		}
-		mergeIngestStatuses(res, ingestRes)
+	cs := opts.IO.ColorScheme()
 
-		if err != nil && !errors.Is(err, context.Canceled) {
-			_ = rc.Close()
-			lastErr = fmt.Errorf("could not ingest %q into dataset %q: %w", filename, opts.Dataset, err)
-			break
-		} else if err = rc.Close(); err != nil {
-			lastErr = fmt.Errorf("failed to close %q: %w", filename, err)
-			break
-		} else if errors.Is(err, context.Canceled) {
-			break
+	if len(files) > 1 && !opts.Follow {
+		if err := printFilesSummary(opts, files); err != nil {
+			return err
 		}
 	}
 
-	stop()
+	opts.sampler.printSummary(opts.IO)
 
-	if opts.IO.IsStderrTTY() {
-		cs := opts.IO.ColorScheme()
+	if opts.redactor != nil {
+		opts.redactor.printSummary(opts.IO)
+	}
 
-		if res.Ingested > 0 {
-			fmt.Fprintf(opts.IO.ErrOut(), "%s Ingested %s (%s)\n",
-				cs.SuccessIcon(),
-				utils.Pluralize(cs, "event", int(res.Ingested)),
-				humanize.Bytes(res.ProcessedBytes),
-			)
+	if opts.router != nil {
+		if err := opts.router.printSummary(opts); err != nil {
+			return err
 		}
+	}
+
+	if opts.IO.IsStderrTTY() && res.Ingested > 0 {
+		fmt.Fprintf(opts.IO.ErrOut(), "%s Ingested %s (%s)\n",
+			cs.SuccessIcon(),
+			utils.Pluralize(cs, "event", int(res.Ingested)),
+			humanize.Bytes(res.ProcessedBytes),
+		)
+	}
+
+	opts.metadata.printSummary(opts.IO)
+	opts.timestamps.printSummary(opts.IO)
+
+	if opts.rejects != nil && opts.rejects.count() > 0 {
+		fmt.Fprintf(opts.IO.ErrOut(), "%s Wrote %s not matching the pattern to %s\n",
+			cs.WarningIcon(),
+			utils.Pluralize(cs, "line", opts.rejects.count()),
+			cs.Bold(opts.RejectFile),
+		)
+	}
+
+	opts.oversized.printSummary(opts.IO)
+	opts.invalid.printSummary(opts.IO)
+
+	if opts.schema.printSummary(opts.IO, opts.SchemaRejectFile) && lastErr == nil {
+		lastErr = cmdutil.ErrSilent
+	}
+
+	if opts.spool.printSummary(opts.IO) && lastErr == nil {
+		lastErr = cmdutil.ErrSilent
+	}
 
-		if res.Failed > 0 {
+	// Failures are always reported, so they don't go unnoticed when running
+	// non-interactively.
+	if res.Failed > 0 {
+		if opts.failures != nil {
+			fmt.Fprintf(opts.IO.ErrOut(), "%s Failed to ingest %s, wrote %s to %s\n",
+				cs.ErrorIcon(),
+				utils.Pluralize(cs, "event", int(res.Failed)),
+				utils.Pluralize(cs, "failure", opts.failures.count()),
+				cs.Bold(opts.FailuresFile),
+			)
+		} else {
 			fmt.Fprintf(opts.IO.ErrOut(), "%s Failed to ingest %s:\n\n",
 				cs.ErrorIcon(),
 				utils.Pluralize(cs, "event", int(res.Failed)),
 			)
 			for _, fail := range res.Failures {
 				fmt.Fprintf(opts.IO.ErrOut(), "%s: %s\n",
-					cs.Gray(fail.Timestamp.Format(time.RFC1123)), err,
+					cs.Gray(fail.Timestamp.Format(time.RFC1123)), fail.Error,
 				)
 			}
 		}
+
+		if lastErr == nil {
+			lastErr = cmdutil.ErrSilent
+		}
 	}
 
 	return lastErr
 }
 
-func ingestEvery(ctx context.Context, client *axiom.Client, r io.Reader, opts *options) (*axiom.IngestStatus, error) {
-	t := time.NewTicker(opts.FlushEvery)
-	defer t.Stop()
+// dryRun reads the configured files and prints a report about the data,
+// without ingesting it. Checkpoints are neither honoured nor updated.
+func dryRun(ctx context.Context, opts *options) error {
+	opts.report = newDryRunReport(opts.timestamps)
 
-	readers := make(chan io.Reader)
+	stop := opts.IO.StartActivityIndicator()
+	defer stop()
 
-	go func() {
-		defer close(readers)
-
-		// Add first reader.
-		pr, pw := io.Pipe()
-		readers <- pr
-
-		scanner := bufio.NewScanner(r)
-		// Start with a 64 byte buffer, check up until 1 MB per line.
-		scanner.Buffer(make([]byte, 64), 1024*1024)
-		scanner.Split(splitLinesMulti)
-
-		// We need to scan in a go func to make sure we don't block on
-		// `scanner.Scan()`.
-		done := make(chan struct{})
-		lines := make(chan []byte)
-		defer close(lines)
+	for _, filename := range opts.Filenames {
+		// The report covers what has been read, if interrupted.
+		if _, err := ingestFile(ctx, nil, filename, nil, nil, opts); errors.Is(err, context.Canceled) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	stop()
+
+	opts.sampler.printSummary(opts.IO)
+	if opts.redactor != nil {
+		opts.redactor.printSummary(opts.IO)
+	}
+	opts.timestamps.printSummary(opts.IO)
+	opts.oversized.printSummary(opts.IO)
+	opts.invalid.printSummary(opts.IO)
+	failed := opts.schema.printSummary(opts.IO, "")
+
+	if err := opts.report.print(opts); err != nil {
+		return err
+	} else if failed {
+		return cmdutil.ErrSilent
+	}
+	return nil
+}
+
+// ingestFiles ingests the files, as many at a time as configured. Once
+// ingesting a file fails, no further files are started.
+func ingestFiles(ctx context.Context, client *axiom.Client, files []*fileProgress, cps *checkpoints, opts *options) (*axiom.IngestStatus, error) {
+	var (
+		res     = new(axiom.IngestStatus)
+		lastErr error
+		failed  bool
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		next    = make(chan *fileProgress)
+	)
+
+	parallel := opts.Parallel
+	if parallel > len(files) {
+		parallel = len(files)
+	}
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
 		go func() {
-			for {
-				select {
-				case <-ctx.Done():
-					_ = pw.CloseWithError(ctx.Err())
-					return
-				default:
+			defer wg.Done()
+
+			for p := range next {
+				p.start()
+				ingestRes, err := ingestFile(ctx, client, p.filename, cps, p, opts)
+				p.finish(ingestRes, err)
+
+				mu.Lock()
+				mergeIngestStatuses(res, ingestRes)
+				if err != nil {
+					if !errors.Is(err, context.Canceled) && lastErr == nil {
+						lastErr = err
+					}
+					failed = true
 				}
+				mu.Unlock()
+			}
+		}()
+	}
 
-				if !scanner.Scan() {
-					close(done)
-					return
-				}
+	for _, p := range files {
+		mu.Lock()
+		stop := failed
+		mu.Unlock()
+
+		if stop {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case next <- p:
+			continue
+		}
+		break
+	}
+	close(next)
+	wg.Wait()
+
+	return res, lastErr
+}
 
-				line := make([]byte, len(scanner.Bytes()))
-				copy(line, scanner.Bytes())
-				lines <- line
+// ingestFile ingests the named file, or stdin if the filename is "-". If
+// checkpoints are given, ingestion resumes after the data of the file that has
+// already been ingested and the checkpoint of the file is updated after every
+// batch. The progress of ingesting the file is tracked, if not nil.
+func ingestFile(ctx context.Context, client *axiom.Client, filename string, cps *checkpoints, progress *fileProgress, opts *options) (*axiom.IngestStatus, error) {
+	var (
+		f   *os.File
+		rc  io.ReadCloser
+		err error
+	)
+	if filename == "-" {
+		rc = opts.IO.In()
+		filename = "stdin" // Enhance printed output
+	} else {
+		if f, err = os.Open(filename); err != nil {
+			return nil, err
+		}
+		rc = f
+	}
+	defer rc.Close()
+
+	var (
+		r   io.Reader = progressReader{Reader: rc, progress: progress}
+		enc           = opts.ContentEncoding
+	)
+	if opts.detectEncoding {
+		if r, enc, err = detectContentEncoding(r); err != nil {
+			return nil, fmt.Errorf("could not read %q: %w", filename, err)
+		}
+	}
+
+	dec, err := decodeContent(r, enc)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %q: %w", filename, err)
+	}
+	defer dec.Close()
+
+	r, typ, format, err := detectContentType(dec, filename, opts)
+	if err != nil {
+		return nil, err
+	} else if err = validateContentType(typ, opts); err != nil {
+		return nil, err
+	}
+
+	src := &source{
+		name:     filename,
+		r:        r,
+		typ:      typ,
+		format:   format,
+		progress: progress,
+	}
+
+	// Track and resume the progress of regular files, if asked for.
+	if cps != nil && f != nil {
+		absFilename, err := filepath.Abs(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		if cp, ok := cps.get(opts.Dataset, absFilename); ok {
+			if ok, err = matchesCheckpoint(f, cp); err != nil {
+				return nil, err
+			} else if ok {
+				src.skip = cp.Offset
 			}
-		}()
+		}
+
+		src.commit = func(n int64) error {
+			cp, err := fileCheckpoint(f)
+			if err != nil {
+				return err
+			}
+			cp.Offset = n
+			return cps.set(opts.Dataset, absFilename, cp)
+		}
+	}
+
+	res, err := ingestEvery(ctx, client, src, opts)
+	if err != nil && opts.report != nil {
+		return res, fmt.Errorf("could not read %q: %w", filename, err)
+	} else if err != nil {
+		return res, ingestError(filename, opts, err)
+	}
+
+	return res, nil
+}
+
+// ingestError wraps an error that occurred while ingesting the named file. If
+// events are routed, the error names the dataset itself.
+func ingestError(filename string, opts *options, err error) error {
+	if opts.router != nil {
+		return fmt.Errorf("could not ingest %q: %w", filename, err)
+	}
+	return fmt.Errorf("could not ingest %q into dataset %q: %w", filename, opts.Dataset, err)
+}
+
+// detectContentType returns the content type and line format of the data read
+// from r, if they are not explicitly configured. The returned io.Reader must be
+// used instead of the passed one.
+func detectContentType(r io.Reader, filename string, opts *options) (io.Reader, axiom.ContentType, lineFormat, error) {
+	if opts.ContentType != 0 {
+		return r, opts.ContentType, opts.Format, nil
+	}
+
+	r, typ, format, err := sniffContentType(r, opts.Delimiter)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("could not detect %q content type: %w", filename, err)
+	}
+
+	// Merging lines requires a line based format.
+	if opts.multilineStart != nil && format == 0 {
+		typ, format = axiom.NDJSON, textFormat
+	}
+
+	return r, typ, format, nil
+}
+
+// validateContentType makes sure the given content type is compatible with the
+// configured options.
+func validateContentType(typ axiom.ContentType, opts *options) error {
+	if opts.Follow && typ == axiom.JSON {
+		return cmdutil.NewFlagErrorf("--follow not valid when content type is JSON")
+	}
+	if opts.Delimiter != "" && typ != axiom.CSV {
+		return cmdutil.NewFlagErrorf("--delimier/-d not valid when content type is not CSV")
+	}
+	csvFlags := len(opts.CSVHeader) > 0 || opts.CSVSkipRows > 0 || len(opts.CSVTypes) > 0 || opts.CSVComment != ""
+	if csvFlags && typ != axiom.CSV {
+		return cmdutil.NewFlagErrorf("--csv-header, --csv-skip-rows, --csv-types and --csv-comment not valid when content type is not CSV")
+	}
+	// When resuming, the first line read is replayed as the header.
+	if opts.Follow && (opts.CSVSkipRows > 0 || opts.CSVComment != "") {
+		return cmdutil.NewFlagErrorf("--follow not valid with --csv-skip-rows or --csv-comment")
+	}
+	return nil
+}
+
+// follow ingests the configured files concurrently, following them until the
+// context is canceled or ingesting one of them fails.
+func follow(ctx context.Context, client *axiom.Client, cps *checkpoints, opts *options) (*axiom.IngestStatus, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		res     = new(axiom.IngestStatus)
+		lastErr error
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	for _, filename := range opts.Filenames {
+		wg.Add(1)
+		go func(filename string) {
+			defer wg.Done()
+
+			ingestRes, err := followFile(ctx, client, filename, cps, opts)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			mergeIngestStatuses(res, ingestRes)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				lastErr = err
+				cancel()
+			}
+		}(filename)
+	}
+	wg.Wait()
+
+	return res, lastErr
+}
+
+// followFile ingests the named file, following it until the context is
+// canceled. The checkpoint of the file is updated after every flush.
+func followFile(ctx context.Context, client *axiom.Client, filename string, cps *checkpoints, opts *options) (*axiom.IngestStatus, error) {
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var cp *checkpoint
+	if c, ok := cps.get(opts.Dataset, absFilename); ok {
+		cp = &c
+	}
+
+	// The content type is detected from the beginning of the file, which might
+	// not be where ingestion resumes.
+	typ, format := opts.ContentType, opts.Format
+	if typ == 0 {
+		fr, err := newFollowReader(ctx, absFilename, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		_, typ, format, err = detectContentType(fr, filename, opts)
+		if closeErr := fr.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = validateContentType(typ, opts); err != nil {
+		return nil, err
+	}
+
+	// Without a header line, there is nothing to replay when resuming.
+	fr, err := newFollowReader(ctx, absFilename, cp, typ == axiom.CSV && len(opts.CSVHeader) == 0)
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+
+	res, err := ingestEvery(ctx, client, &source{
+		name:   filename,
+		r:      fr,
+		typ:    typ,
+		format: format,
+		line:   fr.line,
+		commit: func(n int64) error {
+			cp, ok, err := fr.checkpoint(n)
+			if err != nil || !ok {
+				return err
+			}
+			return cps.set(opts.Dataset, absFilename, cp)
+		},
+	}, opts)
+	if err != nil {
+		return res, ingestError(filename, opts, err)
+	}
+
+	return res, nil
+}
+
+// source is data to ingest.
+type source struct {
+	// name of the source, used for reporting.
+	name string
+	// r is the data to ingest.
+	r io.Reader
+	// typ is the content type of the data.
+	typ axiom.ContentType
+	// format, if set, is the line format the data is converted from to
+	// newline delimited JSON.
+	format lineFormat
+	// skip is the amount of bytes at the beginning of the data that have
+	// already been ingested and must not be sent again.
+	skip int64
+	// commit, if not nil, is called with the amount of bytes read from r that
+	// have been ingested after every successfully ingested batch.
+	commit func(n int64) error
+	// line, if not nil, maps the number of a line read from r to its number
+	// in the file it was read from.
+	line func(n int) int
+	// progress, if not nil, tracks the events read.
+	progress *fileProgress
+}
+
+// ingestEvery ingests the newline delimited data of the source in batches,
+// flushing them every configured interval or once they reach the maximum batch
+// size. JSON arrays, CSV, line formats and exports of other tools are converted
+// to newline delimited JSON. If events are routed, they are batched per
+// dataset.
+func ingestEvery(ctx context.Context, client *axiom.Client, src *source, opts *options) (*axiom.IngestStatus, error) {
+	t := time.NewTicker(opts.FlushEvery)
+	defer t.Stop()
+
+	// Sending outlives the context, so everything read is flushed once
+	// ingestion is stopped.
+	sendCtx, cancelSend := cmdutil.ShutdownContext(ctx, opts.ShutdownTimeout)
+	defer cancelSend()
+
+	r, typ := src.r, src.typ
+	if opts.Import != 0 {
+		r = opts.Import.toNDJSON(r, opts)
+	} else if typ == axiom.JSON {
+		r, typ = jsonArrayToNDJSON(r), axiom.NDJSON
+	}
 
+	lr := newLineReader(r, opts)
+
+	// We need to read in a go func to make sure we don't block on reading.
+	var (
+		chunks  = make(chan lineChunk)
+		stopped = make(chan struct{})
+		readErr error
+	)
+	defer close(stopped)
+	go func() {
+		defer close(chunks)
 		for {
-			select {
-			case <-ctx.Done():
-				_ = pw.CloseWithError(ctx.Err())
-				return
-			case <-t.C:
-				if err := pw.Close(); err != nil {
+			chunk, err := lr.next()
+			if chunk.n > 0 {
+				select {
+				case <-stopped:
 					return
+				case chunks <- chunk:
 				}
-
-				pr, pw = io.Pipe()
-				readers <- pr
-			case line := <-lines:
-				if _, err := pw.Write(line); err != nil {
-					_ = pw.CloseWithError(err)
-					return
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					readErr = err
 				}
-			case <-done:
-				_ = pw.Close()
 				return
 			}
 		}
 	}()
 
-	res := new(axiom.IngestStatus)
-	for r := range readers {
-		ingestRes, err := ingest(ctx, client, r, axiom.NDJSON, opts)
+	// Line formats are converted to newline delimited JSON line by line.
+	parse := lineParser(src.format, opts)
+
+	// Events are decoded and re-encoded as newline delimited JSON, if they need
+	// to be processed or routed. Lines which can't be decoded are skipped, so
+	// a single invalid line doesn't stop ingestion.
+	decode := len(opts.processors) > 0 || opts.router != nil || opts.metadata != nil
+	skipInvalid := parse == nil && typ == axiom.NDJSON && decode
+	if skipInvalid {
+		parse = decodeEvent
+	}
+
+	// CSV records are always decoded, so values are sent with their type.
+	var csvDec *csvDecoder
+	if typ == axiom.CSV {
+		var err error
+		if csvDec, err = newCSVDecoder(opts, src.skip); err != nil {
+			return nil, err
+		}
+	}
+
+	// Continuation lines are merged into the preceding event, if configured.
+	var ml *multiline
+	if parse != nil && opts.multilineStart != nil {
+		ml = newMultiline(opts)
+	}
+
+	var (
+		res    = new(axiom.IngestStatus)
+		bufs   = newBatches()
+		counts = make(map[string]int)
+		read   int64
+		// lines is the amount of lines read.
+		lines int
+
+		flushDataset func(dataset string) error
+	)
+
+	// added counts an event added to the batch of the dataset and sends the
+	// batch, once it reached the configured limits.
+	added := func(dataset string) error {
+		src.progress.event()
+		counts[dataset]++
+		if !opts.batchFull(bufs.get(dataset).Len(), counts[dataset]) {
+			return nil
+		}
+		return flushDataset(dataset)
+	}
+
+	// emit processes the event read from the given line and buffers it for
+	// the dataset it is routed to.
+	emit := func(event map[string]any, line int) error {
+		if src.line != nil {
+			line = src.line(line)
+		}
+		opts.metadata.apply(event, src.name, line)
+
+		event, err := processEvent(event, opts.processors)
 		if err != nil {
-			return res, err
+			return err
+		} else if event == nil {
+			src.progress.drop()
+			return nil
+		}
+
+		dataset := opts.Dataset
+		if opts.router != nil {
+			dataset = opts.router.route(event)
+		}
+		if err = appendEvent(bufs.get(dataset), event); err != nil {
+			return err
+		}
+		return added(dataset)
+	}
+
+	// write buffers the lines as they are.
+	write := func(data []byte) error {
+		buf := bufs.get(opts.Dataset)
+		for len(data) > 0 {
+			line := data
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				line = data[:i+1]
+			}
+			data = data[len(line):]
+
+			_, _ = buf.Write(line)
+			if err := added(opts.Dataset); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	emitLine := func(line string, number int) error {
+		event, err := parse(line)
+		if err != nil && skipInvalid {
+			opts.invalid.add(fmt.Errorf("line %d of %q: %w", number, src.name, err))
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("line %q: %w", line, err)
+		} else if event == nil {
+			return nil
+		}
+		return emit(event, number)
+	}
+
+	// convert converts the lines read at the given offset to newline delimited
+	// JSON and buffers them.
+	convert := func(data []byte, offset int64) error {
+		if csvDec != nil {
+			return csvDec.decode(data, offset, emit)
+		}
+
+		for len(data) > 0 {
+			line := data
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				line = data[:i+1]
+			}
+			data = data[len(line):]
+			lines++
+
+			s := strings.TrimRight(string(line), "\r\n")
+			if ml != nil {
+				for _, event := range ml.add(s, offset, lines) {
+					if err := emitLine(event.text, event.line); err != nil {
+						return err
+					}
+				}
+			} else if strings.TrimSpace(s) != "" {
+				if err := emitLine(s, lines); err != nil {
+					return err
+				}
+			}
+			offset += int64(len(line))
+		}
+		return nil
+	}
+
+	// flushMultiline buffers the pending multiline event.
+	flushMultiline := func() error {
+		if _, ok := ml.pending(); !ok {
+			return nil
+		}
+		event := ml.flush()
+		return emitLine(event.text, event.line)
+	}
+
+	// flushDataset sends the batch buffered for the dataset.
+	flushDataset = func(dataset string) error {
+		buf := bufs.get(dataset)
+		if buf.Len() == 0 {
+			return nil
+		}
+		events := counts[dataset]
+		counts[dataset] = 0
+
+		batch, batchTyp := buf.Bytes(), typ
+		if csvDec != nil {
+			batchTyp = axiom.NDJSON
+		}
+
+		if opts.report != nil {
+			err := opts.report.add(src.name, batch)
+			buf.Reset()
+			return err
+		}
+
+		if err := opts.throttle.wait(sendCtx, events, len(batch)); err != nil {
+			return err
+		}
+
+		var (
+			ingestRes *axiom.IngestStatus
+			err       error
+		)
+		if opts.router != nil {
+			ingestRes, err = opts.router.ingest(sendCtx, client, dataset, batch, batchTyp, opts)
+		} else {
+			ingestRes, err = ingestBatch(sendCtx, client, dataset, batch, batchTyp, opts)
+		}
+		if err != nil && opts.spool != nil && spoolable(err) {
+			// The batch is sent later, so reading goes on.
+			if err = opts.spool.write(dataset, batch, batchTyp, events, opts); err != nil {
+				return fmt.Errorf("could not spool batch: %w", err)
+			}
+			buf.Reset()
+			return nil
+		} else if err != nil {
+			return err
 		}
 		mergeIngestStatuses(res, ingestRes)
+		opts.spool.tryDrain(sendCtx, client, opts)
+
+		if opts.failures != nil {
+			if err = opts.failures.write(src.name, batch, ingestRes.Failures); err != nil {
+				return err
+			}
+		}
+		buf.Reset()
+
+		return nil
 	}
 
-	return res, nil
+	// commit persists the progress, once everything read has been ingested.
+	commit := func() error {
+		if src.commit == nil || !bufs.empty() {
+			return nil
+		}
+
+		// Lines of a pending multiline event have been read but not yet
+		// ingested.
+		if ml != nil {
+			if offset, ok := ml.pending(); ok {
+				return src.commit(offset)
+			}
+		}
+		// So have the lines of an incomplete CSV record.
+		if csvDec != nil {
+			return src.commit(read - int64(csvDec.pending()))
+		}
+		return src.commit(read)
+	}
+
+	// flush sends the batches of all datasets.
+	flush := func() error {
+		for _, dataset := range bufs.datasets() {
+			if err := flushDataset(dataset); err != nil {
+				return err
+			}
+		}
+		return commit()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Stop reading, but send what has been read.
+			var err error
+			if ml != nil {
+				err = flushMultiline()
+			}
+			if err == nil {
+				err = flush()
+			}
+			return res, shutdownError(ctx, opts, err)
+		case now := <-t.C:
+			if ml != nil && ml.expired(now) {
+				if err := flushMultiline(); err != nil {
+					return res, err
+				}
+			}
+			if err := flush(); err != nil {
+				return res, err
+			}
+		case chunk, ok := <-chunks:
+			if !ok {
+				if ml != nil {
+					if err := flushMultiline(); err != nil {
+						return res, err
+					}
+				}
+				if csvDec != nil && readErr == nil {
+					if err := csvDec.flush(emit); err != nil {
+						return res, err
+					}
+				}
+				if err := flush(); err != nil {
+					return res, err
+				}
+				return res, readErr
+			}
+			data, start := chunk.data, read
+			read += chunk.n
+
+			// Skip what has already been ingested, but count its lines. CSV
+			// records are skipped by the decoder, which needs to see the
+			// header.
+			if start < src.skip && csvDec == nil {
+				if read <= src.skip {
+					lines += bytes.Count(data, []byte{'\n'})
+					continue
+				}
+				lines += bytes.Count(data[:src.skip-start], []byte{'\n'})
+				data = data[src.skip-start:]
+				start = src.skip
+			}
+
+			var err error
+			if parse == nil && csvDec == nil {
+				err = write(data)
+			} else {
+				err = convert(data, start)
+			}
+			if err != nil {
+				return res, err
+			} else if err = commit(); err != nil {
+				return res, err
+			}
+		}
+	}
 }
 
-func ingest(ctx context.Context, client *axiom.Client, r io.Reader, typ axiom.ContentType, opts *options) (*axiom.IngestStatus, error) {
-	// If the data to ingest is not compressed, it gets zstd compressed.
-	enc := opts.ContentEncoding
-	if enc == axiom.Identity {
-		var err error
-		if r, err = axiom.ZstdEncoder(r); err != nil {
-			return nil, err
+// shutdownError returns the error of flushing pending events once ingestion
+// has been stopped by canceling the context.
+func shutdownError(ctx context.Context, opts *options, err error) error {
+	switch {
+	case err == nil:
+		return ctx.Err()
+	case cmdutil.AbortContext(ctx).Err() != nil:
+		return errors.New("aborted before pending events were sent")
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("pending events not sent within the shutdown timeout of %s", opts.ShutdownTimeout)
+	}
+	return err
+}
+
+// lineParser returns the function that turns a line into an event, if lines
+// are parsed on the client side using the configured pattern or the given line
+// format. Otherwise, it returns nil. The function returns a nil event, if the
+// line is rejected.
+func lineParser(format lineFormat, opts *options) func(line string) (map[string]any, error) {
+	switch {
+	case opts.pattern != nil:
+		return func(line string) (map[string]any, error) {
+			if event, ok := opts.pattern.match(line); ok {
+				return event, nil
+			} else if opts.rejects != nil {
+				return nil, opts.rejects.write(line)
+			}
+			return map[string]any{unparsedField: line}, nil
+		}
+	case format != 0:
+		return func(line string) (map[string]any, error) {
+			return format.parse(line), nil
 		}
-		enc = axiom.Zstd
-	} else {
-		r = io.NopCloser(r)
 	}
+	return nil
+}
 
-	res, err := client.Datasets.Ingest(ctx, opts.Dataset, r, typ, enc, axiom.IngestOptions{
-		TimestampField:  opts.TimestampField,
-		TimestampFormat: opts.TimestampFormat,
-		CSVDelimiter:    opts.Delimiter,
-	})
-	if err != nil {
-		return nil, err
+// batches buffers newline delimited data per dataset.
+type batches struct {
+	bufs  map[string]*bytes.Buffer
+	order []string
+}
+
+func newBatches() *batches {
+	return &batches{
+		bufs: make(map[string]*bytes.Buffer),
 	}
+}
 
-	return res, nil
+// get returns the buffer of the dataset.
+func (b *batches) get(dataset string) *bytes.Buffer {
+	buf, ok := b.bufs[dataset]
+	if !ok {
+		buf = new(bytes.Buffer)
+		b.bufs[dataset] = buf
+		b.order = append(b.order, dataset)
+	}
+	return buf
+}
+
+// datasets returns the datasets in the order data was first buffered for them.
+func (b *batches) datasets() []string {
+	return b.order
+}
+
+// empty returns true if no data is buffered.
+func (b *batches) empty() bool {
+	for _, buf := range b.bufs {
+		if buf.Len() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonArrayToNDJSON returns a reader that converts the elements of the JSON
+// array read from r to newline delimited JSON.
+func jsonArrayToNDJSON(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+
+	go func() {
+		dec := json.NewDecoder(r)
+		if tok, err := dec.Token(); err != nil {
+			_ = pw.CloseWithError(err)
+			return
+		} else if tok != json.Delim('[') {
+			_ = pw.CloseWithError(errors.New("expected JSON array"))
+			return
+		}
+
+		var buf bytes.Buffer
+		for dec.More() {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+
+			buf.Reset()
+			if err := json.Compact(&buf, raw); err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+			_ = buf.WriteByte('\n')
+
+			if _, err := pw.Write(buf.Bytes()); err != nil {
+				return
+			}
+		}
+
+		_ = pw.Close()
+	}()
+
+	return pr
 }
 
 func mergeIngestStatuses(base, add *axiom.IngestStatus) {
@@ -436,24 +1945,6 @@ func mergeIngestStatuses(base, add *axiom.IngestStatus) {
 	base.WALLength += add.WALLength
 }
 
-// splitLinesMulti is like bufio.SplitLines, but returns multiple lines
-// including the newline char.
-func splitLinesMulti(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	if atEOF && len(data) == 0 {
-		return 0, nil, nil
-	}
-	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
-		// We have a full newline-terminated line.
-		return i + 1, data[0 : i+1], nil
-	}
-	// If we're at EOF, we have a final, non-terminated line. Return it.
-	if atEOF {
-		return len(data), data, nil
-	}
-	// Request more data.
-	return 0, nil, nil
-}
-
 func contentTypeCompletion(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 	res := make([]string, 0, len(validContentTypes))
 	for _, contentType := range validContentTypes {
@@ -464,6 +1955,105 @@ func contentTypeCompletion(_ *cobra.Command, _ []string, toComplete string) ([]s
 	return res, cobra.ShellCompDirectiveNoFileComp
 }
 
+func castCompletion(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	// Only complete the type, once the field name is given.
+	field, typ, ok := strings.Cut(toComplete, "=")
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
+	}
+
+	res := make([]string, 0, len(validCasts))
+	for _, cast := range validCasts {
+		if strings.HasPrefix(cast, typ) {
+			res = append(res, field+"="+cast)
+		}
+	}
+	return res, cobra.ShellCompDirectiveNoFileComp
+}
+
+func csvTypesCompletion(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	// Only complete the type of the last field, once its name is given.
+	i := strings.LastIndexByte(toComplete, ',') + 1
+	field, typ, ok := strings.Cut(toComplete[i:], "=")
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
+	}
+
+	res := make([]string, 0, len(validCSVTypes))
+	for _, t := range validCSVTypes {
+		if strings.HasPrefix(t, typ) {
+			res = append(res, toComplete[:i]+field+"="+t)
+		}
+	}
+	return res, cobra.ShellCompDirectiveNoFileComp
+}
+
+func metadataFieldCompletion(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	res := make([]string, 0, len(validMetadataFields))
+	for _, field := range validMetadataFields {
+		if strings.HasPrefix(field, toComplete) {
+			res = append(res, field+"=")
+		}
+	}
+	return res, cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
+}
+
+func redactCompletion(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	// Complete the last of the comma separated rules.
+	i := strings.LastIndexByte(toComplete, ',') + 1
+
+	var res []string
+	for _, rule := range redactRuleNames() {
+		if strings.HasPrefix(rule, toComplete[i:]) {
+			res = append(res, toComplete[:i]+rule)
+		}
+	}
+	return res, cobra.ShellCompDirectiveNoFileComp
+}
+
+func oversizedLinesCompletion(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	res := make([]string, 0, len(validOversizedModes))
+	for _, mode := range validOversizedModes {
+		if strings.HasPrefix(mode, toComplete) {
+			res = append(res, mode)
+		}
+	}
+	return res, cobra.ShellCompDirectiveNoFileComp
+}
+
+func schemaModeCompletion(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	res := make([]string, 0, len(validSchemaModes))
+	for _, mode := range validSchemaModes {
+		if strings.HasPrefix(mode, toComplete) {
+			res = append(res, mode)
+		}
+	}
+	return res, cobra.ShellCompDirectiveNoFileComp
+}
+
+func redactModeCompletion(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	res := make([]string, 0, len(validRedactModes))
+	for _, mode := range validRedactModes {
+		if strings.HasPrefix(mode, toComplete) {
+			res = append(res, mode)
+		}
+	}
+	return res, cobra.ShellCompDirectiveNoFileComp
+}
+
+func redactProfileCompletion(f *cmdutil.Factory) cmdutil.CompletionFunc {
+	return func(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		res := make([]string, 0, len(f.Config.Redactions))
+		for name := range f.Config.Redactions {
+			if strings.HasPrefix(name, toComplete) {
+				res = append(res, name)
+			}
+		}
+		sort.Strings(res)
+		return res, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
 func contentEncodingCompletion(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 	res := make([]string, 0, len(validContentEncodings))
 	for _, contentEncoding := range validContentEncodings {
@@ -0,0 +1,55 @@
+package ingest
+
+import (
+	"github.com/dustin/go-humanize"
+)
+
+const (
+	// defaultBatchSize is the size of uncompressed data after which a batch is
+	// sent, regardless of the flush interval, if not configured otherwise.
+	defaultBatchSize = 10 * 1024 * 1024
+	// defaultMaxLineSize is the maximum size of a line, if not configured
+	// otherwise.
+	defaultMaxLineSize = 1024 * 1024
+)
+
+// batchFull reports whether a batch of the given size holding the given amount
+// of events reached the configured limits and must be sent. If ingestion is
+// throttled, batches are limited to one second worth of data.
+func (opts *options) batchFull(size, events int) bool {
+	batchBytes := int(opts.BatchBytes)
+	if batchBytes <= 0 {
+		batchBytes = defaultBatchSize
+	}
+	if n := int(opts.MaxBytesPerSecond); n > 0 && n < batchBytes {
+		batchBytes = n
+	}
+
+	batchEvents := opts.BatchEvents
+	if n := opts.MaxEventsPerSecond; n > 0 && (batchEvents <= 0 || n < batchEvents) {
+		batchEvents = n
+	}
+
+	return size >= batchBytes || (batchEvents > 0 && events >= batchEvents)
+}
+
+// byteSize is a size in bytes which is given as a flag in a human readable
+// form, like "10MiB" or "5MB".
+type byteSize uint64
+
+func (s *byteSize) String() string {
+	return humanize.IBytes(uint64(*s))
+}
+
+func (s *byteSize) Set(v string) error {
+	n, err := humanize.ParseBytes(v)
+	if err != nil {
+		return err
+	}
+	*s = byteSize(n)
+	return nil
+}
+
+func (s *byteSize) Type() string {
+	return "size"
+}
@@ -0,0 +1,276 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// validCSVTypes are the types a CSV column can be converted to.
+var validCSVTypes = []string{"string", "int", "float", "bool", "time"}
+
+// csvNumberRe matches numbers whose type is inferred. Numbers with leading
+// zeros, like zip codes, are kept as strings.
+var csvNumberRe = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// csvRecord is a record split off the data, which hasn't been parsed yet.
+type csvRecord struct {
+	// line is the number of the line the record starts at.
+	line int
+	// offset of the record in the data read.
+	offset int64
+}
+
+// csvDecoder decodes CSV data into events, one per record. Data is passed to
+// it in chunks of lines. Records are split off on line breaks outside of
+// quoted fields, so quoted fields can contain line breaks. Lines to skip,
+// comment lines and empty lines are dropped. Unless the field names are
+// configured, they are taken from the first record. Values are converted to
+// the type configured for their column or to the one inferred from them.
+type csvDecoder struct {
+	delimiter rune
+	comment   []byte
+	types     map[string]string
+	names     []string
+	// skipRows is the amount of lines left to skip before the header.
+	skipRows int
+	// skip is the offset up to which records have already been ingested and
+	// are not emitted again.
+	skip int64
+
+	// buf holds the data not yet split into records.
+	buf []byte
+	// offset of buf in the data read.
+	offset int64
+	// line is the number of lines split off.
+	line int
+}
+
+// newCSVDecoder creates a CSV decoder configured by the options. Records read
+// before the given offset are not emitted.
+func newCSVDecoder(opts *options, skip int64) (*csvDecoder, error) {
+	d := &csvDecoder{
+		delimiter: ',',
+		comment:   []byte(opts.CSVComment),
+		names:     opts.CSVHeader,
+		skipRows:  opts.CSVSkipRows,
+		skip:      skip,
+	}
+	if opts.Delimiter != "" {
+		d.delimiter = []rune(opts.Delimiter)[0]
+	}
+
+	types, err := splitAssignments("--csv-types", opts.CSVTypes)
+	if err != nil {
+		return nil, err
+	}
+	d.types = make(map[string]string, len(types))
+	for _, t := range types {
+		if !contains(validCSVTypes, t[1]) {
+			return nil, fmt.Errorf("invalid --csv-types %q: type must be one of %s", t[0]+"="+t[1], strings.Join(validCSVTypes, ", "))
+		}
+		d.types[t[0]] = t[1]
+	}
+
+	if d.names != nil {
+		if err = d.checkTypes(); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// checkTypes makes sure every column a type is configured for exists.
+func (d *csvDecoder) checkTypes() error {
+	for name := range d.types {
+		if !contains(d.names, name) {
+			return fmt.Errorf("invalid --csv-types: no column %q in header %s", name, strings.Join(d.names, ","))
+		}
+	}
+	return nil
+}
+
+// decode decodes the records completed by the data, which starts at the given
+// offset of the data read, and passes the events to emit, along with the
+// number of the line the record starts at. Incomplete records are kept until
+// more data is decoded or the decoder is flushed.
+func (d *csvDecoder) decode(data []byte, offset int64, emit func(event map[string]any, line int) error) error {
+	if len(d.buf) == 0 {
+		d.offset = offset
+	}
+	d.buf = append(d.buf, data...)
+
+	var (
+		recs  bytes.Buffer
+		infos []csvRecord
+		p     int
+	)
+	for p < len(d.buf) {
+		rest := d.buf[p:]
+
+		// A UTF-8 byte order mark at the beginning of the data is dropped.
+		if d.offset+int64(p) == 0 && bytes.HasPrefix(rest, []byte("\xef\xbb\xbf")) {
+			p += 3
+			continue
+		}
+
+		// Lines to skip, comment lines and empty lines end at the next line
+		// break.
+		if d.skipRows > 0 || (len(d.comment) > 0 && bytes.HasPrefix(rest, d.comment)) ||
+			rest[0] == '\n' || bytes.HasPrefix(rest, []byte("\r\n")) {
+			i := bytes.IndexByte(rest, '\n')
+			if i < 0 {
+				break
+			}
+			if d.skipRows > 0 {
+				d.skipRows--
+			}
+			p += i + 1
+			d.line++
+			continue
+		}
+
+		// A record ends at the first line break outside of quotes. Escaped
+		// quotes are doubled, so they don't change whether a line break is
+		// quoted.
+		var (
+			end    = -1
+			quoted bool
+			lines  int
+		)
+		for i, c := range rest {
+			if c == '"' {
+				quoted = !quoted
+			} else if c == '\n' {
+				lines++
+				if !quoted {
+					end = i
+					break
+				}
+			}
+		}
+		if end < 0 {
+			break
+		}
+
+		infos = append(infos, csvRecord{
+			line:   d.line + 1,
+			offset: d.offset + int64(p),
+		})
+		_, _ = recs.Write(rest[:end+1])
+		p += end + 1
+		d.line += lines
+	}
+
+	d.buf = append(d.buf[:0], d.buf[p:]...)
+	d.offset += int64(p)
+
+	return d.parse(&recs, infos, emit)
+}
+
+// flush decodes the data left, which is not terminated by a line break.
+func (d *csvDecoder) flush(emit func(event map[string]any, line int) error) error {
+	if len(d.buf) == 0 {
+		return nil
+	}
+	if err := d.decode([]byte{'\n'}, d.offset+int64(len(d.buf)), emit); err != nil {
+		return err
+	} else if len(d.buf) > 0 {
+		return fmt.Errorf("invalid CSV on line %d: %w", d.line+1, csv.ErrQuote)
+	}
+	return nil
+}
+
+// pending returns the size of the data which hasn't been split into records,
+// yet.
+func (d *csvDecoder) pending() int {
+	return len(d.buf)
+}
+
+// parse parses the records split off the data and emits them as events.
+func (d *csvDecoder) parse(recs io.Reader, infos []csvRecord, emit func(event map[string]any, line int) error) error {
+	cr := csv.NewReader(recs)
+	cr.Comma = d.delimiter
+	cr.FieldsPerRecord = -1
+
+	for _, info := range infos {
+		record, err := cr.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				err = parseErr.Err
+			}
+			return fmt.Errorf("invalid CSV on line %d: %w", info.line, err)
+		}
+
+		if d.names == nil {
+			d.names = record
+			if err = d.checkTypes(); err != nil {
+				return err
+			}
+			continue
+		} else if info.offset < d.skip {
+			continue
+		}
+
+		if err = emit(d.event(record), info.line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// event converts the record into an event.
+func (d *csvDecoder) event(record []string) map[string]any {
+	event := make(map[string]any, len(d.names))
+	for i, v := range record {
+		if i >= len(d.names) {
+			break
+		}
+
+		name := d.names[i]
+		switch typ := d.types[name]; typ {
+		case "":
+			event[name] = inferCSVValue(v)
+		case "string":
+			event[name] = v
+		default:
+			// Empty values of typed columns are null.
+			if v != "" {
+				event[name] = castValue(v, typ)
+			}
+		}
+	}
+	return event
+}
+
+// inferCSVValue converts the value to a number or a boolean, if it looks like
+// one. Other values are kept as strings.
+func inferCSVValue(v string) any {
+	switch {
+	case v == "true" || v == "TRUE" || v == "True":
+		return true
+	case v == "false" || v == "FALSE" || v == "False":
+		return false
+	case !csvNumberRe.MatchString(v):
+		return v
+	}
+
+	// Integers too big for an int64 are kept as strings, as they are most
+	// likely identifiers which would lose precision as a float.
+	if !strings.ContainsAny(v, ".eE") {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+		return v
+	} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+	return v
+}
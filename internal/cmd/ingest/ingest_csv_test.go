@@ -0,0 +1,108 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVDecoder(t *testing.T) {
+	tr, err := newTransform(&options{Cast: []string{"n=int"}})
+	require.NoError(t, err)
+
+	d, err := newCSVDecoder(&options{}, 0)
+	require.NoError(t, err)
+
+	var (
+		buf   bytes.Buffer
+		lines []int
+	)
+	emit := func(event map[string]any, line int) error {
+		lines = append(lines, line)
+		if event, err = processEvent(event, []eventFunc{tr.apply}); err != nil {
+			return err
+		}
+		return appendEvent(&buf, event)
+	}
+
+	// The quoted line break is split across chunks.
+	require.NoError(t, d.decode([]byte("name,n\na,1\n\"b,\n"), 0, emit))
+	assert.Equal(t, 4, d.pending())
+	require.NoError(t, d.decode([]byte("c\",2\n"), 15, emit))
+	assert.Zero(t, d.pending())
+	require.NoError(t, d.flush(emit))
+
+	assert.Equal(t, "{\"n\":1,\"name\":\"a\"}\n{\"n\":2,\"name\":\"b,\\nc\"}\n", buf.String())
+	assert.Equal(t, []int{2, 3}, lines)
+}
+
+func TestCSVDecoder_Options(t *testing.T) {
+	d, err := newCSVDecoder(&options{
+		Delimiter:   ";",
+		CSVHeader:   []string{"id", "zip", "price", "ok", "note", "count"},
+		CSVSkipRows: 2,
+		CSVTypes:    []string{"note=string", "count=int"},
+		CSVComment:  "#",
+	}, 0)
+	require.NoError(t, err)
+
+	var (
+		events []map[string]any
+		lines  []int
+	)
+	emit := func(event map[string]any, line int) error {
+		events = append(events, event)
+		lines = append(lines, line)
+		return nil
+	}
+
+	data := "exported by some tool\n" +
+		"# not a comment, but skipped\n" +
+		"# a comment\n" +
+		"1;01234;9.5;true;42;7\n" +
+		"\n" +
+		"99999999999999999999;x;-1e3;False;;\n"
+	require.NoError(t, d.decode([]byte(data), 0, emit))
+	require.NoError(t, d.flush(emit))
+
+	assert.Equal(t, []map[string]any{
+		{"id": int64(1), "zip": "01234", "price": 9.5, "ok": true, "note": "42", "count": int64(7)},
+		{"id": "99999999999999999999", "zip": "x", "price": -1000.0, "ok": false, "note": ""},
+	}, events)
+	assert.Equal(t, []int{4, 6}, lines)
+}
+
+func TestCSVDecoder_Skip(t *testing.T) {
+	// Records before the offset have already been ingested, but the header is
+	// still read from the data.
+	d, err := newCSVDecoder(&options{}, 8)
+	require.NoError(t, err)
+
+	var events []map[string]any
+	emit := func(event map[string]any, _ int) error {
+		events = append(events, event)
+		return nil
+	}
+	require.NoError(t, d.decode([]byte("a,b\n1,2\n3,4"), 0, emit))
+	require.NoError(t, d.flush(emit))
+
+	assert.Equal(t, []map[string]any{{"a": int64(3), "b": int64(4)}}, events)
+}
+
+func TestCSVDecoder_Invalid(t *testing.T) {
+	_, err := newCSVDecoder(&options{CSVTypes: []string{"a=text"}}, 0)
+	assert.EqualError(t, err, `invalid --csv-types "a=text": type must be one of string, int, float, bool, time`)
+
+	_, err = newCSVDecoder(&options{CSVHeader: []string{"a"}, CSVTypes: []string{"b=int"}}, 0)
+	assert.EqualError(t, err, `invalid --csv-types: no column "b" in header a`)
+
+	d, err := newCSVDecoder(&options{}, 0)
+	require.NoError(t, err)
+
+	emit := func(map[string]any, int) error { return nil }
+	require.NoError(t, d.decode([]byte("a\n\"b\n"), 0, emit))
+	assert.ErrorIs(t, d.flush(emit), csv.ErrQuote)
+}
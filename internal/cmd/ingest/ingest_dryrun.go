@@ -0,0 +1,220 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/axiomhq/cli/pkg/iofmt"
+)
+
+// maxReportedTimestamps is the maximum amount of events with invalid
+// timestamps listed in the dry run report.
+const maxReportedTimestamps = 10
+
+// invalidTimestamp is an event whose timestamp could not be parsed.
+type invalidTimestamp struct {
+	source string
+	event  int
+	value  any
+}
+
+// dryRunReport collects statistics about the data that would be ingested.
+type dryRunReport struct {
+	// timestamps parses timestamps on the client side, if configured.
+	timestamps *timestamps
+
+	events            int
+	eventsPerSource   map[string]int
+	fields            map[string]map[string]struct{}
+	missingTimestamps int
+	invalidTimestamps []invalidTimestamp
+	uncompressedBytes uint64
+	compressedBytes   uint64
+}
+
+func newDryRunReport(ts *timestamps) *dryRunReport {
+	return &dryRunReport{
+		timestamps:      ts,
+		eventsPerSource: make(map[string]int),
+		fields:          make(map[string]map[string]struct{}),
+	}
+}
+
+// add analyzes a batch of newline delimited JSON read from the named source.
+func (rep *dryRunReport) add(source string, batch []byte) error {
+	compressed, err := zstdCompress(batch)
+	if err != nil {
+		return err
+	}
+	rep.uncompressedBytes += uint64(len(batch))
+	rep.compressedBytes += uint64(len(compressed))
+
+	addEvent := func(event map[string]any) {
+		rep.events++
+		rep.eventsPerSource[source]++
+
+		flattenFields("", event, func(name, typ string) {
+			if rep.fields[name] == nil {
+				rep.fields[name] = make(map[string]struct{})
+			}
+			rep.fields[name][typ] = struct{}{}
+		})
+
+		v, found, valid := rep.timestamp(event)
+		if !found {
+			rep.missingTimestamps++
+		} else if !valid {
+			rep.invalidTimestamps = append(rep.invalidTimestamps, invalidTimestamp{
+				source: source,
+				event:  rep.eventsPerSource[source],
+				value:  v,
+			})
+		}
+	}
+
+	// The batch is held in memory already, so its lines are not limited in
+	// size.
+	for len(batch) > 0 {
+		var line []byte
+		line, batch, _ = bytes.Cut(batch, []byte{'\n'})
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+
+		var event map[string]any
+		if err := dec.Decode(&event); err != nil {
+			return fmt.Errorf("invalid JSON in event %d of %q: %w", rep.eventsPerSource[source]+1, source, err)
+		}
+		addEvent(event)
+	}
+
+	return nil
+}
+
+// timestamp returns the timestamp of the event and whether it is valid. The
+// fields timestamps are parsed from on the client side are looked at first,
+// using the same parser, before falling back to the "_time" field, parsed the
+// way the server does.
+func (rep *dryRunReport) timestamp(event map[string]any) (v any, found, valid bool) {
+	if ts := rep.timestamps; ts != nil {
+		for _, field := range ts.fields {
+			if m, k, ok := lookupField(event, field); ok && m[k] != nil {
+				_, valid = ts.parse(m[k])
+				return m[k], true, valid
+			}
+		}
+	}
+
+	if v, found = event[defaultTimestampField]; !found {
+		return nil, false, false
+	}
+	_, valid = parseTimestamp(v)
+	return v, true, valid
+}
+
+// print writes the report to the standard output.
+func (rep *dryRunReport) print(opts *options) error {
+	var (
+		cs = opts.IO.ColorScheme()
+		w  = opts.IO.Out()
+	)
+
+	if opts.IO.IsStdoutTTY() && opts.Dataset != "" {
+		fmt.Fprintf(w, "Dry run of ingesting into dataset %s, nothing was sent:\n\n", cs.Bold(opts.Dataset))
+	} else if opts.IO.IsStdoutTTY() {
+		fmt.Fprint(w, "Dry run, nothing was sent:\n\n")
+	}
+
+	fmt.Fprintf(w, "Events:              %d\n", rep.events)
+	fmt.Fprintf(w, "Uncompressed size:   %s\n", humanize.Bytes(rep.uncompressedBytes))
+	fmt.Fprintf(w, "Compressed size:     %s\n", humanize.Bytes(rep.compressedBytes))
+	fmt.Fprintf(w, "Missing timestamps:  %d\n", rep.missingTimestamps)
+	fmt.Fprintf(w, "Invalid timestamps:  %d\n", len(rep.invalidTimestamps))
+
+	if len(rep.invalidTimestamps) > 0 {
+		fmt.Fprintln(w)
+		for i, it := range rep.invalidTimestamps {
+			if i == maxReportedTimestamps {
+				fmt.Fprintf(w, "  ... and %d more\n", len(rep.invalidTimestamps)-i)
+				break
+			}
+			fmt.Fprintf(w, "  %s event %d: %s\n", cs.Gray(it.source), it.event, cs.Red(fmt.Sprintf("%v", it.value)))
+		}
+	}
+
+	if len(rep.fields) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+
+	names := make([]string, 0, len(rep.fields))
+	for name := range rep.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var header iofmt.HeaderBuilderFunc
+	if opts.IO.IsStdoutTTY() {
+		header = func(_ io.Writer, trb iofmt.TableRowBuilder) {
+			trb.AddField("Field", cs.Bold)
+			trb.AddField("Types", cs.Bold)
+		}
+	}
+
+	contentRow := func(trb iofmt.TableRowBuilder, k int) {
+		types := make([]string, 0, len(rep.fields[names[k]]))
+		for typ := range rep.fields[names[k]] {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+
+		trb.AddField(names[k], nil)
+		trb.AddField(strings.Join(types, ", "), cs.Gray)
+	}
+
+	return iofmt.FormatToTable(opts.IO, len(names), header, nil, contentRow)
+}
+
+// flattenFields calls fn with the name and type of every field of the event.
+// Nested objects are flattened, joining the field names with a dot.
+func flattenFields(prefix string, event map[string]any, fn func(name, typ string)) {
+	for k, v := range event {
+		name := prefix + k
+		if m, ok := v.(map[string]any); ok {
+			flattenFields(name+".", m, fn)
+			continue
+		}
+		fn(name, valueType(v))
+	}
+}
+
+// valueType returns the name of the type of a decoded JSON value.
+func valueType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return "float"
+		}
+		return "integer"
+	case float64:
+		return "float"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "string"
+}
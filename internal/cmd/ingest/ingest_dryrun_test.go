@@ -0,0 +1,84 @@
+package ingest
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunReport(t *testing.T) {
+	rep := newDryRunReport(nil)
+
+	// Lines larger than the default maximum line size are analyzed, too.
+	large := `{"_time":"2022-07-01T12:00:00Z","msg":"` + strings.Repeat("x", defaultMaxLineSize) + `"}`
+	require.NoError(t, rep.add("a.ndjson", []byte(large+"\n\n"+`{"_time":1656676800,"user":{"id":1,"ratio":0.5}}`)))
+	require.NoError(t, rep.add("b.ndjson", []byte(`{"msg":null,"tags":["x"]}`+"\n"+`{"_time":"yesterday"}`)))
+
+	assert.Equal(t, 4, rep.events)
+	assert.Equal(t, map[string]int{"a.ndjson": 2, "b.ndjson": 2}, rep.eventsPerSource)
+	assert.Equal(t, 1, rep.missingTimestamps)
+	assert.Equal(t, []invalidTimestamp{{source: "b.ndjson", event: 2, value: "yesterday"}}, rep.invalidTimestamps)
+	assert.Equal(t, map[string]map[string]struct{}{
+		"_time":      {"string": {}, "integer": {}},
+		"msg":        {"string": {}, "null": {}},
+		"user.id":    {"integer": {}},
+		"user.ratio": {"float": {}},
+		"tags":       {"array": {}},
+	}, rep.fields)
+	assert.NotZero(t, rep.uncompressedBytes)
+	assert.Less(t, rep.compressedBytes, rep.uncompressedBytes)
+
+	err := rep.add("c.ndjson", []byte(`{"a":1}`+"\n"+`{"a":`))
+	assert.EqualError(t, err, `invalid JSON in event 2 of "c.ndjson": unexpected EOF`)
+}
+
+func TestDryRunReport_TimestampField(t *testing.T) {
+	ts, err := newTimestamps(&options{TimestampFields: []string{"ts"}})
+	require.NoError(t, err)
+
+	rep := newDryRunReport(ts)
+
+	// Events are reported the way the timestamp parser passes them on.
+	var batch []byte
+	for _, event := range []map[string]any{
+		{"ts": "2022-07-01 12:00:00"},
+		{"ts": "soon"},
+		{"msg": "no timestamp"},
+		{"_time": "2022-07-01T12:00:00Z"},
+	} {
+		event, err = ts.apply(event)
+		require.NoError(t, err)
+
+		b, err := json.Marshal(event)
+		require.NoError(t, err)
+		batch = append(append(batch, b...), '\n')
+	}
+	require.NoError(t, rep.add("a.ndjson", batch))
+
+	assert.Equal(t, 4, rep.events)
+	assert.Equal(t, 1, rep.missingTimestamps)
+	assert.Equal(t, []invalidTimestamp{{source: "a.ndjson", event: 2, value: "soon"}}, rep.invalidTimestamps)
+}
+
+func TestValueType(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{nil, "null"},
+		{true, "boolean"},
+		{json.Number("1"), "integer"},
+		{json.Number("1.5"), "float"},
+		{json.Number("1e3"), "float"},
+		{1.5, "float"},
+		{[]any{}, "array"},
+		{map[string]any{}, "object"},
+		{"a", "string"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, valueType(tt.value), tt.value)
+	}
+}
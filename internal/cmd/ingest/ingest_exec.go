@@ -0,0 +1,259 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/axiomhq/cli/internal/cmdutil"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+// Streams of a child process, as found in the "stream" field of its events.
+const (
+	stdoutStream = "stdout"
+	stderrStream = "stderr"
+	exitStream   = "exit"
+)
+
+// runExec runs the configured command and ingests every line it writes to
+// its standard output and error as an event. Lines are passed through to the
+// standard output and error of the CLI. Once the command has exited, a final
+// event holding its exit code is ingested. Signals received are forwarded to
+// the command and the CLI exits with the exit code of the command.
+func runExec(ctx context.Context, opts *options) error {
+	client, err := opts.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	if opts.RejectFile != "" {
+		if opts.rejects, err = openRejectsFile(opts.RejectFile); err != nil {
+			return err
+		}
+		defer opts.rejects.Close()
+	}
+
+	if opts.SchemaRejectFile != "" {
+		if opts.schemaRejects, err = openRejectsFile(opts.SchemaRejectFile); err != nil {
+			return err
+		}
+		defer opts.schemaRejects.Close()
+	}
+
+	parse := lineParser(opts.Format, opts)
+	if parse == nil {
+		parse = parseExecLine
+	}
+
+	// The command is neither killed when the context is canceled nor are
+	// events lost, as signals are forwarded to the command and it is up to it
+	// to exit. Sending is only aborted, if the CLI is.
+	sendCtx, cancelSend := context.WithCancel(cmdutil.AbortContext(ctx))
+	defer cancelSend()
+
+	// Batches spooled by previous runs are sent first, so they are ingested
+	// in order.
+	opts.spool.tryDrain(sendCtx, client, opts)
+
+	s := newSink(sendCtx, client, opts)
+
+	cmd := exec.Command(opts.command[0], opts.command[1:]...) //nolint:gosec // Running the given command is the whole point.
+	cmd.Stdin = opts.IO.In()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	started := time.Now()
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	var (
+		pid     = cmd.Process.Pid
+		command = strings.Join(opts.command, " ")
+	)
+
+	// The sink is flushed every flush interval until the command has exited.
+	runCtx, stopRun := context.WithCancel(context.Background())
+	defer stopRun()
+	go s.run(runCtx)
+
+	// Signals sent by the terminal reach the whole foreground process group,
+	// so the command has already received them.
+	fromTerminal := opts.IO.IsStdinTTY()
+	go func() {
+		for sig := range sigs {
+			if fromTerminal && (sig == os.Interrupt || sig == syscall.SIGQUIT) {
+				continue
+			}
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+
+	var (
+		wg      sync.WaitGroup
+		errMu   sync.Mutex
+		readErr error
+	)
+	setErr := func(err error) {
+		errMu.Lock()
+		defer errMu.Unlock()
+
+		if readErr == nil {
+			readErr = err
+		}
+	}
+	capture := func(r io.Reader, w io.Writer, stream string) {
+		defer wg.Done()
+
+		// Lines are passed through as they are read, regardless of the
+		// maximum line size.
+		r = io.TeeReader(r, passThrough{w})
+		lr := newLineReader(r, opts)
+		for {
+			chunk, err := lr.next()
+			for data := chunk.data; len(data) > 0; {
+				line := data
+				if i := bytes.IndexByte(data, '\n'); i >= 0 {
+					line = data[:i+1]
+				}
+				data = data[len(line):]
+
+				if perr := addExecLine(s, parse, string(line), stream, pid, command); perr != nil {
+					setErr(perr)
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				return
+			} else if err != nil {
+				// Keep reading, so the command doesn't block on a full pipe.
+				setErr(err)
+				_, _ = io.Copy(io.Discard, r)
+				return
+			}
+		}
+	}
+
+	wg.Add(2)
+	go capture(stdout, opts.IO.Out(), stdoutStream)
+	go capture(stderr, opts.IO.ErrOut(), stderrStream)
+	wg.Wait()
+
+	waitErr := cmd.Wait()
+	exitCode := cmd.ProcessState.ExitCode()
+	var exitErr *exec.ExitError
+	if waitErr != nil && !errors.As(waitErr, &exitErr) {
+		return waitErr
+	}
+
+	if err = s.add(map[string]any{
+		messageField: fmt.Sprintf("%s exited with code %d", command, exitCode),
+		"stream":     exitStream,
+		"pid":        pid,
+		"command":    command,
+		"exit_code":  exitCode,
+		"duration":   time.Since(started).Seconds(),
+	}); err != nil {
+		setErr(err)
+	}
+
+	stopRun()
+	s.flush()
+
+	opts.sampler.printSummary(opts.IO)
+	opts.oversized.printSummary(opts.IO)
+
+	if opts.rejects != nil && opts.rejects.count() > 0 {
+		cs := opts.IO.ColorScheme()
+		fmt.Fprintf(opts.IO.ErrOut(), "%s Wrote %s not matching the pattern to %s\n",
+			cs.WarningIcon(),
+			utils.Pluralize(cs, "line", opts.rejects.count()),
+			cs.Bold(opts.RejectFile),
+		)
+	}
+
+	err = s.printSummary()
+	opts.metadata.printSummary(opts.IO)
+	opts.timestamps.printSummary(opts.IO)
+	if opts.schema.printSummary(opts.IO, opts.SchemaRejectFile) && err == nil {
+		err = cmdutil.ErrSilent
+	}
+	if opts.spool.printSummary(opts.IO) && err == nil {
+		err = cmdutil.ErrSilent
+	}
+
+	if err != nil && exitCode == 0 {
+		return err
+	} else if readErr != nil && exitCode == 0 {
+		return readErr
+	} else if exitCode != 0 {
+		// A command killed by a signal has no exit code.
+		if exitCode < 0 {
+			exitCode = 1
+		}
+		return cmdutil.ExitError{Code: exitCode}
+	}
+	return nil
+}
+
+// passThrough writes to the underlying writer, ignoring errors, so the output
+// of the command is still ingested, if it can't be passed through.
+type passThrough struct {
+	w io.Writer
+}
+
+func (pt passThrough) Write(p []byte) (int, error) {
+	_, _ = pt.w.Write(p)
+	return len(p), nil
+}
+
+// addExecLine parses the line written by the command to the stream and adds
+// it to the sink.
+func addExecLine(s *sink, parse func(string) (map[string]any, error), line, stream string, pid int, command string) error {
+	if line = strings.TrimRight(line, "\r\n"); strings.TrimSpace(line) == "" {
+		return nil
+	}
+
+	event, err := parse(line)
+	if err != nil || event == nil {
+		return err
+	}
+
+	event["stream"] = stream
+	event["pid"] = pid
+	event["command"] = command
+
+	return s.add(event)
+}
+
+// parseExecLine parses a line that is a JSON object as such. Any other line
+// ends up in the message field.
+func parseExecLine(line string) (map[string]any, error) {
+	if strings.HasPrefix(strings.TrimSpace(line), "{") {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err == nil && event != nil {
+			return event, nil
+		}
+	}
+	return textFormat.parse(line), nil
+}
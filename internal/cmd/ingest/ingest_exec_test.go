@@ -0,0 +1,22 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddExecLine(t *testing.T) {
+	s := newSink(context.Background(), nil, &options{Dataset: "test"})
+
+	require.NoError(t, addExecLine(s, parseExecLine, "{\"level\":\"info\"}\n", stdoutStream, 42, "job --flag"))
+	require.NoError(t, addExecLine(s, parseExecLine, "\r\n", stderrStream, 42, "job --flag"))
+	require.NoError(t, addExecLine(s, parseExecLine, "{\"level\":\n", stderrStream, 42, "job --flag"))
+
+	want := "{\"command\":\"job --flag\",\"level\":\"info\",\"pid\":42,\"stream\":\"stdout\"}\n" +
+		"{\"command\":\"job --flag\",\"message\":\"{\\\"level\\\":\",\"pid\":42,\"stream\":\"stderr\"}\n"
+	assert.Equal(t, want, s.bufs.get("test").String())
+	assert.EqualValues(t, 2, s.status().Received)
+}
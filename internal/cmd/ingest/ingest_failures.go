@@ -0,0 +1,195 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/axiomhq/axiom-go/axiom"
+)
+
+// defaultTimestampField is the field the server takes the event time from.
+const defaultTimestampField = "_time"
+
+// failureRecord is a line of the failures file.
+type failureRecord struct {
+	// Line is the original line (or JSON array element) of the rejected
+	// event. It is omitted if the failure can't be attributed to an event.
+	Line string `json:"line,omitempty"`
+	// File the event was read from.
+	File string `json:"file"`
+	// Timestamp of the rejected event.
+	Timestamp time.Time `json:"timestamp"`
+	// Error returned by the server.
+	Error string `json:"error"`
+}
+
+// failuresFile writes rejected events as newline delimited JSON. It is safe
+// for concurrent use.
+type failuresFile struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+	n   int
+}
+
+// openFailuresFile opens the named file for appending failure records,
+// creating it if necessary.
+func openFailuresFile(filename string) (*failuresFile, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failuresFile{
+		f:   f,
+		enc: json.NewEncoder(f),
+	}, nil
+}
+
+// write records the given failures which occurred while ingesting the batch of
+// newline delimited JSON. The batch is used to find the original line of each
+// rejected event, as described by attributeFailures. It may be nil, if the
+// batch is not available anymore.
+func (ff *failuresFile) write(filename string, batch []byte, failures []*axiom.IngestFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	lines := make([]string, len(failures))
+	attributeFailures(batch, failures, lines)
+
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	for i, failure := range failures {
+		if err := ff.enc.Encode(failureRecord{
+			Line:      lines[i],
+			File:      filename,
+			Timestamp: failure.Timestamp,
+			Error:     failure.Error,
+		}); err != nil {
+			return err
+		}
+		ff.n++
+	}
+
+	return nil
+}
+
+// count returns the amount of records written.
+func (ff *failuresFile) count() int {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	return ff.n
+}
+
+// Close closes the underlying file.
+func (ff *failuresFile) Close() error {
+	return ff.f.Close()
+}
+
+// attributeFailures populates lines with the original line of the event each
+// failure belongs to, read from the batch of newline delimited JSON. As the
+// server only reports the timestamp of a rejected event, this is best-effort:
+// Failures are matched to the events holding the same timestamp, in order.
+// Events sharing a timestamp can be mixed up and failures of events without a
+// timestamp, or with one that can't be parsed, are left untouched.
+func attributeFailures(batch []byte, failures []*axiom.IngestFailure, lines []string) {
+	remaining := len(failures)
+	match := func(line string, v any) bool {
+		ts, ok := parseTimestamp(v)
+		if !ok {
+			return false
+		}
+		for i, failure := range failures {
+			if lines[i] == "" && failure.Timestamp.Equal(ts) {
+				lines[i] = line
+				remaining--
+				break
+			}
+		}
+		return remaining == 0
+	}
+
+	// The batch is held in memory already, so its lines are not limited in
+	// size.
+	for len(batch) > 0 {
+		var line []byte
+		line, batch, _ = bytes.Cut(batch, []byte{'\n'})
+
+		var event map[string]any
+		if json.Unmarshal(line, &event) != nil {
+			continue
+		} else if match(string(line), event[defaultTimestampField]) {
+			return
+		}
+	}
+}
+
+// parseTimestamp parses a timestamp value the way the server does: Strings are
+// parsed using a heuristic parser. Numbers are treated as Unix timestamps in
+// seconds, milliseconds, microseconds or nanoseconds, depending on their
+// magnitude.
+func parseTimestamp(v any) (time.Time, bool) {
+	switch v := v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		// Integers are converted exactly, unless they don't fit.
+		s, _ := formatNumber(v)
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return unixTimestamp(n), true
+		}
+		f, _ := strconv.ParseFloat(s, 64)
+		return parseTimestamp(f)
+	case json.Number:
+		return parseTimestamp(string(v))
+	case string:
+		// Integers are parsed as such, as a float64 can't hold epochs in
+		// nanoseconds exactly.
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return unixTimestamp(n), true
+		} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return parseTimestamp(f)
+		}
+		ts, err := dateparse.ParseAny(v)
+		return ts, err == nil
+	case float64:
+		switch abs := math.Abs(v); {
+		case abs < 1e11:
+			sec, frac := math.Modf(v)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), true
+		case abs < 1e14:
+			return time.UnixMilli(int64(v)).UTC(), true
+		case abs < 1e17:
+			return time.UnixMicro(int64(v)).UTC(), true
+		default:
+			return time.Unix(0, int64(v)).UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// unixTimestamp converts the Unix timestamp, whose unit is detected like
+// parseTimestamp does, to a time.
+func unixTimestamp(n int64) time.Time {
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs < 1e11:
+		return time.Unix(n, 0).UTC()
+	case abs < 1e14:
+		return time.UnixMilli(n).UTC()
+	case abs < 1e17:
+		return time.UnixMicro(n).UTC()
+	default:
+		return time.Unix(0, n).UTC()
+	}
+}
@@ -0,0 +1,90 @@
+package ingest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailuresFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "failures.ndjson")
+
+	ff, err := openFailuresFile(filename)
+	require.NoError(t, err)
+	defer ff.Close()
+
+	ts := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	// Lines larger than the default maximum line size are attributed, too.
+	large := `{"_time":"2022-07-01T12:00:02Z","msg":"` + strings.Repeat("x", defaultMaxLineSize) + `"}`
+	batch := strings.Join([]string{
+		`{"_time":"2022-07-01T12:00:00Z","n":1}`,
+		`{"msg":"no timestamp"}`,
+		`{"_time":1656676800,"n":2}`,
+		large,
+	}, "\n")
+
+	require.NoError(t, ff.write("app.log", []byte(batch), []*axiom.IngestFailure{
+		{Timestamp: ts, Error: "first"},
+		{Timestamp: ts, Error: "second"},
+		{Timestamp: ts.Add(time.Second * 2), Error: "large"},
+		{Timestamp: ts.Add(time.Second), Error: "unknown"},
+	}))
+	assert.Equal(t, 4, ff.count())
+
+	b, err := os.ReadFile(filename)
+	require.NoError(t, err)
+
+	var records []failureRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var rec failureRecord
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		records = append(records, rec)
+	}
+	require.Len(t, records, 4)
+
+	// Failures sharing a timestamp are attributed in order.
+	assert.Equal(t, failureRecord{Line: `{"_time":"2022-07-01T12:00:00Z","n":1}`, File: "app.log", Timestamp: ts, Error: "first"}, records[0])
+	assert.Equal(t, `{"_time":1656676800,"n":2}`, records[1].Line)
+	assert.Equal(t, large, records[2].Line)
+	assert.Empty(t, records[3].Line)
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  time.Time
+		ok    bool
+	}{
+		{"rfc3339", "2022-07-01T12:00:00.5Z", time.Date(2022, 7, 1, 12, 0, 0, 5e8, time.UTC), true},
+		{"seconds", 1656676800.25, time.Date(2022, 7, 1, 12, 0, 0, 25e7, time.UTC), true},
+		{"milliseconds", float64(1656676800123), time.Date(2022, 7, 1, 12, 0, 0, 123e6, time.UTC), true},
+		{"microseconds", float64(1656676800123456), time.Date(2022, 7, 1, 12, 0, 0, 123456e3, time.UTC), true},
+		{"nanoseconds", float64(1656676800000000000), time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC), true},
+		{"integer seconds", int64(1656676800), time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC), true},
+		{"integer milliseconds", 1656676800123, time.Date(2022, 7, 1, 12, 0, 0, 123e6, time.UTC), true},
+		{"integer nanoseconds", uint64(1656676800123456789), time.Date(2022, 7, 1, 12, 0, 0, 123456789, time.UTC), true},
+		{"nanoseconds string", "1656676800123456789", time.Date(2022, 7, 1, 12, 0, 0, 123456789, time.UTC), true},
+		{"json number", json.Number("1656676800123456789"), time.Date(2022, 7, 1, 12, 0, 0, 123456789, time.UTC), true},
+		{"numeric string", "1656676800", time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC), true},
+		{"invalid string", "yesterday", time.Time{}, false},
+		{"other type", true, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, ok := parseTimestamp(tt.value)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.True(t, tt.want.Equal(ts), "want %s, got %s", tt.want, ts)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Magic bytes at the beginning of compressed data.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// expandFilenames expands directories and glob patterns into the regular files
+// they contain, in lexical order. Besides the patterns supported by
+// filepath.Match, a "**" path element matches any amount of directories.
+// Filenames which are neither are kept as they are, just like "-" for stdin.
+func expandFilenames(filenames []string) ([]string, error) {
+	var (
+		res  = make([]string, 0, len(filenames))
+		seen = make(map[string]bool, len(filenames))
+	)
+	add := func(filename string) {
+		if !seen[filename] {
+			seen[filename] = true
+			res = append(res, filename)
+		}
+	}
+
+	for _, filename := range filenames {
+		if filename == "-" {
+			add(filename)
+			continue
+		}
+
+		if isGlob(filename) {
+			matches, err := glob(filename)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", filename, err)
+			} else if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", filename)
+			}
+			for _, match := range matches {
+				add(match)
+			}
+			continue
+		}
+
+		if fi, err := os.Stat(filename); err != nil || !fi.IsDir() {
+			add(filename)
+			continue
+		}
+
+		if err := walkFiles(filename, -1, func(path string) error {
+			add(path)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// isGlob reports whether the filename is a glob pattern.
+func isGlob(filename string) bool {
+	return strings.ContainsAny(filename, "*?[")
+}
+
+// glob returns the regular files matching the pattern. The directory the
+// pattern is relative to is the longest leading path without any pattern
+// characters, which is walked recursively. Unless the pattern holds a "**"
+// element, it is walked no deeper than the pattern reaches.
+func glob(pattern string) ([]string, error) {
+	var (
+		elems = strings.Split(filepath.ToSlash(pattern), "/")
+		i     int
+	)
+	for i < len(elems)-1 && !isGlob(elems[i]) {
+		i++
+	}
+
+	root := filepath.FromSlash(strings.Join(elems[:i], "/"))
+	if root == "" && i > 0 {
+		root = string(filepath.Separator)
+	} else if root == "" {
+		root = "."
+	}
+	patternElems := elems[i:]
+
+	// Validate the pattern upfront, as filepath.Match only reports errors
+	// when it gets to a malformed element.
+	for _, elem := range patternElems {
+		if _, err := filepath.Match(elem, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	maxDepth := len(patternElems)
+	for _, elem := range patternElems {
+		if elem == "**" {
+			maxDepth = -1
+			break
+		}
+	}
+
+	var res []string
+	err := walkFiles(root, maxDepth, func(path string) error {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if matchGlob(patternElems, strings.Split(filepath.ToSlash(rel), "/")) {
+			res = append(res, path)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// matchGlob reports whether the elements of a path match the elements of a
+// pattern. A "**" element matches any amount of path elements.
+func matchGlob(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchGlob(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(path) == 0 {
+			return false
+		} else if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+// walkFiles calls fn for every regular file in the directory tree, in lexical
+// order, including symbolic links to regular files, like the ones Kubernetes
+// creates for container logs. Symbolic links to directories are not followed.
+// Files more than maxDepth path elements below the root are skipped, unless
+// maxDepth is negative.
+func walkFiles(root string, maxDepth int, fn func(path string) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			// The files in a directory are one level deeper than it.
+			if maxDepth >= 0 && path != root && pathDepth(root, path) >= maxDepth {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			// Broken links are skipped, just like links to anything but a
+			// regular file.
+			if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+				return nil
+			}
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+		return fn(path)
+	})
+}
+
+// pathDepth returns the amount of path elements the path is below the root.
+func pathDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// detectContentEncoding returns the content encoding of the data read from r,
+// as indicated by the magic bytes of gzip and zstd. The returned io.Reader
+// must be used instead of the passed one.
+func detectContentEncoding(r io.Reader) (io.Reader, axiom.ContentEncoding, error) {
+	br := bufio.NewReader(r)
+
+	b, err := br.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, 0, err
+	}
+
+	switch {
+	case bytes.HasPrefix(b, gzipMagic):
+		return br, axiom.Gzip, nil
+	case bytes.HasPrefix(b, zstdMagic):
+		return br, axiom.Zstd, nil
+	}
+	return br, axiom.Identity, nil
+}
+
+// decodeContent wraps r with a reader that decodes the given content encoding.
+func decodeContent(r io.Reader, enc axiom.ContentEncoding) (io.ReadCloser, error) {
+	switch enc {
+	case axiom.Gzip:
+		return gzip.NewReader(r)
+	case axiom.Zstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case 0, axiom.Identity:
+		return io.NopCloser(r), nil
+	}
+	return nil, axiom.ErrUnknownContentEncoding
+}
@@ -0,0 +1,195 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandFilenames(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{
+		"a.json",
+		"logs/b.json.gz",
+		"logs/c.txt",
+		"logs/2022/d.json.gz",
+		"logs/2022/01/e.json.gz",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, nil, 0o600))
+	}
+
+	join := func(names ...string) []string {
+		res := make([]string, len(names))
+		for i, name := range names {
+			res[i] = filepath.Join(dir, filepath.FromSlash(name))
+		}
+		return res
+	}
+
+	tests := []struct {
+		name      string
+		filenames []string
+		want      []string
+		err       string
+	}{
+		{
+			name:      "stdin",
+			filenames: []string{"-"},
+			want:      []string{"-"},
+		},
+		{
+			name:      "file",
+			filenames: join("a.json"),
+			want:      join("a.json"),
+		},
+		{
+			name:      "directory",
+			filenames: join("logs"),
+			want:      join("logs/2022/01/e.json.gz", "logs/2022/d.json.gz", "logs/b.json.gz", "logs/c.txt"),
+		},
+		{
+			name:      "glob",
+			filenames: join("logs/*.json.gz"),
+			want:      join("logs/b.json.gz"),
+		},
+		{
+			name:      "double star glob",
+			filenames: join("logs/**/*.json.gz"),
+			want:      join("logs/2022/01/e.json.gz", "logs/2022/d.json.gz", "logs/b.json.gz"),
+		},
+		{
+			name:      "duplicates",
+			filenames: join("logs/c.txt", "logs/*.txt"),
+			want:      join("logs/c.txt"),
+		},
+		{
+			name:      "no match",
+			filenames: join("logs/*.csv"),
+			err:       "no files match",
+		},
+		{
+			name:      "invalid pattern",
+			filenames: join("logs/[.json"),
+			err:       "invalid pattern",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandFilenames(tt.filenames)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExpandFilenames_Symlinks(t *testing.T) {
+	dir := t.TempDir()
+
+	target := filepath.Join(dir, "pods", "app", "0.log")
+	require.NoError(t, os.MkdirAll(filepath.Dir(target), 0o755))
+	require.NoError(t, os.WriteFile(target, nil, 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "containers"), 0o755))
+
+	// Links to files are followed, links to directories and broken ones are
+	// not.
+	for name, target := range map[string]string{
+		"containers/app.log":     target,
+		"containers/broken.log":  filepath.Join(dir, "missing.log"),
+		"containers/pods.log":    filepath.Join(dir, "pods"),
+		"containers/linked-pods": filepath.Join(dir, "pods"),
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Skipf("could not create symbolic link: %s", err)
+		}
+	}
+
+	want := []string{filepath.Join(dir, "containers", "app.log")}
+
+	got, err := expandFilenames([]string{filepath.Join(dir, "containers", "*.log")})
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	got, err = expandFilenames([]string{filepath.Join(dir, "containers")})
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestWalkFiles_MaxDepth(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"a.log", "b/c.log", "b/d/e.log"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, nil, 0o600))
+	}
+
+	walk := func(maxDepth int) []string {
+		var res []string
+		require.NoError(t, walkFiles(dir, maxDepth, func(path string) error {
+			rel, err := filepath.Rel(dir, path)
+			res = append(res, filepath.ToSlash(rel))
+			return err
+		}))
+		return res
+	}
+
+	assert.Equal(t, []string{"a.log"}, walk(1))
+	assert.Equal(t, []string{"a.log", "b/c.log"}, walk(2))
+	assert.Equal(t, []string{"a.log", "b/c.log", "b/d/e.log"}, walk(-1))
+}
+
+func TestDetectContentEncoding(t *testing.T) {
+	const data = `{"foo":"bar"}`
+
+	var gzipData bytes.Buffer
+	gw := gzip.NewWriter(&gzipData)
+	_, err := io.WriteString(gw, data)
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	var zstdData bytes.Buffer
+	zw, err := zstd.NewWriter(&zstdData)
+	require.NoError(t, err)
+	_, err = io.WriteString(zw, data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  axiom.ContentEncoding
+	}{
+		{"identity", []byte(data), axiom.Identity},
+		{"empty", nil, axiom.Identity},
+		{"short", []byte("{"), axiom.Identity},
+		{"gzip", gzipData.Bytes(), axiom.Gzip},
+		{"zstd", zstdData.Bytes(), axiom.Zstd},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, enc, err := detectContentEncoding(bytes.NewReader(tt.input))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, enc)
+
+			// Nothing read must be lost.
+			b, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, string(tt.input), string(b))
+		})
+	}
+}
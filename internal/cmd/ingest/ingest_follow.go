@@ -0,0 +1,613 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// followPollInterval is the interval at which a followed file is checked
+	// for new data, truncation and rotation once its end has been reached.
+	followPollInterval = time.Second / 4
+	// fingerprintSize is the maximum amount of bytes at the beginning of a file
+	// that are used to identify it across restarts.
+	fingerprintSize = 1024
+	// checkpointSaveInterval is the minimum interval between two saves of the
+	// checkpoints, so they aren't rewritten for every chunk ingested.
+	checkpointSaveInterval = time.Second
+)
+
+// defaultCheckpointFile returns the path of the checkpoint file used when
+// following files and no explicit checkpoint file is configured.
+func defaultCheckpointFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "axiom", "ingest-checkpoints.json")
+}
+
+// checkpoint is the position up to which a file has been ingested.
+type checkpoint struct {
+	// Fingerprint identifies the file by the hash of its first bytes.
+	Fingerprint string `json:"fingerprint"`
+	// FingerprintSize is the amount of bytes the fingerprint was created
+	// from.
+	FingerprintSize int64 `json:"fingerprintSize"`
+	// Offset is the amount of bytes successfully ingested.
+	Offset int64 `json:"offset"`
+	// Header is the CSV header line which is replayed when resuming.
+	Header string `json:"header,omitempty"`
+}
+
+// checkpoints persists the byte offsets of ingested files, keyed by dataset
+// and absolute file path. Updates are saved at most every
+// checkpointSaveInterval and merged with the ones of other processes sharing
+// the file. It is safe for concurrent use.
+type checkpoints struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]map[string]checkpoint
+	// dirty holds the entries updated since they were last saved.
+	dirty map[string]map[string]checkpoint
+	saved time.Time
+}
+
+// loadCheckpoints loads the checkpoints stored in the file at the given path.
+// A missing file is not an error.
+func loadCheckpoints(path string) (*checkpoints, error) {
+	entries, err := readCheckpoints(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &checkpoints{
+		path:    path,
+		entries: entries,
+		dirty:   make(map[string]map[string]checkpoint),
+	}, nil
+}
+
+// readCheckpoints reads the checkpoints stored in the file at the given path.
+// A missing file holds no checkpoints.
+func readCheckpoints(path string) (map[string]map[string]checkpoint, error) {
+	entries := make(map[string]map[string]checkpoint)
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	} else if err = json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// get returns the checkpoint for the given dataset and file, if any.
+func (cps *checkpoints) get(dataset, filename string) (checkpoint, bool) {
+	cps.mu.Lock()
+	defer cps.mu.Unlock()
+
+	cp, ok := cps.entries[dataset][filename]
+	return cp, ok
+}
+
+// set updates the checkpoint for the given dataset and file. It is saved,
+// unless the checkpoints have been saved less than checkpointSaveInterval ago.
+// Call flush to save pending updates.
+func (cps *checkpoints) set(dataset, filename string, cp checkpoint) error {
+	cps.mu.Lock()
+	defer cps.mu.Unlock()
+
+	setCheckpoint(cps.entries, dataset, filename, cp)
+	setCheckpoint(cps.dirty, dataset, filename, cp)
+
+	if time.Since(cps.saved) < checkpointSaveInterval {
+		return nil
+	}
+	return cps.save()
+}
+
+// flush saves the updates not saved yet, if any.
+func (cps *checkpoints) flush() error {
+	if cps == nil {
+		return nil
+	}
+
+	cps.mu.Lock()
+	defer cps.mu.Unlock()
+
+	if len(cps.dirty) == 0 {
+		return nil
+	}
+	return cps.save()
+}
+
+// save merges the updated checkpoints into the ones stored on disk, which
+// might have been updated by another process, and writes them back. The
+// caller must hold the lock.
+func (cps *checkpoints) save() error {
+	lock, err := lockFile(cps.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	entries, err := readCheckpoints(cps.path)
+	if err != nil {
+		return err
+	}
+	for dataset, files := range cps.dirty {
+		for filename, cp := range files {
+			setCheckpoint(entries, dataset, filename, cp)
+		}
+	}
+
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first and then rename it so the checkpoint
+	// file is never left half written.
+	tmp, err := os.CreateTemp(filepath.Dir(cps.path), filepath.Base(cps.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	} else if err = tmp.Close(); err != nil {
+		return err
+	} else if err = os.Rename(tmp.Name(), cps.path); err != nil {
+		return err
+	}
+
+	cps.entries = entries
+	cps.dirty = make(map[string]map[string]checkpoint)
+	cps.saved = time.Now()
+
+	return nil
+}
+
+func setCheckpoint(entries map[string]map[string]checkpoint, dataset, filename string, cp checkpoint) {
+	if entries[dataset] == nil {
+		entries[dataset] = make(map[string]checkpoint)
+	}
+	entries[dataset][filename] = cp
+}
+
+// matchesCheckpoint returns true if the checkpoint was created for the given
+// file.
+func matchesCheckpoint(f *os.File, cp checkpoint) (bool, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return false, err
+	} else if stat.Size() < cp.FingerprintSize {
+		return false, nil
+	}
+
+	fp, err := fingerprint(f, cp.FingerprintSize)
+	if err != nil {
+		return false, err
+	}
+	return fp == cp.Fingerprint, nil
+}
+
+// fingerprint returns the hex encoded hash of the first n bytes of the file.
+func fingerprint(f *os.File, n int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, n)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// segment maps a contiguous range of the followed stream to the file it was
+// read from.
+type segment struct {
+	// streamStart is the offset in the stream the segment starts at.
+	streamStart int64
+	// fileStart is the offset in the file the segment starts at.
+	fileStart int64
+	// streamLine is the amount of lines in the stream before the segment.
+	streamLine int
+	// fileLine is the amount of lines in the file before the segment.
+	fileLine int
+	// file the segment was read from. Nil, if the segment is made up of
+	// replayed data which doesn't advance the file offset.
+	file *os.File
+	// fingerprint of the file, captured when it was closed.
+	fingerprint *checkpoint
+}
+
+// followReader is an io.Reader that reads a file like "tail -F" does: Instead
+// of returning io.EOF when reaching the end of the file, it waits for more data
+// to be appended. Truncation and rotation (the file being renamed and
+// recreated) are detected and reading continues at the beginning of the new
+// file. Reading stops with the context error once the context is canceled.
+type followReader struct {
+	ctx      context.Context
+	filename string
+
+	// csv causes the first line of every file opened after rotation or
+	// truncation to be skipped, if it equals the header line already passed
+	// on.
+	csv    bool
+	header []byte
+
+	file        *os.File
+	fileOffset  int64
+	checkHeader bool
+
+	// replay is data passed on before continuing to read from the file.
+	replay []byte
+
+	mu       sync.Mutex
+	read     int64
+	lines    int
+	segments []segment
+}
+
+// newFollowReader opens the named file for following. If the checkpoint
+// matches the file, reading resumes at the checkpoint offset. For CSV content,
+// the header line stored with the checkpoint is replayed before resuming.
+func newFollowReader(ctx context.Context, filename string, cp *checkpoint, csv bool) (*followReader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fr := &followReader{
+		ctx:      ctx,
+		filename: filename,
+		csv:      csv,
+		file:     f,
+	}
+
+	if cp != nil && cp.Offset > 0 {
+		if ok, err := fr.matches(*cp); err != nil {
+			_ = f.Close()
+			return nil, err
+		} else if ok {
+			if csv {
+				fr.replay = []byte(cp.Header)
+				fr.segments = append(fr.segments, segment{})
+			}
+			if _, err = f.Seek(cp.Offset, io.SeekStart); err != nil {
+				_ = f.Close()
+				return nil, err
+			}
+			fr.fileOffset = cp.Offset
+		}
+	}
+
+	// Lines are numbered from the beginning of the file, not from where
+	// reading resumes.
+	fileLine, err := countLines(io.NewSectionReader(f, 0, fr.fileOffset))
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	fr.segments = append(fr.segments, segment{
+		streamStart: int64(len(fr.replay)),
+		fileStart:   fr.fileOffset,
+		streamLine:  bytes.Count(fr.replay, []byte{'\n'}),
+		fileLine:    fileLine,
+		file:        f,
+	})
+
+	return fr, nil
+}
+
+// matches returns true if the checkpoint was created for the currently opened
+// file.
+func (fr *followReader) matches(cp checkpoint) (bool, error) {
+	stat, err := fr.file.Stat()
+	if err != nil {
+		return false, err
+	} else if stat.Size() < cp.Offset {
+		return false, nil
+	}
+	return matchesCheckpoint(fr.file, cp)
+}
+
+// Read implements io.Reader.
+func (fr *followReader) Read(p []byte) (int, error) {
+	if len(fr.replay) > 0 {
+		n := copy(p, fr.replay)
+		fr.replay = fr.replay[n:]
+		fr.advance(p[:n])
+		return n, nil
+	}
+
+	for {
+		if fr.checkHeader {
+			if ok, err := fr.skipHeader(); err != nil {
+				return 0, err
+			} else if !ok {
+				if err = fr.wait(); err != nil {
+					return 0, err
+				}
+				continue
+			}
+		}
+
+		n, err := fr.file.Read(p)
+		fr.fileOffset += int64(n)
+
+		if n > 0 {
+			fr.advance(p[:n])
+			return n, nil
+		} else if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		// Reached the end of the file. Wait a bit and check if the file was
+		// truncated or rotated before trying again.
+		if err = fr.wait(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// wait waits for the poll interval to pass and checks the file for truncation
+// and rotation afterwards.
+func (fr *followReader) wait() error {
+	select {
+	case <-fr.ctx.Done():
+		return fr.ctx.Err()
+	case <-time.After(followPollInterval):
+	}
+	return fr.checkFile()
+}
+
+// skipHeader skips the first line of the current file, if it equals the header
+// line. It returns false if the first line is not yet complete.
+func (fr *followReader) skipHeader() (bool, error) {
+	line, err := readFirstLine(fr.file)
+	if err != nil {
+		return false, err
+	} else if !bytes.HasSuffix(line, []byte{'\n'}) {
+		return false, nil
+	}
+
+	fr.checkHeader = false
+	if !bytes.Equal(line, fr.header) {
+		return true, nil
+	}
+
+	if _, err = fr.file.Seek(int64(len(line)), io.SeekStart); err != nil {
+		return false, err
+	}
+	fr.fileOffset = int64(len(line))
+
+	fr.mu.Lock()
+	fr.segments[len(fr.segments)-1].fileStart = fr.fileOffset
+	fr.segments[len(fr.segments)-1].fileLine = 1
+	fr.mu.Unlock()
+
+	return true, nil
+}
+
+// advance records that the given data has been passed on to the reader.
+func (fr *followReader) advance(p []byte) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	// Remember the first line passed on as the header.
+	if fr.csv && !bytes.HasSuffix(fr.header, []byte{'\n'}) {
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			fr.header = append(fr.header, p[:i+1]...)
+		} else {
+			fr.header = append(fr.header, p...)
+		}
+	}
+	fr.read += int64(len(p))
+	fr.lines += bytes.Count(p, []byte{'\n'})
+}
+
+// checkFile checks if the followed file was truncated or rotated and reopens
+// it, if necessary.
+func (fr *followReader) checkFile() error {
+	current, err := fr.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if current.Size() < fr.fileOffset {
+		// The file was truncated. Start over at its beginning. Data read before
+		// the truncation can't be mapped to the file anymore.
+		if _, err = fr.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		fr.mu.Lock()
+		fr.segments[len(fr.segments)-1].file = nil
+		fr.mu.Unlock()
+		fr.startSegment(fr.file)
+		return nil
+	}
+
+	stat, err := os.Stat(fr.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		// The file was moved away but not yet recreated. Keep reading the old
+		// one, in case it is still written to.
+		return nil
+	} else if err != nil {
+		return err
+	} else if os.SameFile(current, stat) || current.Size() > fr.fileOffset {
+		// Either nothing changed or the old file has data left which must be
+		// read before switching over.
+		return nil
+	}
+
+	f, err := os.Open(fr.filename)
+	if err != nil {
+		return err
+	}
+
+	fr.mu.Lock()
+	last := &fr.segments[len(fr.segments)-1]
+	if cp, fpErr := fileCheckpoint(fr.file); fpErr == nil {
+		last.fingerprint = &cp
+	}
+	last.file = nil
+	fr.mu.Unlock()
+
+	_ = fr.file.Close()
+	fr.startSegment(f)
+
+	return nil
+}
+
+// startSegment continues reading at the beginning of the given file.
+func (fr *followReader) startSegment(f *os.File) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	fr.file = f
+	fr.fileOffset = 0
+	fr.checkHeader = fr.csv
+	fr.segments = append(fr.segments, segment{
+		streamStart: fr.read,
+		streamLine:  fr.lines,
+		file:        f,
+	})
+}
+
+// line returns the number of the nth line of the stream in the file it was
+// read from.
+func (fr *followReader) line(n int) int {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	// Find the segment the line starts in.
+	for i := len(fr.segments) - 1; i >= 0; i-- {
+		if seg := fr.segments[i]; seg.streamLine < n {
+			return seg.fileLine + n - seg.streamLine
+		}
+	}
+	return n
+}
+
+// checkpoint returns the checkpoint for the given amount of bytes read from
+// the stream. It returns false if the position doesn't map to a file offset.
+func (fr *followReader) checkpoint(n int64) (checkpoint, bool, error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	// Find the segment holding the last byte read.
+	for i := len(fr.segments) - 1; i >= 0; i-- {
+		seg := fr.segments[i]
+		if seg.streamStart > n || (seg.streamStart == n && i > 0) {
+			continue
+		}
+
+		var (
+			cp  checkpoint
+			err error
+		)
+		switch {
+		case seg.fingerprint != nil:
+			cp = *seg.fingerprint
+		case seg.file != nil:
+			if cp, err = fileCheckpoint(seg.file); err != nil {
+				return checkpoint{}, false, err
+			}
+		default:
+			return checkpoint{}, false, nil
+		}
+
+		cp.Offset = seg.fileStart + n - seg.streamStart
+		if fr.csv {
+			cp.Header = string(fr.header)
+		}
+		return cp, true, nil
+	}
+
+	return checkpoint{}, false, nil
+}
+
+// Close closes the underlying file.
+func (fr *followReader) Close() error {
+	return fr.file.Close()
+}
+
+// fileCheckpoint creates a checkpoint identifying the file.
+func fileCheckpoint(f *os.File) (checkpoint, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return checkpoint{}, err
+	}
+
+	size := stat.Size()
+	if size > fingerprintSize {
+		size = fingerprintSize
+	}
+
+	fp, err := fingerprint(f, size)
+	if err != nil {
+		return checkpoint{}, err
+	}
+
+	return checkpoint{
+		Fingerprint:     fp,
+		FingerprintSize: size,
+	}, nil
+}
+
+// countLines returns the amount of newline characters read from r.
+func countLines(r io.Reader) (int, error) {
+	var (
+		buf   = make([]byte, 32*1024)
+		lines int
+	)
+	for {
+		n, err := r.Read(buf)
+		lines += bytes.Count(buf[:n], []byte{'\n'})
+		if err == io.EOF {
+			return lines, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readFirstLine reads the first line, including the newline character, from
+// the beginning of the file.
+func readFirstLine(f *os.File) ([]byte, error) {
+	var (
+		buf = make([]byte, 4096)
+		res []byte
+		off int64
+	)
+	for {
+		n, err := f.ReadAt(buf, off)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return append(res, buf[:i+1]...), nil
+		}
+		res = append(res, buf[:n]...)
+		off += int64(n)
+
+		if err == io.EOF {
+			return res, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package ingest
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowReader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	filename := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(filename, []byte("a,b\n1,2\n"), 0o600))
+
+	fr, err := newFollowReader(ctx, filename, nil, true)
+	require.NoError(t, err)
+	defer fr.Close()
+
+	assertRead(t, fr, "a,b\n1,2\n")
+
+	cp, ok, err := fr.checkpoint(8)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.EqualValues(t, 8, cp.Offset)
+
+	// Appended data is picked up.
+	appendFile(t, filename, "3,4\n")
+	assertRead(t, fr, "3,4\n")
+
+	// Rotate the file. The header of the new file is skipped.
+	require.NoError(t, os.Rename(filename, filename+".1"))
+	require.NoError(t, os.WriteFile(filename, []byte("a,b\n5,6\n"), 0o600))
+	assertRead(t, fr, "5,6\n")
+
+	cp, ok, err = fr.checkpoint(16)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.EqualValues(t, 8, cp.Offset)
+
+	// Truncate the file.
+	require.NoError(t, os.WriteFile(filename, []byte("7,8\n"), 0o600))
+	assertRead(t, fr, "7,8\n")
+
+	// Resuming from a checkpoint replays the header.
+	cp, ok, err = fr.checkpoint(20)
+	require.NoError(t, err)
+	require.True(t, ok)
+	appendFile(t, filename, "9,0\n")
+
+	fr2, err := newFollowReader(ctx, filename, &cp, true)
+	require.NoError(t, err)
+	defer fr2.Close()
+
+	assertRead(t, fr2, "a,b\n9,0\n")
+}
+
+func TestFollowReader_Line(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	filename := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, os.WriteFile(filename, []byte("1\n2\n3\n"), 0o600))
+
+	fr, err := newFollowReader(ctx, filename, nil, false)
+	require.NoError(t, err)
+	defer fr.Close()
+
+	assertRead(t, fr, "1\n2\n3\n")
+	assert.Equal(t, 3, fr.line(3))
+
+	cp, ok, err := fr.checkpoint(6)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Lines are numbered from the beginning of the file when resuming.
+	appendFile(t, filename, "4\n5\n")
+
+	fr2, err := newFollowReader(ctx, filename, &cp, false)
+	require.NoError(t, err)
+	defer fr2.Close()
+
+	assertRead(t, fr2, "4\n5\n")
+	assert.Equal(t, 4, fr2.line(1))
+	assert.Equal(t, 5, fr2.line(2))
+
+	// And start over after a rotation.
+	require.NoError(t, os.Rename(filename, filename+".1"))
+	require.NoError(t, os.WriteFile(filename, []byte("1\n"), 0o600))
+
+	assertRead(t, fr2, "1\n")
+	assert.Equal(t, 1, fr2.line(3))
+
+	// The header replayed when resuming CSV content is the first line.
+	filename = filepath.Join(t.TempDir(), "app.csv")
+	require.NoError(t, os.WriteFile(filename, []byte("a,b\n1,2\n3,4\n"), 0o600))
+
+	cp, err = fileCheckpoint(mustOpen(t, filename))
+	require.NoError(t, err)
+	cp.Offset, cp.Header = 8, "a,b\n"
+
+	fr3, err := newFollowReader(ctx, filename, &cp, true)
+	require.NoError(t, err)
+	defer fr3.Close()
+
+	assertRead(t, fr3, "a,b\n3,4\n")
+	assert.Equal(t, 1, fr3.line(1))
+	assert.Equal(t, 3, fr3.line(2))
+}
+
+func TestCheckpoints(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	cps, err := loadCheckpoints(filename)
+	require.NoError(t, err)
+
+	_, ok := cps.get("test", "/var/log/app.log")
+	assert.False(t, ok)
+
+	require.NoError(t, cps.set("test", "/var/log/app.log", checkpoint{Offset: 42}))
+
+	cps, err = loadCheckpoints(filename)
+	require.NoError(t, err)
+
+	cp, ok := cps.get("test", "/var/log/app.log")
+	assert.True(t, ok)
+	assert.EqualValues(t, 42, cp.Offset)
+
+	// Updates are only saved once per interval, unless flushed.
+	require.NoError(t, cps.set("test", "/var/log/app.log", checkpoint{Offset: 43}))
+	require.NoError(t, cps.set("test", "/var/log/app.log", checkpoint{Offset: 44}))
+
+	saved, err := loadCheckpoints(filename)
+	require.NoError(t, err)
+	cp, _ = saved.get("test", "/var/log/app.log")
+	assert.EqualValues(t, 43, cp.Offset)
+
+	require.NoError(t, cps.flush())
+
+	saved, err = loadCheckpoints(filename)
+	require.NoError(t, err)
+	cp, _ = saved.get("test", "/var/log/app.log")
+	assert.EqualValues(t, 44, cp.Offset)
+}
+
+func TestCheckpoints_Shared(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	cps1, err := loadCheckpoints(filename)
+	require.NoError(t, err)
+	cps2, err := loadCheckpoints(filename)
+	require.NoError(t, err)
+
+	// The updates of both are kept, as they are merged with the ones on disk.
+	require.NoError(t, cps1.set("test", "/var/log/a.log", checkpoint{Offset: 1}))
+	require.NoError(t, cps2.set("test", "/var/log/b.log", checkpoint{Offset: 2}))
+
+	cps, err := loadCheckpoints(filename)
+	require.NoError(t, err)
+
+	cp, ok := cps.get("test", "/var/log/a.log")
+	assert.True(t, ok)
+	assert.EqualValues(t, 1, cp.Offset)
+	cp, ok = cps.get("test", "/var/log/b.log")
+	assert.True(t, ok)
+	assert.EqualValues(t, 2, cp.Offset)
+
+	// No temporary files are left behind.
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotEqual(t, ".tmp", filepath.Ext(entry.Name()))
+	}
+}
+
+func appendFile(t *testing.T, filename, s string) {
+	t.Helper()
+
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(s)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func mustOpen(t *testing.T, filename string) *os.File {
+	t.Helper()
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	return f
+}
+
+func assertRead(t *testing.T, r io.Reader, want string) {
+	t.Helper()
+
+	buf := make([]byte, len(want))
+	_, err := io.ReadFull(r, buf)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(buf))
+}
@@ -0,0 +1,403 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/axiomhq/axiom-go/axiom"
+)
+
+// lineFormat is a line based format which is parsed on the client side. Each
+// line is turned into an event which is ingested as newline delimited JSON.
+type lineFormat uint8
+
+// All line formats.
+const (
+	logfmtFormat        lineFormat = iota + 1 // logfmt
+	syslogRFC5424Format                       // syslog-rfc5424
+	syslogRFC3164Format                       // syslog-rfc3164
+	textFormat                                // text
+)
+
+var lineFormats = []lineFormat{
+	logfmtFormat,
+	syslogRFC5424Format,
+	syslogRFC3164Format,
+	textFormat,
+}
+
+func (f lineFormat) String() string {
+	switch f {
+	case logfmtFormat:
+		return "logfmt"
+	case syslogRFC5424Format:
+		return "syslog-rfc5424"
+	case syslogRFC3164Format:
+		return "syslog-rfc3164"
+	case textFormat:
+		return "text"
+	}
+	return fmt.Sprintf("lineFormat(%d)", f)
+}
+
+func lineFormatFromString(s string) (lineFormat, error) {
+	for _, f := range lineFormats {
+		if strings.EqualFold(s, f.String()) {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid line format %q", s)
+}
+
+// messageField is the field the raw line is stored in, if it isn't parsed
+// into distinct fields.
+const messageField = "message"
+
+var (
+	syslogRFC5424Regexp = regexp.MustCompile(`^<(\d{1,3})>(\d{1,2}) (\S+) (\S+) (\S+) (\S+) (\S+) ?(.*)$`)
+	syslogRFC3164Regexp = regexp.MustCompile(`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}) (\S+) ([^:\[\s]+)(?:\[([^\]]*)\])?:? ?(.*)$`)
+)
+
+// syslogFacilities are the names of the syslog facilities, indexed by their
+// code.
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp",
+	"cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// syslogSeverities are the names of the syslog severities, indexed by their
+// code.
+var syslogSeverities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// parse turns the line, without its trailing newline, into an event. Lines
+// which can't be parsed are kept as they are in the message field.
+func (f lineFormat) parse(line string) map[string]any {
+	var (
+		event map[string]any
+		err   error
+	)
+	switch f {
+	case logfmtFormat:
+		event, err = parseLogfmt(line)
+	case syslogRFC5424Format:
+		event, err = parseSyslogRFC5424(line)
+	case syslogRFC3164Format:
+		event, err = parseSyslogRFC3164(line, time.Now())
+	}
+	if event == nil || err != nil {
+		event = map[string]any{messageField: line}
+	}
+	return event
+}
+
+// parseLogfmt parses a line of space separated key=value pairs. Values can be
+// quoted. Keys without a value are set to true.
+func parseLogfmt(line string) (map[string]any, error) {
+	event := make(map[string]any)
+	for s := strings.TrimSpace(line); s != ""; s = strings.TrimLeft(s, " \t") {
+		i := strings.IndexAny(s, "= \t")
+		if i == 0 {
+			return nil, errors.New("missing key")
+		} else if i < 0 || s[i] != '=' {
+			if i < 0 {
+				i = len(s)
+			}
+			event[s[:i]] = true
+			s = s[i:]
+			continue
+		}
+
+		key := s[:i]
+		if strings.ContainsRune(key, '"') {
+			return nil, fmt.Errorf("invalid key %q", key)
+		}
+		s = s[i+1:]
+
+		if strings.HasPrefix(s, `"`) {
+			end := 1
+			for ; end < len(s); end++ {
+				if s[end] == '\\' {
+					end++
+				} else if s[end] == '"' {
+					break
+				}
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated value of key %q", key)
+			}
+			v, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of key %q: %w", key, err)
+			}
+			event[key], s = v, s[end+1:]
+			continue
+		}
+
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			end = len(s)
+		}
+		event[key], s = s[:end], s[end:]
+	}
+	return event, nil
+}
+
+// isLogfmt returns true if the line consists solely of key=value pairs.
+func isLogfmt(line string) bool {
+	event, err := parseLogfmt(line)
+	if err != nil || len(event) == 0 {
+		return false
+	}
+	for _, v := range event {
+		if v == true {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSyslogRFC5424 parses a syslog message as specified by RFC 5424.
+func parseSyslogRFC5424(line string) (map[string]any, error) {
+	m := syslogRFC5424Regexp.FindStringSubmatch(line)
+	if m == nil {
+		return nil, errors.New("not a RFC 5424 syslog message")
+	}
+
+	event, err := syslogPriority(m[1])
+	if err != nil {
+		return nil, err
+	}
+	event["version"], _ = strconv.Atoi(m[2])
+
+	if m[3] != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, m[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %w", err)
+		}
+		event[defaultTimestampField] = ts.Format(time.RFC3339Nano)
+	}
+	for i, name := range []string{"hostname", "appname", "procid", "msgid"} {
+		if v := m[4+i]; v != "-" {
+			event[name] = v
+		}
+	}
+
+	sd, msg, err := parseStructuredData(m[8])
+	if err != nil {
+		return nil, err
+	} else if sd != nil {
+		event["structured_data"] = sd
+	}
+	if msg = strings.TrimPrefix(msg, "\ufeff"); msg != "" {
+		event[messageField] = msg
+	}
+
+	return event, nil
+}
+
+// parseStructuredData parses the structured data element of a RFC 5424 syslog
+// message and returns it along with the remaining message.
+func parseStructuredData(s string) (map[string]any, string, error) {
+	if strings.HasPrefix(s, "-") {
+		return nil, strings.TrimPrefix(s[1:], " "), nil
+	}
+
+	sd := make(map[string]any)
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+
+		end := strings.IndexAny(s, " ]")
+		if end <= 0 {
+			return nil, "", errors.New("invalid structured data")
+		}
+		id, params := s[:end], make(map[string]any)
+		s = s[end:]
+
+		for strings.HasPrefix(s, " ") {
+			s = strings.TrimLeft(s, " ")
+
+			eq := strings.Index(s, `="`)
+			if eq <= 0 {
+				return nil, "", fmt.Errorf("invalid parameter of structured data element %q", id)
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+
+			var value strings.Builder
+			for {
+				if s == "" {
+					return nil, "", fmt.Errorf("unterminated parameter %q of structured data element %q", name, id)
+				} else if s[0] == '\\' && len(s) > 1 && strings.ContainsRune(`"\]`, rune(s[1])) {
+					_ = value.WriteByte(s[1])
+					s = s[2:]
+				} else if s[0] == '"' {
+					s = s[1:]
+					break
+				} else {
+					_ = value.WriteByte(s[0])
+					s = s[1:]
+				}
+			}
+			params[name] = value.String()
+		}
+
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", fmt.Errorf("unterminated structured data element %q", id)
+		}
+		s = s[1:]
+		sd[id] = params
+	}
+
+	if len(sd) == 0 {
+		return nil, "", errors.New("missing structured data")
+	}
+	return sd, strings.TrimPrefix(s, " "), nil
+}
+
+// parseSyslogRFC3164 parses a BSD syslog message as described by RFC 3164. The
+// timestamp of such messages doesn't carry a year, so it is assumed to be the
+// most recent one which doesn't put the timestamp into the future, relative to
+// the given time.
+func parseSyslogRFC3164(line string, now time.Time) (map[string]any, error) {
+	m := syslogRFC3164Regexp.FindStringSubmatch(line)
+	if m == nil {
+		return nil, errors.New("not a RFC 3164 syslog message")
+	}
+
+	event := make(map[string]any)
+	if m[1] != "" {
+		var err error
+		if event, err = syslogPriority(m[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	ts, err := time.ParseInLocation(time.Stamp, m[2], now.Location())
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	ts = ts.AddDate(now.Year(), 0, 0)
+	if ts.After(now.Add(24 * time.Hour)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	event[defaultTimestampField] = ts.Format(time.RFC3339Nano)
+
+	event["hostname"] = m[3]
+	event["appname"] = m[4]
+	if m[5] != "" {
+		event["procid"] = m[5]
+	}
+	if m[6] != "" {
+		event[messageField] = m[6]
+	}
+
+	return event, nil
+}
+
+// syslogPriority decodes the priority value of a syslog message into the
+// fields of a new event.
+func syslogPriority(s string) (map[string]any, error) {
+	pri, err := strconv.Atoi(s)
+	if err != nil || pri > 191 {
+		return nil, fmt.Errorf("invalid priority %q", s)
+	}
+	return map[string]any{
+		"priority": pri,
+		"facility": syslogFacilities[pri/8],
+		"severity": syslogSeverities[pri%8],
+	}, nil
+}
+
+// sniffSize is the maximum number of bytes looked at to detect the content
+// type of data.
+const sniffSize = 64 * 1024
+
+// sniffContentType detects the content type of the data read from r by looking
+// at its first non-empty line, of which at most sniffSize bytes are considered.
+// It only reads until that line is complete, so data that keeps coming, like
+// a followed file, is detected as soon as its first line arrived. Line formats
+// are detected as newline delimited JSON along with the line format the data
+// has to be converted from. Text that can't be recognized otherwise is treated
+// as text, while binary data is rejected. The returned io.Reader must be used
+// instead of the passed one.
+func sniffContentType(r io.Reader, delimiter string) (io.Reader, axiom.ContentType, lineFormat, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+
+	// Peeking one byte more than buffered reads once from r, at most.
+	var err error
+	for n := 1; n <= sniffSize; n = br.Buffered() + 1 {
+		if _, err = br.Peek(n); err != nil {
+			break
+		}
+		b, _ := br.Peek(br.Buffered())
+		if bytes.IndexByte(bytes.TrimLeftFunc(b, unicode.IsSpace), '\n') >= 0 {
+			break
+		}
+	}
+	if err != nil && err != io.EOF {
+		return nil, 0, 0, err
+	}
+
+	b, _ := br.Peek(br.Buffered())
+	b = bytes.TrimLeftFunc(b, unicode.IsSpace)
+	if len(b) == 0 {
+		return nil, 0, 0, errors.New("couldn't find beginning of supported ingestion format")
+	}
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	if !isText(b) {
+		return nil, 0, 0, errors.New("cannot determine content type")
+	}
+	line := strings.TrimSpace(string(b))
+	r = br
+
+	if delimiter == "" {
+		delimiter = ","
+	}
+
+	switch c := []rune(line)[0]; {
+	case c == '[':
+		return r, axiom.JSON, 0, nil
+	case c == '{':
+		return r, axiom.NDJSON, 0, nil
+	case syslogRFC5424Regexp.MatchString(line):
+		return r, axiom.NDJSON, syslogRFC5424Format, nil
+	case syslogRFC3164Regexp.MatchString(line):
+		return r, axiom.NDJSON, syslogRFC3164Format, nil
+	case isLogfmt(line):
+		return r, axiom.NDJSON, logfmtFormat, nil
+	case (unicode.IsLetter(c) || c == '"') && strings.Contains(line, delimiter):
+		// We assume a CSV table starts with a letter or a quote.
+		return r, axiom.CSV, 0, nil
+	}
+	return r, axiom.NDJSON, textFormat, nil
+}
+
+// isText reports whether b is UTF-8 encoded text without control characters
+// other than whitespace. An incomplete rune at the end of b is ignored, as b
+// might be cut off.
+func isText(b []byte) bool {
+	for len(b) > 0 {
+		c, size := utf8.DecodeRune(b)
+		if c == utf8.RuneError && size == 1 {
+			return !utf8.FullRune(b)
+		} else if unicode.IsControl(c) && !unicode.IsSpace(c) {
+			return false
+		}
+		b = b[size:]
+	}
+	return true
+}
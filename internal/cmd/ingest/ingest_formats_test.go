@@ -0,0 +1,185 @@
+package ingest
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineFormat_Parse(t *testing.T) {
+	tests := []struct {
+		format lineFormat
+		line   string
+		want   map[string]any
+	}{
+		{
+			format: logfmtFormat,
+			line:   `level=info msg="hello \"world\"" took=12ms debug`,
+			want: map[string]any{
+				"level": "info",
+				"msg":   `hello "world"`,
+				"took":  "12ms",
+				"debug": true,
+			},
+		},
+		{
+			format: logfmtFormat,
+			line:   `msg="unterminated`,
+			want:   map[string]any{"message": `msg="unterminated`},
+		},
+		{
+			format: syslogRFC5424Format,
+			line:   `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication"] An application event`,
+			want: map[string]any{
+				"priority": 165,
+				"facility": "local4",
+				"severity": "notice",
+				"version":  1,
+				"_time":    "2003-10-11T22:14:15.003Z",
+				"hostname": "mymachine.example.com",
+				"appname":  "evntslog",
+				"msgid":    "ID47",
+				"structured_data": map[string]any{
+					"exampleSDID@32473": map[string]any{
+						"iut":         "3",
+						"eventSource": `App"lication`,
+					},
+				},
+				"message": "An application event",
+			},
+		},
+		{
+			format: syslogRFC5424Format,
+			line:   `<34>1 - - su - - - 'su root' failed`,
+			want: map[string]any{
+				"priority": 34,
+				"facility": "auth",
+				"severity": "crit",
+				"version":  1,
+				"appname":  "su",
+				"message":  "'su root' failed",
+			},
+		},
+		{
+			format: textFormat,
+			line:   "Exception in thread main",
+			want:   map[string]any{"message": "Exception in thread main"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format.String(), func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.format.parse(tt.line))
+		})
+	}
+}
+
+func TestParseSyslogRFC3164(t *testing.T) {
+	now := time.Date(2022, time.January, 5, 0, 0, 0, 0, time.UTC)
+
+	event, err := parseSyslogRFC3164("<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick", now)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]any{
+		"priority": 34,
+		"facility": "auth",
+		"severity": "crit",
+		"_time":    "2021-10-11T22:14:15Z",
+		"hostname": "mymachine",
+		"appname":  "su",
+		"procid":   "123",
+		"message":  "'su root' failed for lonvick",
+	}, event)
+
+	event, err = parseSyslogRFC3164("Jan  4 08:00:00 host cron: job done", now)
+	require.NoError(t, err)
+
+	assert.Equal(t, "2022-01-04T08:00:00Z", event["_time"])
+	assert.Equal(t, "cron", event["appname"])
+	assert.Equal(t, "job done", event["message"])
+}
+
+func TestSniffContentType(t *testing.T) {
+	tests := []struct {
+		input      string
+		wantType   axiom.ContentType
+		wantFormat lineFormat
+	}{
+		{"\n[{}]", axiom.JSON, 0},
+		{`{"a":1}`, axiom.NDJSON, 0},
+		{"a,b\n1,2", axiom.CSV, 0},
+		{`level=info msg="started"`, axiom.NDJSON, logfmtFormat},
+		{"<34>1 2003-10-11T22:14:15.003Z host su - ID47 - failed", axiom.NDJSON, syslogRFC5424Format},
+		{"<34>Oct 11 22:14:15 host su: failed", axiom.NDJSON, syslogRFC3164Format},
+		{"Oct 11 22:14:15 host su: failed", axiom.NDJSON, syslogRFC3164Format},
+		{"Starting server on port 8080", axiom.NDJSON, textFormat},
+		// Lines longer than the data looked at are detected by their prefix.
+		{`{"msg":"` + strings.Repeat("x", sniffSize) + `"}`, axiom.NDJSON, 0},
+		{strings.Repeat("ü", sniffSize), axiom.NDJSON, textFormat},
+	}
+	for _, tt := range tests {
+		r, typ, format, err := sniffContentType(strings.NewReader(tt.input), "")
+		require.NoError(t, err)
+
+		assert.Equal(t, tt.wantType, typ, tt.input)
+		assert.Equal(t, tt.wantFormat, format, tt.input)
+
+		// The data read to detect the content type is not lost.
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, tt.input, string(b))
+	}
+}
+
+func TestSniffContentType_Stream(t *testing.T) {
+	// The writing end stays open, like a followed file or stdin.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	go func() {
+		_, _ = pw.Write([]byte("\n"))
+		_, _ = pw.Write([]byte(`{"a":`))
+		_, _ = pw.Write([]byte("1}\n"))
+	}()
+
+	type result struct {
+		typ axiom.ContentType
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		_, typ, _, err := sniffContentType(pr, "")
+		done <- result{typ, err}
+	}()
+
+	select {
+	case res := <-done:
+		require.NoError(t, res.err)
+		assert.Equal(t, axiom.NDJSON, res.typ)
+	case <-time.After(5 * time.Second):
+		t.Fatal("content type not detected after the first line")
+	}
+}
+
+func TestSniffContentType_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"empty", "", "couldn't find beginning of supported ingestion format"},
+		{"whitespace", " \n\t\n", "couldn't find beginning of supported ingestion format"},
+		{"binary", "\x1f\x8b\x08\x00", "cannot determine content type"},
+		{"invalid UTF-8", "abc\xff\xfedef", "cannot determine content type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := sniffContentType(strings.NewReader(tt.input), "")
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,441 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// importFormat is the export format of another tool. Its envelope is
+// unwrapped on the client side and each entry is ingested as newline delimited
+// JSON, with the timestamp of the tool in the "_time" field.
+type importFormat uint8
+
+// All import formats.
+const (
+	elasticsearchImport importFormat = iota + 1 // elasticsearch
+	lokiImport                                  // loki
+	cloudwatchImport                            // cloudwatch
+	journaldImport                              // journald
+)
+
+var importFormats = []importFormat{
+	elasticsearchImport,
+	lokiImport,
+	cloudwatchImport,
+	journaldImport,
+}
+
+func (f importFormat) String() string {
+	switch f {
+	case elasticsearchImport:
+		return "elasticsearch"
+	case lokiImport:
+		return "loki"
+	case cloudwatchImport:
+		return "cloudwatch"
+	case journaldImport:
+		return "journald"
+	}
+	return fmt.Sprintf("importFormat(%d)", f)
+}
+
+func importFormatFromString(s string) (importFormat, error) {
+	for _, f := range importFormats {
+		if strings.EqualFold(s, f.String()) {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid import format %q", s)
+}
+
+// maxJournalFieldSize is the maximum size of a binary field of a journal
+// entry in the export format.
+const maxJournalFieldSize = 64 * 1024 * 1024
+
+// toNDJSON returns a reader that converts the entries read from r to newline
+// delimited JSON. Text fields of journal entries in the export format are
+// limited to the configured maximum line size.
+func (f importFormat) toNDJSON(r io.Reader, opts *options) io.Reader {
+	pr, pw := io.Pipe()
+
+	go func() {
+		enc := json.NewEncoder(pw)
+		emit := func(event map[string]any) error {
+			return enc.Encode(event)
+		}
+
+		var err error
+		switch f {
+		case elasticsearchImport:
+			err = decodeElasticsearch(r, emit)
+		case lokiImport:
+			err = decodeLoki(r, emit)
+		case cloudwatchImport:
+			err = decodeCloudWatch(r, emit)
+		case journaldImport:
+			maxFieldSize := int(opts.MaxLineSize)
+			if maxFieldSize <= 0 {
+				maxFieldSize = defaultMaxLineSize
+			}
+			err = decodeJournal(r, maxFieldSize, emit)
+		default:
+			err = fmt.Errorf("unsupported import format %s", f)
+		}
+		_ = pw.CloseWithError(err)
+	}()
+
+	return pr
+}
+
+// decodeJSONValues calls fn with every JSON value read from r, until the end
+// of r is reached. Numbers are kept as json.Number.
+func decodeJSONValues(r io.Reader, fn func(v any) error) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	for {
+		var v any
+		if err := dec.Decode(&v); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if err = fn(v); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeElasticsearch decodes Elasticsearch bulk requests, as well as scroll
+// and search responses and the documents they hold, as written by most export
+// tools. The source of each document is emitted, with its "@timestamp" field
+// renamed to "_time".
+func decodeElasticsearch(r io.Reader, emit func(map[string]any) error) error {
+	// action is the bulk action whose source is expected next.
+	var action string
+
+	emitDocument := func(doc map[string]any) error {
+		if ts, ok := doc["@timestamp"]; ok {
+			if _, ok = doc[defaultTimestampField]; !ok {
+				doc[defaultTimestampField] = ts
+				delete(doc, "@timestamp")
+			}
+		}
+		return emit(doc)
+	}
+
+	var decode func(v any) error
+	decode = func(v any) error {
+		if action != "" {
+			doc, ok := v.(map[string]any)
+			if !ok {
+				return fmt.Errorf("invalid source of bulk %s action", action)
+			}
+
+			// Updates hold a partial document, if any.
+			if action == "update" {
+				doc, _ = doc["doc"].(map[string]any)
+			}
+			action = ""
+
+			if doc == nil {
+				return nil
+			}
+			return emitDocument(doc)
+		}
+
+		switch v := v.(type) {
+		case []any:
+			for _, elem := range v {
+				if err := decode(elem); err != nil {
+					return err
+				}
+			}
+			return nil
+		case map[string]any:
+			// A scroll or search response.
+			if hits, ok := v["hits"].(map[string]any); ok {
+				return decode(hits["hits"])
+			}
+
+			// A document of a response.
+			if src, ok := v["_source"].(map[string]any); ok {
+				return emitDocument(src)
+			}
+
+			// A bulk action, followed by its source, unless it is a delete.
+			if len(v) == 1 {
+				for name, meta := range v {
+					if _, ok := meta.(map[string]any); !ok {
+						break
+					}
+					switch name {
+					case "index", "create", "update":
+						action = name
+						return nil
+					case "delete":
+						return nil
+					}
+				}
+			}
+
+			// A plain document.
+			return emitDocument(v)
+		case nil:
+			return nil
+		}
+		return fmt.Errorf("unexpected JSON value %v", v)
+	}
+
+	if err := decodeJSONValues(r, decode); err != nil {
+		return err
+	} else if action != "" {
+		return fmt.Errorf("missing source of bulk %s action", action)
+	}
+	return nil
+}
+
+// decodeLoki decodes the entries written by "logcli query --output=jsonl".
+// Each entry becomes an event holding the line in the "message" field, the
+// labels of its stream and its timestamp in the "_time" field.
+func decodeLoki(r io.Reader, emit func(map[string]any) error) error {
+	return decodeJSONValues(r, func(v any) error {
+		entry, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("invalid Loki entry %v: must be an object", v)
+		}
+
+		labels, _ := entry["labels"].(map[string]any)
+		event := make(map[string]any, len(labels)+2)
+		for k, v := range labels {
+			event[k] = v
+		}
+		if ts, ok := entry["timestamp"]; ok {
+			event[defaultTimestampField] = ts
+		}
+		event[messageField] = entry["line"]
+
+		return emit(event)
+	})
+}
+
+// decodeCloudWatch decodes CloudWatch Logs subscription data, as delivered to
+// S3 or Kinesis, and the output of "aws logs filter-log-events" and
+// "aws logs get-log-events". Each log event becomes an event holding its
+// timestamp in the "_time" field and the log group and stream it belongs to,
+// if known.
+func decodeCloudWatch(r io.Reader, emit func(map[string]any) error) error {
+	emitLogEvents := func(v any, logGroup, logStream any) error {
+		logEvents, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("invalid CloudWatch log events %v: must be an array", v)
+		}
+
+		for _, v := range logEvents {
+			event, ok := v.(map[string]any)
+			if !ok {
+				return fmt.Errorf("invalid CloudWatch log event %v: must be an object", v)
+			}
+
+			if ts, ok := event["timestamp"].(json.Number); ok {
+				msec, err := ts.Int64()
+				if err != nil {
+					return fmt.Errorf("invalid CloudWatch timestamp %q: %w", ts, err)
+				}
+				event[defaultTimestampField] = time.UnixMilli(msec).UTC().Format(time.RFC3339Nano)
+				delete(event, "timestamp")
+			}
+			if logGroup != nil {
+				event["logGroup"] = logGroup
+			}
+			if logStream != nil {
+				event["logStream"] = logStream
+			}
+
+			if err := emit(event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return decodeJSONValues(r, func(v any) error {
+		data, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("invalid CloudWatch data %v: must be an object", v)
+		}
+
+		switch {
+		case data["messageType"] == "CONTROL_MESSAGE":
+			return nil
+		case data["logEvents"] != nil:
+			return emitLogEvents(data["logEvents"], data["logGroup"], data["logStream"])
+		case data["events"] != nil:
+			return emitLogEvents(data["events"], nil, nil)
+		}
+		return errors.New("invalid CloudWatch data: neither log events nor events found")
+	})
+}
+
+// decodeJournal decodes journal entries as written by "journalctl -o export"
+// or "journalctl -o json". Each entry becomes an event holding its fields, with
+// the "MESSAGE" field renamed to "message" and its realtime timestamp in the
+// "_time" field. Text fields of the export format must not exceed the given
+// size.
+func decodeJournal(r io.Reader, maxFieldSize int, emit func(map[string]any) error) error {
+	br := bufio.NewReader(r)
+
+	// The JSON format starts with an object, the export format with a field
+	// name.
+	for {
+		b, err := br.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		_, _ = br.ReadByte()
+	}
+
+	emitEntry := func(entry map[string]any) error {
+		event, err := journalEvent(entry)
+		if err != nil {
+			return err
+		}
+		return emit(event)
+	}
+
+	if b, _ := br.Peek(1); b[0] == '{' {
+		return decodeJSONValues(br, func(v any) error {
+			entry, ok := v.(map[string]any)
+			if !ok {
+				return fmt.Errorf("invalid journal entry %v: must be an object", v)
+			}
+			return emitEntry(entry)
+		})
+	}
+
+	entry := make(map[string]any)
+	add := func(name, value string) {
+		switch prev := entry[name].(type) {
+		case nil:
+			entry[name] = value
+		case []any:
+			entry[name] = append(prev, value)
+		default:
+			entry[name] = []any{prev, value}
+		}
+	}
+
+	for {
+		// The newline is not part of the field.
+		line, err := readUntil(br, '\n', maxFieldSize+1)
+		if errors.Is(err, errFrameTooLarge) {
+			name, _, _ := strings.Cut(line, "=")
+			return fmt.Errorf("journal field %q exceeds the maximum line size of %s (see --max-line-size)",
+				name, humanize.IBytes(uint64(maxFieldSize)))
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		// An empty line terminates an entry, as does the end of the data.
+		if line = strings.TrimSuffix(line, "\n"); line == "" {
+			if len(entry) > 0 {
+				if err := emitEntry(entry); err != nil {
+					return err
+				}
+				entry = make(map[string]any)
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			continue
+		}
+
+		if name, value, ok := strings.Cut(line, "="); ok {
+			add(name, value)
+		} else if err == nil {
+			// Binary fields are followed by their size as little endian
+			// 64 bit integer, their data and a newline.
+			var size uint64
+			if err = binary.Read(br, binary.LittleEndian, &size); err != nil {
+				return fmt.Errorf("invalid size of journal field %q: %w", line, err)
+			} else if size > maxJournalFieldSize {
+				return fmt.Errorf("size %d of journal field %q exceeds %d bytes", size, line, maxJournalFieldSize)
+			}
+			data := make([]byte, size+1)
+			if _, err = io.ReadFull(br, data); err != nil {
+				return fmt.Errorf("invalid journal field %q: %w", line, err)
+			}
+			add(line, string(data[:size]))
+		} else {
+			return fmt.Errorf("invalid journal field %q", line)
+		}
+	}
+}
+
+// journalEvent turns a journal entry into an event. Binary values, which the
+// JSON format writes as arrays of bytes, are converted to strings.
+func journalEvent(entry map[string]any) (map[string]any, error) {
+	for k, v := range entry {
+		entry[k] = journalValue(v)
+	}
+
+	if msg, ok := entry["MESSAGE"]; ok {
+		entry[messageField] = msg
+		delete(entry, "MESSAGE")
+	}
+
+	if ts, ok := entry["__REALTIME_TIMESTAMP"].(string); ok {
+		usec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid journal timestamp %q: %w", ts, err)
+		}
+		entry[defaultTimestampField] = time.UnixMicro(usec).UTC().Format(time.RFC3339Nano)
+		delete(entry, "__REALTIME_TIMESTAMP")
+	}
+
+	return entry, nil
+}
+
+// journalValue converts a value of the JSON format that is an array of bytes
+// to a string. Arrays of values, which are written for fields that occur
+// more than once, are converted element by element.
+func journalValue(v any) any {
+	arr, ok := v.([]any)
+	if !ok || len(arr) == 0 {
+		return v
+	}
+
+	data := make([]byte, 0, len(arr))
+	for _, elem := range arr {
+		n, ok := elem.(json.Number)
+		if !ok {
+			break
+		}
+		b, err := strconv.ParseUint(n.String(), 10, 8)
+		if err != nil {
+			break
+		}
+		data = append(data, byte(b))
+	}
+	if len(data) == len(arr) {
+		return string(data)
+	}
+
+	res := make([]any, len(arr))
+	for i, elem := range arr {
+		res[i] = journalValue(elem)
+	}
+	return res
+}
@@ -0,0 +1,120 @@
+package ingest
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportFormat_ToNDJSON(t *testing.T) {
+	tests := []struct {
+		file   string
+		format importFormat
+		want   []string
+	}{
+		{
+			file:   "elasticsearch-bulk.ndjson.gz",
+			format: elasticsearchImport,
+			want: []string{
+				`{"_time":"2022-01-01T00:00:00Z","level":"info","message":"GET /index.html 200","service":{"name":"web"}}`,
+				`{"_time":"2022-01-01T00:00:01Z","level":"warn","message":"GET /missing 404","service":{"name":"web"}}`,
+				`{"_time":"2022-01-01T00:00:02Z","level":"error","message":"POST /api 500","service":{"name":"web"}}`,
+				`{"_time":"2022-01-01T00:00:01Z","level":"warn","message":"GET /missing 404","reviewed":true,"service":{"name":"web"}}`,
+			},
+		},
+		{
+			file:   "elasticsearch-scroll.json.gz",
+			format: elasticsearchImport,
+			want: []string{
+				`{"_time":"2022-01-01T01:00:00Z","level":"info","message":"user logged in"}`,
+				`{"_time":"2022-01-01T01:00:01Z","level":"info","message":"user logged out"}`,
+				`{"_time":"2022-01-01T01:00:02Z","level":"error","message":"session expired"}`,
+			},
+		},
+		{
+			file:   "loki.jsonl.gz",
+			format: lokiImport,
+			want: []string{
+				`{"_time":"2022-01-01T00:00:00.123456789Z","app":"web","env":"prod","message":"GET /index.html 200"}`,
+				`{"_time":"2022-01-01T00:00:01Z","app":"web","env":"prod","message":"GET /missing 404"}`,
+				`{"_time":"2022-01-01T00:00:02Z","app":"worker","env":"prod","message":"job finished"}`,
+			},
+		},
+		{
+			file:   "cloudwatch.json.gz",
+			format: cloudwatchImport,
+			want: []string{
+				`{"_time":"2022-01-01T00:00:00Z","id":"36587186587265378888","logGroup":"/aws/lambda/checkout","logStream":"2022/01/01/[$LATEST]abcdef","message":"START RequestId: 1 Version: $LATEST"}`,
+				`{"_time":"2022-01-01T00:00:00.123Z","id":"36587186587265378889","logGroup":"/aws/lambda/checkout","logStream":"2022/01/01/[$LATEST]abcdef","message":"{\"level\":\"info\",\"msg\":\"order placed\"}"}`,
+				`{"_time":"2022-01-01T00:00:00.456Z","id":"36587186587265378890","logGroup":"/aws/lambda/checkout","logStream":"2022/01/01/[$LATEST]abcdef","message":"END RequestId: 1"}`,
+				`{"_time":"2022-01-01T00:00:01Z","eventId":"36587186587265378891","ingestionTime":1640995201500,"logStreamName":"i-0abc","message":"kernel: eth0 up"}`,
+			},
+		},
+		{
+			file:   "journald.export.gz",
+			format: journaldImport,
+			want: []string{
+				`{"PRIORITY":"6","SYSLOG_IDENTIFIER":"sshd","_BOOT_ID":"b1","_HOSTNAME":"host1","_PID":"42","__CURSOR":"s=1;i=1","__MONOTONIC_TIMESTAMP":"1000","_time":"2022-01-01T00:00:00Z","message":"Accepted publickey for root"}`,
+				`{"PRIORITY":"3","SYSLOG_IDENTIFIER":"app","_BOOT_ID":"b1","_HOSTNAME":"host1","__CURSOR":"s=1;i=2","__MONOTONIC_TIMESTAMP":"2500","_time":"2022-01-01T00:00:01.5Z","message":"line one\nline two"}`,
+			},
+		},
+		{
+			file:   "journald.json.gz",
+			format: journaldImport,
+			want: []string{
+				`{"PRIORITY":"6","SYSLOG_IDENTIFIER":"sshd","_BOOT_ID":"b1","_HOSTNAME":"host1","_PID":"42","__CURSOR":"s=1;i=1","__MONOTONIC_TIMESTAMP":"1000","_time":"2022-01-01T00:00:00Z","message":"Accepted publickey for root"}`,
+				`{"PRIORITY":"3","SYSLOG_IDENTIFIER":"app","_BOOT_ID":"b1","_HOSTNAME":"host1","__CURSOR":"s=1;i=2","__MONOTONIC_TIMESTAMP":"2500","_time":"2022-01-01T00:00:01.5Z","message":"line one\nline two"}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("..", "..", "..", "testdata", tt.file))
+			require.NoError(t, err)
+			defer f.Close()
+
+			gr, err := gzip.NewReader(f)
+			require.NoError(t, err)
+
+			b, err := io.ReadAll(tt.format.toNDJSON(gr, &options{}))
+			require.NoError(t, err)
+
+			assert.Equal(t, strings.Join(tt.want, "\n")+"\n", string(b))
+		})
+	}
+}
+
+func TestImportFormat_ToNDJSON_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		format importFormat
+		input  string
+	}{
+		{"bulk action without source", elasticsearchImport, `{"index":{"_index":"logs"}}`},
+		{"loki entry not an object", lokiImport, `["line"]`},
+		{"cloudwatch without events", cloudwatchImport, `{"logGroup":"/aws/lambda/checkout"}`},
+		{"journal binary field truncated", journaldImport, "MESSAGE\n\x05\x00\x00\x00\x00\x00\x00\x00ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := io.ReadAll(tt.format.toNDJSON(strings.NewReader(tt.input), &options{}))
+			assert.Error(t, err)
+		})
+	}
+
+	// Text fields of the journal export format are limited to the maximum
+	// line size.
+	opts := &options{MaxLineSize: 18}
+	b, err := io.ReadAll(journaldImport.toNDJSON(strings.NewReader("MESSAGE=0123456789\n\n"), opts))
+	require.NoError(t, err)
+	assert.Equal(t, `{"message":"0123456789"}`+"\n", string(b))
+
+	_, err = io.ReadAll(journaldImport.toNDJSON(strings.NewReader("MESSAGE=0123456789abcdef\n\n"), opts))
+	assert.EqualError(t, err, `journal field "MESSAGE" exceeds the maximum line size of 18 B (see --max-line-size)`)
+}
@@ -0,0 +1,212 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/axiomhq/cli/pkg/terminal"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+// Handling of lines exceeding the maximum line size.
+const (
+	oversizedFail     = "fail"
+	oversizedTruncate = "truncate"
+	oversizedSkip     = "skip"
+)
+
+var validOversizedModes = []string{oversizedFail, oversizedTruncate, oversizedSkip}
+
+// oversizedLines counts the lines exceeding the maximum line size that have
+// been truncated or skipped. It is safe for concurrent use.
+type oversizedLines struct {
+	mu        sync.Mutex
+	truncated int
+	skipped   int
+}
+
+func (o *oversizedLines) add(mode string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	switch mode {
+	case oversizedTruncate:
+		o.truncated++
+	case oversizedSkip:
+		o.skipped++
+	}
+}
+
+func (o *oversizedLines) counts() (truncated, skipped int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.truncated, o.skipped
+}
+
+// printSummary writes the amount of truncated and skipped lines to the
+// standard error, if any.
+func (o *oversizedLines) printSummary(io *terminal.IO) {
+	var (
+		cs                 = io.ColorScheme()
+		truncated, skipped = o.counts()
+	)
+	if truncated > 0 {
+		fmt.Fprintf(io.ErrOut(), "%s Truncated %s exceeding the maximum line size\n",
+			cs.WarningIcon(), utils.Pluralize(cs, "line", truncated))
+	}
+	if skipped > 0 {
+		fmt.Fprintf(io.ErrOut(), "%s Skipped %s exceeding the maximum line size\n",
+			cs.WarningIcon(), utils.Pluralize(cs, "line", skipped))
+	}
+}
+
+// lineChunkSize is the size after which the lines read are passed on as a
+// chunk.
+const lineChunkSize = 64 * 1024
+
+// lineChunk is a chunk of complete lines read from a source.
+type lineChunk struct {
+	// data holds the lines, including their trailing newline. Only the last
+	// line of a source might not be terminated by one.
+	data []byte
+	// n is the amount of bytes read from the source for the chunk. It only
+	// differs from the length of data, if an oversized line was truncated or
+	// skipped, which is always the last line of a chunk.
+	n int64
+}
+
+// lineReader reads chunks of lines and handles lines exceeding the maximum
+// line size as configured. Its memory use is bounded by the chunk size and
+// the maximum line size, regardless of the length of the lines read.
+type lineReader struct {
+	br      *bufio.Reader
+	maxSize int
+	mode    string
+	opts    *options
+	lines   int
+}
+
+func newLineReader(r io.Reader, opts *options) *lineReader {
+	lr := &lineReader{
+		br:      bufio.NewReaderSize(r, lineChunkSize),
+		maxSize: int(opts.MaxLineSize),
+		mode:    opts.OversizedLines,
+		opts:    opts,
+	}
+	if lr.maxSize <= 0 {
+		lr.maxSize = defaultMaxLineSize
+	}
+	if lr.mode == "" {
+		lr.mode = oversizedFail
+	}
+	return lr
+}
+
+// next reads the next chunk of lines. It returns io.EOF along with the last
+// chunk, once the end of the source is reached. A chunk holds at least one
+// line, but it doesn't wait for further lines, if they haven't been written to
+// the source yet, so streams are not held back.
+func (lr *lineReader) next() (lineChunk, error) {
+	var chunk lineChunk
+	for len(chunk.data) < lineChunkSize && (chunk.n == 0 || lr.lineBuffered()) {
+		start := len(chunk.data)
+
+		var (
+			n    int64
+			size int
+			err  error
+		)
+		chunk.data, n, size, err = lr.readLine(chunk.data)
+		chunk.n += n
+		if n > 0 {
+			lr.lines++
+		}
+
+		if size > lr.maxSize {
+			switch lr.mode {
+			case oversizedTruncate:
+			case oversizedSkip:
+				chunk.data = chunk.data[:start]
+			default:
+				return lineChunk{}, fmt.Errorf("line %d exceeds the maximum line size of %s (see --max-line-size and --oversized-lines)",
+					lr.lines, humanize.IBytes(uint64(lr.maxSize)))
+			}
+			lr.opts.oversized.add(lr.mode)
+			return chunk, err
+		} else if err != nil {
+			return chunk, err
+		}
+	}
+	return chunk, nil
+}
+
+// lineBuffered reports whether a complete line has already been read from the
+// source and is buffered, so it can be read without blocking.
+func (lr *lineReader) lineBuffered() bool {
+	b, _ := lr.br.Peek(lr.br.Buffered())
+	return bytes.IndexByte(b, '\n') >= 0
+}
+
+// readLine appends the next line to dst. Of lines exceeding the maximum line
+// size, only as many bytes as allowed and the trailing newline are appended.
+// It returns the amount of bytes read and the size of the line, without its
+// trailing newline.
+func (lr *lineReader) readLine(dst []byte) ([]byte, int64, int, error) {
+	var (
+		n    int64
+		size int
+	)
+	for {
+		frag, err := lr.br.ReadSlice('\n')
+		n += int64(len(frag))
+
+		eol := err == nil
+		if eol {
+			frag = frag[:len(frag)-1]
+		}
+
+		if room := lr.maxSize - size; room > 0 {
+			if len(frag) > room {
+				dst = append(dst, frag[:room]...)
+			} else {
+				dst = append(dst, frag...)
+			}
+		}
+		size += len(frag)
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		} else if eol {
+			dst = append(dst, '\n')
+		}
+		return dst, n, size, err
+	}
+}
+
+// errFrameTooLarge is returned by readUntil, if the delimiter isn't found
+// within the maximum amount of bytes.
+var errFrameTooLarge = errors.New("frame too large")
+
+// readUntil reads until the first occurrence of the delimiter, like
+// bufio.Reader.ReadString, but reads no more than max bytes. If the delimiter
+// isn't found within them, it returns what has been read and errFrameTooLarge.
+func readUntil(br *bufio.Reader, delim byte, max int) (string, error) {
+	var b []byte
+	for {
+		frag, err := br.ReadSlice(delim)
+		if len(b)+len(frag) > max {
+			return string(append(b, frag[:max-len(b)]...)), errFrameTooLarge
+		}
+		b = append(b, frag...)
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return string(b), err
+		}
+	}
+}
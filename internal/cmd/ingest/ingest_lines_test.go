@@ -0,0 +1,113 @@
+package ingest
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineReader(t *testing.T) {
+	input := "short\n" + strings.Repeat("x", 20) + "\nafter\nlast"
+
+	tests := []struct {
+		mode          string
+		want          string
+		wantTruncated int
+		wantSkipped   int
+		wantErr       string
+	}{
+		{
+			mode:          oversizedTruncate,
+			want:          "short\nxxxxxxxx\nafter\nlast",
+			wantTruncated: 1,
+		},
+		{
+			mode:        oversizedSkip,
+			want:        "short\nafter\nlast",
+			wantSkipped: 1,
+		},
+		{
+			mode:    oversizedFail,
+			wantErr: "line 2 exceeds the maximum line size of 8 B",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			opts := &options{MaxLineSize: 8, OversizedLines: tt.mode}
+			lr := newLineReader(strings.NewReader(input), opts)
+
+			var (
+				got strings.Builder
+				n   int64
+				err error
+			)
+			for {
+				var chunk lineChunk
+				chunk, err = lr.next()
+				got.Write(chunk.data)
+				n += chunk.n
+				if err != nil {
+					break
+				}
+			}
+
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.True(t, errors.Is(err, io.EOF), err)
+
+			assert.Equal(t, tt.want, got.String())
+			assert.EqualValues(t, len(input), n)
+
+			truncated, skipped := opts.oversized.counts()
+			assert.Equal(t, tt.wantTruncated, truncated)
+			assert.Equal(t, tt.wantSkipped, skipped)
+		})
+	}
+}
+
+func TestOptions_BatchFull(t *testing.T) {
+	opts := &options{BatchBytes: 100, BatchEvents: 3}
+
+	assert.False(t, opts.batchFull(99, 2))
+	assert.True(t, opts.batchFull(100, 1))
+	assert.True(t, opts.batchFull(10, 3))
+
+	opts = &options{}
+	assert.False(t, opts.batchFull(defaultBatchSize-1, 1000000))
+	assert.True(t, opts.batchFull(defaultBatchSize, 1))
+}
+
+func TestOptions_BatchFull_Throttled(t *testing.T) {
+	opts := &options{BatchBytes: 100, BatchEvents: 3, MaxBytesPerSecond: 50, MaxEventsPerSecond: 2}
+
+	assert.False(t, opts.batchFull(49, 1))
+	assert.True(t, opts.batchFull(50, 1))
+	assert.True(t, opts.batchFull(10, 2))
+
+	opts = &options{MaxEventsPerSecond: 5}
+	assert.False(t, opts.batchFull(10, 4))
+	assert.True(t, opts.batchFull(10, 5))
+}
+
+func TestLineReader_Stream(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	go func() {
+		_, _ = io.WriteString(pw, "{\"a\":1}\n{\"b\"")
+	}()
+
+	// The complete line is returned without waiting for the rest of the
+	// stream.
+	lr := newLineReader(pr, &options{})
+	chunk, err := lr.next()
+	require.NoError(t, err)
+	assert.Equal(t, "{\"a\":1}\n", string(chunk.data))
+}
@@ -0,0 +1,57 @@
+package ingest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// errLocked is returned by tryLockFile, if the lock is held by another
+// process.
+var errLocked = errors.New("locked by another process")
+
+// fileLock is an exclusive advisory lock held on a file. It coordinates
+// processes sharing a checkpoint file or spool directory. It is released when
+// the process exits.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile takes the lock on the file at the given path, which is created if
+// needed, and blocks until it is acquired.
+func lockFile(path string) (*fileLock, error) {
+	return acquireFileLock(path, true)
+}
+
+// tryLockFile is like lockFile but returns errLocked instead of blocking, if
+// the lock is held by another process.
+func tryLockFile(path string) (*fileLock, error) {
+	return acquireFileLock(path, false)
+}
+
+func acquireFileLock(path string, wait bool) (*fileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := lockFD(f, wait); err != nil {
+		_ = f.Close()
+		return nil, err
+	} else if !ok {
+		_ = f.Close()
+		return nil, errLocked
+	}
+
+	return &fileLock{f: f}, nil
+}
+
+// unlock releases the lock. The lock file is kept, as removing it would race
+// with other processes about to lock it.
+func (l *fileLock) unlock() error {
+	return l.f.Close()
+}
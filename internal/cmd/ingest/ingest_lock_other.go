@@ -0,0 +1,29 @@
+//go:build !windows
+
+package ingest
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFD takes an exclusive lock on the file. If wait is not set, it returns
+// false instead of blocking, if the lock is held by another process. Closing
+// the file releases the lock.
+func lockFD(f *os.File, wait bool) (bool, error) {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		} else if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
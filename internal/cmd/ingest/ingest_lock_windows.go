@@ -0,0 +1,24 @@
+package ingest
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFD takes an exclusive lock on the file. If wait is not set, it returns
+// false instead of blocking, if the lock is held by another process. Closing
+// the file releases the lock.
+func lockFD(f *os.File, wait bool) (bool, error) {
+	var flags uint32 = windows.LOCKFILE_EXCLUSIVE_LOCK
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, new(windows.Overlapped))
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,141 @@
+package ingest
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/axiomhq/pkg/version"
+
+	"github.com/axiomhq/cli/pkg/terminal"
+)
+
+const (
+	metadataFile     = "file"
+	metadataLine     = "line"
+	metadataHost     = "host"
+	metadataIngestID = "ingest_id"
+	metadataVersion  = "version"
+)
+
+var (
+	// validMetadataFields are the metadata fields which can be added to
+	// events.
+	validMetadataFields = []string{
+		metadataFile,
+		metadataLine,
+		metadataHost,
+		metadataIngestID,
+		metadataVersion,
+	}
+
+	// defaultMetadataFieldNames are the names of the metadata fields, if not
+	// configured otherwise.
+	defaultMetadataFieldNames = map[string]string{
+		metadataFile:     "_source.file",
+		metadataLine:     "_source.line",
+		metadataHost:     "_source.host",
+		metadataIngestID: "_source.ingest_id",
+		metadataVersion:  "_source.cli_version",
+	}
+)
+
+// metadata adds fields describing where an event comes from to the event: The
+// file and line it was read from, the host and the ID of the ingest run, which
+// identifies all events ingested by a single invocation of the CLI, and the
+// version of the CLI.
+type metadata struct {
+	// names of the fields by metadata field. Fields without a name are not
+	// added.
+	names map[string]string
+
+	host     string
+	ingestID string
+	version  string
+}
+
+// newMetadata creates the metadata configured by the options. It returns nil,
+// if adding metadata is not configured.
+func newMetadata(opts *options) (*metadata, error) {
+	if !opts.AddMetadata {
+		if len(opts.MetadataFields) > 0 {
+			return nil, fmt.Errorf("--metadata-field only valid with --add-metadata")
+		}
+		return nil, nil
+	}
+
+	m := &metadata{
+		names:   make(map[string]string, len(defaultMetadataFieldNames)),
+		version: version.Release(),
+	}
+	for k, v := range defaultMetadataFieldNames {
+		m.names[k] = v
+	}
+
+	fields, err := splitAssignments("--metadata-field", opts.MetadataFields)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if !contains(validMetadataFields, f[0]) {
+			return nil, fmt.Errorf("invalid --metadata-field %q: field must be one of %s", f[0]+"="+f[1], strings.Join(validMetadataFields, ", "))
+		}
+		m.names[f[0]] = f[1]
+	}
+
+	if m.host, err = os.Hostname(); err != nil {
+		return nil, fmt.Errorf("could not determine host name: %w", err)
+	}
+	if m.ingestID, err = newIngestID(); err != nil {
+		return nil, fmt.Errorf("could not generate ingest ID: %w", err)
+	}
+
+	return m, nil
+}
+
+// newIngestID returns a random version 4 UUID.
+func newIngestID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// apply adds the metadata to the event. The file and line are only added if
+// known, so if file is not empty and line is positive. A nil metadata adds
+// nothing.
+func (m *metadata) apply(event map[string]any, file string, line int) {
+	if m == nil {
+		return
+	}
+
+	m.set(event, metadataFile, file, file != "")
+	m.set(event, metadataLine, line, line > 0)
+	m.set(event, metadataHost, m.host, true)
+	m.set(event, metadataIngestID, m.ingestID, true)
+	m.set(event, metadataVersion, m.version, true)
+}
+
+// printSummary writes the ingest ID to the standard error, if it is added to
+// the events. It is always printed, so it can be found in the logs of
+// non-interactive runs.
+func (m *metadata) printSummary(io *terminal.IO) {
+	if m == nil || m.names[metadataIngestID] == "" {
+		return
+	}
+
+	cs := io.ColorScheme()
+	fmt.Fprintf(io.ErrOut(), "%s Ingest ID is %s, held by the %s field of every event\n",
+		cs.SuccessIcon(), cs.Bold(m.ingestID), cs.Bold(m.names[metadataIngestID]))
+}
+
+// set sets the metadata field to the value, if it is known and has a name.
+func (m *metadata) set(event map[string]any, field string, v any, known bool) {
+	if name := m.names[field]; name != "" && known {
+		setField(event, name, v)
+	}
+}
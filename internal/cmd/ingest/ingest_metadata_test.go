@@ -0,0 +1,56 @@
+package ingest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	m, err := newMetadata(&options{
+		AddMetadata:    true,
+		MetadataFields: []string{"host=hostname", "version="},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, m.ingestID)
+
+	host, err := os.Hostname()
+	require.NoError(t, err)
+
+	event := map[string]any{"foo": "bar"}
+	m.apply(event, "app.log", 3)
+	assert.Equal(t, map[string]any{
+		"foo":      "bar",
+		"hostname": host,
+		"_source": map[string]any{
+			"file":      "app.log",
+			"line":      3,
+			"ingest_id": m.ingestID,
+		},
+	}, event)
+
+	// Unknown files and lines are omitted.
+	event = map[string]any{}
+	m.apply(event, "", 0)
+	assert.Equal(t, map[string]any{
+		"hostname": host,
+		"_source": map[string]any{
+			"ingest_id": m.ingestID,
+		},
+	}, event)
+}
+
+func TestNewMetadata_Invalid(t *testing.T) {
+	m, err := newMetadata(&options{})
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	_, err = newMetadata(&options{MetadataFields: []string{"host=hostname"}})
+	assert.EqualError(t, err, "--metadata-field only valid with --add-metadata")
+
+	_, err = newMetadata(&options{AddMetadata: true, MetadataFields: []string{"user=name"}})
+	assert.EqualError(t, err, `invalid --metadata-field "user=name": field must be one of file, line, host, ingest_id, version`)
+}
@@ -0,0 +1,88 @@
+package ingest
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// multiline merges continuation lines into the event started by the last line
+// which matches the start pattern, like the lines of a stack trace.
+type multiline struct {
+	start    *regexp.Regexp
+	maxLines int
+	timeout  time.Duration
+
+	lines []string
+	// offset of the first pending line in the data read.
+	offset int64
+	// line is the number of the first pending line.
+	line int
+	// last is the time the last line was added.
+	last time.Time
+}
+
+func newMultiline(opts *options) *multiline {
+	return &multiline{
+		start:    opts.multilineStart,
+		maxLines: opts.MultilineMaxLines,
+		timeout:  opts.MultilineTimeout,
+	}
+}
+
+// multilineEvent is an event merged from one or more lines.
+type multilineEvent struct {
+	// text of the event, its lines joined by a newline.
+	text string
+	// line is the number of the first line of the event.
+	line int
+}
+
+// add adds a line, without its trailing newline, which was read at the given
+// offset and has the given number and returns the events completed by it. An
+// event is complete when the next event starts or when it has reached the
+// maximum amount of lines.
+func (m *multiline) add(line string, offset int64, number int) []multilineEvent {
+	// Skip empty lines in between events.
+	if len(m.lines) == 0 && strings.TrimSpace(line) == "" {
+		return nil
+	}
+
+	var events []multilineEvent
+	if len(m.lines) > 0 && m.start.MatchString(line) {
+		events = append(events, m.flush())
+	}
+
+	if len(m.lines) == 0 {
+		m.offset, m.line = offset, number
+	}
+	m.lines = append(m.lines, line)
+	m.last = time.Now()
+
+	if m.maxLines > 0 && len(m.lines) >= m.maxLines {
+		events = append(events, m.flush())
+	}
+
+	return events
+}
+
+// pending returns the offset of the first line of the pending event, if any.
+func (m *multiline) pending() (int64, bool) {
+	return m.offset, len(m.lines) > 0
+}
+
+// expired returns true if there is a pending event which didn't receive a
+// line for longer than the timeout.
+func (m *multiline) expired(now time.Time) bool {
+	return len(m.lines) > 0 && now.Sub(m.last) >= m.timeout
+}
+
+// flush returns the pending event and resets it.
+func (m *multiline) flush() multilineEvent {
+	event := multilineEvent{
+		text: strings.TrimRight(strings.Join(m.lines, "\n"), " \t\r\n"),
+		line: m.line,
+	}
+	m.lines = m.lines[:0]
+	return event
+}
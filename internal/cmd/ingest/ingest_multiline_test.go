@@ -0,0 +1,45 @@
+package ingest
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiline(t *testing.T) {
+	m := newMultiline(&options{
+		multilineStart:    regexp.MustCompile(`^\d{4}-`),
+		MultilineMaxLines: 3,
+		MultilineTimeout:  time.Second,
+	})
+
+	assert.Empty(t, m.add("", 0, 1))
+	assert.Empty(t, m.add("2022-01-01 ERROR boom", 1, 2))
+	assert.Empty(t, m.add("java.lang.NullPointerException", 23, 3))
+
+	offset, ok := m.pending()
+	assert.True(t, ok)
+	assert.EqualValues(t, 1, offset)
+
+	// The next event completes the pending one.
+	assert.Equal(t, []multilineEvent{
+		{"2022-01-01 ERROR boom\njava.lang.NullPointerException", 2},
+	}, m.add("2022-01-01 INFO recovered", 54, 4))
+
+	// Events are split once they reach the maximum amount of lines.
+	assert.Empty(t, m.add("  at a", 80, 5))
+	assert.Equal(t, []multilineEvent{
+		{"2022-01-01 INFO recovered\n  at a\n  at b", 4},
+	}, m.add("  at b", 87, 6))
+
+	_, ok = m.pending()
+	assert.False(t, ok)
+
+	// Pending events expire after the timeout.
+	assert.Empty(t, m.add("2022-01-02 INFO done", 94, 7))
+	assert.False(t, m.expired(time.Now()))
+	assert.True(t, m.expired(time.Now().Add(time.Second)))
+	assert.Equal(t, multilineEvent{"2022-01-02 INFO done", 7}, m.flush())
+}
@@ -0,0 +1,654 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/axiomhq/cli/internal/cmd/auth"
+	"github.com/axiomhq/cli/internal/cmdutil"
+)
+
+// Content types of OTLP/HTTP requests.
+const (
+	otlpProtobufContentType = "application/x-protobuf"
+	otlpJSONContentType     = "application/json"
+)
+
+// gRPC status codes returned in OTLP error responses.
+const (
+	grpcInvalidArgument = 3
+	grpcInternal        = 13
+	grpcUnavailable     = 14
+)
+
+type otlpServerOptions struct {
+	*options
+
+	// Listen is the address to listen on for OTLP/HTTP requests.
+	Listen string
+}
+
+func newOTLPServerCmd(f *cmdutil.Factory) *cobra.Command {
+	opts := &otlpServerOptions{
+		options: &options{
+			Factory: f,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "otlp-server [<dataset-name>] [--listen <address>] [--max-retries <count>] [--retry-timeout <duration>] [--shutdown-timeout <duration>]",
+		Short: "Receive OpenTelemetry logs and ingest them",
+		Long: heredoc.Doc(`
+			Receive OpenTelemetry logs via OTLP/HTTP and ingest them into an
+			Axiom dataset, using the credentials of the configured deployment.
+
+			Export requests are accepted on the "/v1/logs" path, encoded as
+			protobuf or JSON, as indicated by their Content-Type header, and
+			optionally compressed using gzip. Each log record becomes an event:
+			Its time (or observed time) is written to the "_time" field, its
+			body to the "body" field and its severity, trace and span ID to
+			the "severity_text", "severity_number", "trace_id" and "span_id"
+			fields. Attributes are flattened into fields prefixed with
+			"attributes.", the ones of the resource with "resource." and the
+			ones of the instrumentation scope with "scope.", which also holds
+			its name and version.
+
+			The log records of a request are ingested before the response is
+			sent. If Axiom rejects some of them, the response reports a partial
+			success holding the amount of rejected log records. Failed batches
+			are retried with exponential backoff. If they can't be ingested
+			because of a transient error, clients are asked to retry.
+
+			When the command is interrupted, requests in flight are finished
+			before it exits, for no longer than "--shutdown-timeout". A second
+			interrupt aborts it immediately.
+		`),
+
+		DisableFlagsInUseLine: true,
+
+		Args:              cmdutil.PopulateFromArgs(f, &opts.Dataset),
+		ValidArgsFunction: cmdutil.DatasetCompletionFunc(f),
+
+		Example: heredoc.Doc(`
+			# Receive logs on the default OTLP/HTTP port and ingest them into a
+			# dataset named "otel-logs":
+			$ axiom ingest otlp-server otel-logs --listen=:4318
+
+			# Point an OpenTelemetry SDK to the receiver:
+			$ export OTEL_EXPORTER_OTLP_LOGS_ENDPOINT=http://localhost:4318/v1/logs
+		`),
+
+		PreRunE: cmdutil.ChainRunFuncs(
+			cmdutil.AsksForSetup(f, auth.NewLoginCmd(f)),
+			cmdutil.NeedsActiveDeployment(f),
+			cmdutil.NeedsDatasets(f),
+		),
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if opts.MaxRetries < 0 {
+				return cmdutil.NewFlagErrorf("--max-retries must not be negative")
+			}
+
+			if err := complete(cmd.Context(), opts.options); err != nil {
+				return err
+			}
+			return runOTLPServer(cmd.Context(), opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Listen, "listen", "127.0.0.1:4318", "Address to listen on for OTLP/HTTP requests")
+	cmd.Flags().IntVar(&opts.MaxRetries, "max-retries", 5, "Maximum amount of retries of a batch that failed to be ingested because of a transient error")
+	cmd.Flags().DurationVar(&opts.RetryTimeout, "retry-timeout", time.Minute, "Maximum duration to spend on retrying a single batch (0 to only limit by --max-retries)")
+	cmd.Flags().DurationVar(&opts.ShutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "Maximum duration to spend on finishing requests when interrupted")
+
+	_ = cmd.RegisterFlagCompletionFunc("listen", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("max-retries", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("retry-timeout", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("shutdown-timeout", cmdutil.NoCompletion)
+
+	return cmd
+}
+
+func runOTLPServer(ctx context.Context, opts *otlpServerOptions) error {
+	client, err := opts.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return err
+	}
+
+	// Events are ingested as they are received, so the flush interval only
+	// matters for the sink to notice the context being canceled.
+	opts.FlushEvery = time.Second
+
+	return serveSink(ctx, client, opts.options, func(s *sink) (func(context.Context) error, error) {
+		mux := http.NewServeMux()
+		mux.Handle("/v1/logs", &otlpHandler{sink: s})
+
+		srv := &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: time.Second * 10,
+		}
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				cs := opts.IO.ColorScheme()
+				fmt.Fprintf(opts.IO.ErrOut(), "%s Failed to serve: %s\n", cs.ErrorIcon(), err)
+			}
+		}()
+
+		if opts.IO.IsStderrTTY() {
+			cs := opts.IO.ColorScheme()
+			fmt.Fprintf(opts.IO.ErrOut(), "%s Listening on %s, ingesting into dataset %s\n",
+				cs.SuccessIcon(), cs.Bold("http://"+ln.Addr().String()+"/v1/logs"), cs.Bold(opts.Dataset))
+		}
+
+		return srv.Shutdown, nil
+	})
+}
+
+// otlpHandler handles OTLP/HTTP logs export requests.
+type otlpHandler struct {
+	sink *sink
+}
+
+// ServeHTTP implements http.Handler.
+func (h *otlpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct != otlpProtobufContentType && ct != otlpJSONContentType {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", ct), http.StatusUnsupportedMediaType)
+		return
+	} else if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOTLPStatus(w, ct, http.StatusMethodNotAllowed, grpcInvalidArgument, "method not allowed")
+		return
+	}
+
+	body, err := decompressBody(r)
+	if err != nil {
+		writeOTLPStatus(w, ct, http.StatusBadRequest, grpcInvalidArgument, err.Error())
+		return
+	}
+	defer body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(body, maxRequestSize+1))
+	if err != nil {
+		writeOTLPStatus(w, ct, http.StatusBadRequest, grpcInvalidArgument, err.Error())
+		return
+	} else if len(b) > maxRequestSize {
+		writeOTLPStatus(w, ct, http.StatusRequestEntityTooLarge, grpcInvalidArgument, "request body too large")
+		return
+	}
+
+	var req otlpLogsRequest
+	if ct == otlpProtobufContentType {
+		err = req.unmarshalProto(b)
+	} else {
+		err = json.Unmarshal(b, &req)
+	}
+	if err != nil {
+		writeOTLPStatus(w, ct, http.StatusBadRequest, grpcInvalidArgument, fmt.Sprintf("invalid export request: %s", err))
+		return
+	}
+
+	res, err := h.sink.ingest(req.events()...)
+	if err != nil {
+		// Clients retry on transient errors.
+		if isRetryable(err) {
+			writeOTLPStatus(w, ct, http.StatusServiceUnavailable, grpcUnavailable, err.Error())
+		} else {
+			writeOTLPStatus(w, ct, http.StatusInternalServerError, grpcInternal, err.Error())
+		}
+		return
+	}
+
+	var (
+		rejected = int64(res.Failed)
+		msg      string
+	)
+	if len(res.Failures) > 0 {
+		msg = res.Failures[0].Error
+	}
+
+	w.Header().Set("Content-Type", ct)
+	w.WriteHeader(http.StatusOK)
+	if ct == otlpProtobufContentType {
+		_, _ = w.Write(marshalOTLPResponse(rejected, msg))
+		return
+	}
+
+	resp := map[string]any{}
+	if rejected > 0 {
+		resp["partialSuccess"] = map[string]any{
+			// 64 bit integers are encoded as strings in JSON.
+			"rejectedLogRecords": strconv.FormatInt(rejected, 10),
+			"errorMessage":       msg,
+		}
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// writeOTLPStatus writes an error response holding a google.rpc.Status
+// message, encoded as indicated by the content type.
+func writeOTLPStatus(w http.ResponseWriter, ct string, code int, grpcCode int32, msg string) {
+	w.Header().Set("Content-Type", ct)
+	w.WriteHeader(code)
+
+	if ct == otlpProtobufContentType {
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(grpcCode))
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, msg)
+		_, _ = w.Write(b)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"code":    grpcCode,
+		"message": msg,
+	})
+}
+
+// marshalOTLPResponse returns the protobuf encoded ExportLogsServiceResponse,
+// which holds a partial success, if log records were rejected.
+func marshalOTLPResponse(rejected int64, msg string) []byte {
+	if rejected == 0 {
+		return nil
+	}
+
+	var ps []byte
+	ps = protowire.AppendTag(ps, 1, protowire.VarintType)
+	ps = protowire.AppendVarint(ps, uint64(rejected))
+	if msg != "" {
+		ps = protowire.AppendTag(ps, 2, protowire.BytesType)
+		ps = protowire.AppendString(ps, msg)
+	}
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, ps)
+}
+
+// otlpLogsRequest is an ExportLogsServiceRequest. Its JSON tags follow the
+// OTLP/JSON encoding.
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name       string         `json:"name"`
+	Version    string         `json:"version"`
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         otlpInt        `json:"timeUnixNano"`
+	ObservedTimeUnixNano otlpInt        `json:"observedTimeUnixNano"`
+	SeverityNumber       int32          `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 *otlpAnyValue  `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes"`
+	Flags                uint32         `json:"flags"`
+	// TraceID and SpanID are hex encoded.
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string           `json:"stringValue,omitempty"`
+	BoolValue   *bool             `json:"boolValue,omitempty"`
+	IntValue    *otlpInt          `json:"intValue,omitempty"`
+	DoubleValue *float64          `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue   `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKeyValueList `json:"kvlistValue,omitempty"`
+	BytesValue  []byte            `json:"bytesValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKeyValueList struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+// otlpInt is a 64 bit integer, which is encoded as a string or a number in
+// JSON.
+type otlpInt int64
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (i *otlpInt) UnmarshalJSON(b []byte) error {
+	s := string(bytes.Trim(b, `"`))
+	if s == "" || s == "null" {
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		// Nanosecond timestamps can exceed the range of signed integers.
+		u, uerr := strconv.ParseUint(s, 10, 64)
+		if uerr != nil {
+			return fmt.Errorf("invalid integer %s", b)
+		}
+		n = int64(u)
+	}
+	*i = otlpInt(n)
+	return nil
+}
+
+// value returns the Go representation of the value. Bytes are base64 encoded.
+func (v *otlpAnyValue) value() any {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return int64(*v.IntValue)
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	case v.ArrayValue != nil:
+		res := make([]any, len(v.ArrayValue.Values))
+		for i := range v.ArrayValue.Values {
+			res[i] = v.ArrayValue.Values[i].value()
+		}
+		return res
+	case v.KvlistValue != nil:
+		res := make(map[string]any, len(v.KvlistValue.Values))
+		for i := range v.KvlistValue.Values {
+			res[v.KvlistValue.Values[i].Key] = v.KvlistValue.Values[i].Value.value()
+		}
+		return res
+	case v.BytesValue != nil:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	}
+	return nil
+}
+
+// setAttributes sets the attributes as fields of the event, prefixing their
+// keys.
+func setAttributes(event map[string]any, prefix string, attrs []otlpKeyValue) {
+	for i := range attrs {
+		event[prefix+attrs[i].Key] = attrs[i].Value.value()
+	}
+}
+
+// events converts the log records of the request into events.
+func (req *otlpLogsRequest) events() []map[string]any {
+	var events []map[string]any
+	for _, rl := range req.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				event := make(map[string]any)
+				setAttributes(event, "resource.", rl.Resource.Attributes)
+				setAttributes(event, "scope.", sl.Scope.Attributes)
+				if sl.Scope.Name != "" {
+					event["scope.name"] = sl.Scope.Name
+				}
+				if sl.Scope.Version != "" {
+					event["scope.version"] = sl.Scope.Version
+				}
+				setAttributes(event, "attributes.", lr.Attributes)
+
+				ts := lr.TimeUnixNano
+				if ts == 0 {
+					ts = lr.ObservedTimeUnixNano
+				}
+				if ts != 0 {
+					event[defaultTimestampField] = time.Unix(0, int64(ts)).UTC().Format(time.RFC3339Nano)
+				}
+				if lr.SeverityText != "" {
+					event["severity_text"] = lr.SeverityText
+				}
+				if lr.SeverityNumber != 0 {
+					event["severity_number"] = lr.SeverityNumber
+				}
+				if lr.Body != nil {
+					event["body"] = lr.Body.value()
+				}
+				if lr.TraceID != "" {
+					event["trace_id"] = lr.TraceID
+				}
+				if lr.SpanID != "" {
+					event["span_id"] = lr.SpanID
+				}
+				if lr.Flags != 0 {
+					event["flags"] = lr.Flags
+				}
+
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
+
+// protoFields calls fn for each field of the protobuf encoded message. The
+// value of length-delimited fields is passed as bytes, the one of all other
+// fields as integer.
+func protoFields(b []byte, fn func(num protowire.Number, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var (
+			v []byte
+			x uint64
+		)
+		switch typ {
+		case protowire.VarintType:
+			x, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			x, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var x32 uint32
+			x32, n = protowire.ConsumeFixed32(b)
+			x = uint64(x32)
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(num, v, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalProto decodes the protobuf encoded request.
+func (req *otlpLogsRequest) unmarshalProto(b []byte) error {
+	return protoFields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		var rl otlpResourceLogs
+		if err := rl.unmarshalProto(v); err != nil {
+			return err
+		}
+		req.ResourceLogs = append(req.ResourceLogs, rl)
+		return nil
+	})
+}
+
+func (rl *otlpResourceLogs) unmarshalProto(b []byte) error {
+	return protoFields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		switch num {
+		case 1:
+			return protoFields(v, func(num protowire.Number, v []byte, _ uint64) error {
+				if num == 1 {
+					return appendKeyValue(&rl.Resource.Attributes, v)
+				}
+				return nil
+			})
+		// Field 1000 holds the deprecated instrumentation library logs, which
+		// are encoded just like scope logs.
+		case 2, 1000:
+			var sl otlpScopeLogs
+			if err := sl.unmarshalProto(v); err != nil {
+				return err
+			}
+			rl.ScopeLogs = append(rl.ScopeLogs, sl)
+		}
+		return nil
+	})
+}
+
+func (sl *otlpScopeLogs) unmarshalProto(b []byte) error {
+	return protoFields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		switch num {
+		case 1:
+			return protoFields(v, func(num protowire.Number, v []byte, _ uint64) error {
+				switch num {
+				case 1:
+					sl.Scope.Name = string(v)
+				case 2:
+					sl.Scope.Version = string(v)
+				case 3:
+					return appendKeyValue(&sl.Scope.Attributes, v)
+				}
+				return nil
+			})
+		case 2:
+			var lr otlpLogRecord
+			if err := lr.unmarshalProto(v); err != nil {
+				return err
+			}
+			sl.LogRecords = append(sl.LogRecords, lr)
+		}
+		return nil
+	})
+}
+
+func (lr *otlpLogRecord) unmarshalProto(b []byte) error {
+	return protoFields(b, func(num protowire.Number, v []byte, x uint64) error {
+		switch num {
+		case 1:
+			lr.TimeUnixNano = otlpInt(x)
+		case 2:
+			lr.SeverityNumber = int32(x)
+		case 3:
+			lr.SeverityText = string(v)
+		case 5:
+			lr.Body = new(otlpAnyValue)
+			return lr.Body.unmarshalProto(v)
+		case 6:
+			return appendKeyValue(&lr.Attributes, v)
+		case 8:
+			lr.Flags = uint32(x)
+		case 9:
+			lr.TraceID = hex.EncodeToString(v)
+		case 10:
+			lr.SpanID = hex.EncodeToString(v)
+		case 11:
+			lr.ObservedTimeUnixNano = otlpInt(x)
+		}
+		return nil
+	})
+}
+
+// appendKeyValue decodes the protobuf encoded key/value pair and appends it.
+func appendKeyValue(kvs *[]otlpKeyValue, b []byte) error {
+	var kv otlpKeyValue
+	err := protoFields(b, func(num protowire.Number, v []byte, _ uint64) error {
+		switch num {
+		case 1:
+			kv.Key = string(v)
+		case 2:
+			return kv.Value.unmarshalProto(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*kvs = append(*kvs, kv)
+	return nil
+}
+
+func (av *otlpAnyValue) unmarshalProto(b []byte) error {
+	return protoFields(b, func(num protowire.Number, v []byte, x uint64) error {
+		switch num {
+		case 1:
+			s := string(v)
+			av.StringValue = &s
+		case 2:
+			b := x != 0
+			av.BoolValue = &b
+		case 3:
+			i := otlpInt(x)
+			av.IntValue = &i
+		case 4:
+			f := math.Float64frombits(x)
+			av.DoubleValue = &f
+		case 5:
+			av.ArrayValue = new(otlpArrayValue)
+			return protoFields(v, func(num protowire.Number, v []byte, _ uint64) error {
+				if num != 1 {
+					return nil
+				}
+				var e otlpAnyValue
+				if err := e.unmarshalProto(v); err != nil {
+					return err
+				}
+				av.ArrayValue.Values = append(av.ArrayValue.Values, e)
+				return nil
+			})
+		case 6:
+			av.KvlistValue = new(otlpKeyValueList)
+			return protoFields(v, func(num protowire.Number, v []byte, _ uint64) error {
+				if num == 1 {
+					return appendKeyValue(&av.KvlistValue.Values, v)
+				}
+				return nil
+			})
+		case 7:
+			av.BytesValue = append([]byte{}, v...)
+		}
+		return nil
+	})
+}
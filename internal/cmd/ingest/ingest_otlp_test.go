@@ -0,0 +1,178 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const otlpJSONRequest = `{
+	"resourceLogs": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+		"scopeLogs": [{
+			"scope": {"name": "logger", "version": "1.0"},
+			"logRecords": [{
+				"timeUnixNano": "1640995200000000000",
+				"severityNumber": 9,
+				"severityText": "INFO",
+				"body": {"stringValue": "hello"},
+				"attributes": [
+					{"key": "status", "value": {"intValue": "200"}},
+					{"key": "tags", "value": {"arrayValue": {"values": [{"boolValue": true}, {"doubleValue": 1.5}]}}}
+				],
+				"traceId": "5b8efff798038103d269b633813fc60c",
+				"spanId": "eee19b7ec3c1b174"
+			}, {
+				"observedTimeUnixNano": 1640995201000000000,
+				"body": {"kvlistValue": {"values": [{"key": "ok", "value": {"boolValue": false}}]}}
+			}]
+		}]
+	}]
+}`
+
+var otlpEvents = []map[string]any{
+	{
+		"resource.service.name": "api",
+		"scope.name":            "logger",
+		"scope.version":         "1.0",
+		"_time":                 "2022-01-01T00:00:00Z",
+		"severity_number":       int32(9),
+		"severity_text":         "INFO",
+		"body":                  "hello",
+		"attributes.status":     int64(200),
+		"attributes.tags":       []any{true, 1.5},
+		"trace_id":              "5b8efff798038103d269b633813fc60c",
+		"span_id":               "eee19b7ec3c1b174",
+	},
+	{
+		"resource.service.name": "api",
+		"scope.name":            "logger",
+		"scope.version":         "1.0",
+		"_time":                 "2022-01-01T00:00:01Z",
+		"body":                  map[string]any{"ok": false},
+	},
+}
+
+func TestOTLPLogsRequest_JSON(t *testing.T) {
+	var req otlpLogsRequest
+	require.NoError(t, json.Unmarshal([]byte(otlpJSONRequest), &req))
+
+	assert.Equal(t, otlpEvents, req.events())
+}
+
+func TestOTLPLogsRequest_Proto(t *testing.T) {
+	var req otlpLogsRequest
+	require.NoError(t, req.unmarshalProto(otlpProtoRequest()))
+
+	assert.Equal(t, otlpEvents, req.events())
+}
+
+func TestOTLPHandler(t *testing.T) {
+	// The fake Axiom server rejects the second event.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(axiom.IngestStatus{
+			Ingested: 1,
+			Failed:   1,
+			Failures: []*axiom.IngestFailure{{Error: "invalid event"}},
+		})
+	}))
+	defer srv.Close()
+
+	client, err := axiom.NewClient(
+		axiom.SetNoEnv(),
+		axiom.SetURL(srv.URL),
+		axiom.SetAccessToken("xaat-test"),
+		axiom.SetOrgID("test"),
+	)
+	require.NoError(t, err)
+
+	h := &otlpHandler{sink: newSink(context.Background(), client, &options{Dataset: "test"})}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/logs", strings.NewReader(otlpJSONRequest))
+	req.Header.Set("Content-Type", otlpJSONContentType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"partialSuccess":{"rejectedLogRecords":"1","errorMessage":"invalid event"}}`, rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/logs", strings.NewReader(string(otlpProtoRequest())))
+	req.Header.Set("Content-Type", otlpProtobufContentType)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, marshalOTLPResponse(1, "invalid event"), rec.Body.Bytes())
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/logs", strings.NewReader("{"))
+	req.Header.Set("Content-Type", otlpJSONContentType)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	assert.EqualValues(t, 4, h.sink.status().Received)
+	assert.EqualValues(t, 2, h.sink.status().Failed)
+}
+
+// otlpProtoRequest returns the protobuf encoding of otlpJSONRequest.
+func otlpProtoRequest() []byte {
+	message := func(fields ...[]byte) []byte {
+		var b []byte
+		for _, f := range fields {
+			b = append(b, f...)
+		}
+		return b
+	}
+	bytesField := func(num protowire.Number, v []byte) []byte {
+		return protowire.AppendBytes(protowire.AppendTag(nil, num, protowire.BytesType), v)
+	}
+	stringField := func(num protowire.Number, v string) []byte {
+		return bytesField(num, []byte(v))
+	}
+	varintField := func(num protowire.Number, v uint64) []byte {
+		return protowire.AppendVarint(protowire.AppendTag(nil, num, protowire.VarintType), v)
+	}
+	fixed64Field := func(num protowire.Number, v uint64) []byte {
+		return protowire.AppendFixed64(protowire.AppendTag(nil, num, protowire.Fixed64Type), v)
+	}
+	keyValue := func(num protowire.Number, key string, value []byte) []byte {
+		return bytesField(num, message(stringField(1, key), bytesField(2, value)))
+	}
+
+	record1 := message(
+		fixed64Field(1, 1640995200000000000),
+		varintField(2, 9),
+		stringField(3, "INFO"),
+		bytesField(5, stringField(1, "hello")),
+		keyValue(6, "status", varintField(3, 200)),
+		keyValue(6, "tags", bytesField(5, message(
+			bytesField(1, varintField(2, 1)),
+			bytesField(1, fixed64Field(4, 0x3ff8000000000000)), // 1.5
+		))),
+		bytesField(9, []byte{0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c}),
+		bytesField(10, []byte{0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x74}),
+	)
+	record2 := message(
+		fixed64Field(11, 1640995201000000000),
+		bytesField(5, bytesField(6, keyValue(1, "ok", varintField(2, 0)))),
+	)
+
+	return bytesField(1, message(
+		bytesField(1, keyValue(1, "service.name", stringField(1, "api"))),
+		bytesField(2, message(
+			bytesField(1, message(stringField(1, "logger"), stringField(2, "1.0"))),
+			bytesField(2, record1),
+			bytesField(2, record2),
+		)),
+	))
+}
@@ -0,0 +1,278 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// unparsedField is the field lines which don't match the pattern are stored
+// in, if no reject file is configured.
+const unparsedField = "_unparsed"
+
+// maxPatternDepth limits the nesting of grok patterns, to catch patterns that
+// reference themselves.
+const maxPatternDepth = 32
+
+var grokRefRegexp = regexp.MustCompile(`%\{(\w+)(?::([\w.@-]+))?(?::(int|float))?\}`)
+
+// grokPatterns is the library of named patterns that can be referenced using
+// the %{NAME} or %{NAME:field} syntax.
+var grokPatterns = map[string]string{
+	// Basic patterns.
+	"USERNAME":     `[a-zA-Z0-9._-]+`,
+	"USER":         `%{USERNAME}`,
+	"INT":          `(?:[+-]?(?:[0-9]+))`,
+	"POSINT":       `\b(?:[1-9][0-9]*)\b`,
+	"NUMBER":       `(?:[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+))`,
+	"WORD":         `\b\w+\b`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"`,
+	"QS":           `%{QUOTEDSTRING}`,
+	"UUID":         `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"LOGLEVEL":     `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|severe|alert|emerg(?:ency)?)`,
+
+	// Networking.
+	"IPV4":     `(?:(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])`,
+	"IPV6":     `(?:[0-9A-Fa-f]{0,4}:){2,7}(?:[0-9A-Fa-f]{1,4}|%{IPV4})?`,
+	"IP":       `(?:%{IPV6}|%{IPV4})`,
+	"HOSTNAME": `\b(?:[0-9A-Za-z][0-9A-Za-z-]{0,62})(?:\.(?:[0-9A-Za-z][0-9A-Za-z-]{0,62}))*\.?\b`,
+	"IPORHOST": `(?:%{IP}|%{HOSTNAME})`,
+
+	// Paths and URIs.
+	"URIPROTO":     `[A-Za-z][A-Za-z0-9+\-.]*`,
+	"URIHOST":      `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":      `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":     `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM": `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":          `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATHPARAM})?`,
+
+	// Dates and times.
+	"MONTH":             `\b(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec)[a-z]*\b`,
+	"MONTHNUM":          `(?:0?[1-9]|1[0-2])`,
+	"MONTHDAY":          `(?:0[1-9]|[12][0-9]|3[01]|[1-9])`,
+	"YEAR":              `[0-9]{4}`,
+	"HOUR":              `(?:2[0-3]|[01]?[0-9])`,
+	"MINUTE":            `(?:[0-5][0-9])`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})`,
+	"ISO8601_TIMEZONE":  `(?:Z|[+-]%{HOUR}(?::?%{MINUTE}))`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} [+-][0-9]{4}`,
+
+	// Log formats.
+	"APACHE_COMMON":   `%{IPORHOST:client} %{USER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:method} %{NOTSPACE:path}(?: HTTP/%{NUMBER:http_version})?|%{DATA:request})" %{INT:status:int} (?:%{INT:bytes:int}|-)`,
+	"APACHE_COMBINED": `%{APACHE_COMMON} "%{DATA:referrer}" "%{DATA:user_agent}"`,
+	"APACHE_ERROR":    `\[%{DATA:timestamp}\] \[(?:%{WORD:module})?:%{LOGLEVEL:level}\] (?:\[pid %{INT:pid:int}(?::tid %{INT:tid:int})?\] )?(?:\[client %{DATA:client}\] )?%{GREEDYDATA:message}`,
+	"NGINX_COMBINED":  `%{APACHE_COMBINED}`,
+	"POSTGRES":        `%{TIMESTAMP_ISO8601:timestamp}(?: %{WORD:timezone})? \[%{INT:pid:int}\] (?:%{USER:user}@%{NOTSPACE:database} )?%{WORD:level}:  %{GREEDYDATA:message}`,
+}
+
+// patternField is a field captured by a pattern.
+type patternField struct {
+	name string
+	// typ is the type the captured value is converted to: "int", "float" or
+	// empty for a string.
+	typ string
+}
+
+// pattern extracts fields from lines using a grok pattern or a regular
+// expression with named capture groups.
+type pattern struct {
+	re     *regexp.Regexp
+	fields []patternField
+}
+
+// compilePattern compiles a grok pattern. Patterns that don't reference any
+// named pattern are treated as regular expressions, with each named capture
+// group becoming a field.
+func compilePattern(s string) (*pattern, error) {
+	p := new(pattern)
+
+	expr, err := p.expand(s, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.re, err = regexp.Compile(expr); err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	// Fields are tracked by subexpression index. Named groups of the pattern
+	// which have not been created by expanding a field reference are fields,
+	// too.
+	fields := make([]patternField, p.re.NumSubexp()+1)
+	for i, name := range p.re.SubexpNames() {
+		if n, ok := grokGroupIndex(name); ok {
+			fields[i] = p.fields[n]
+		} else if name != "" {
+			fields[i] = patternField{name: name}
+		}
+	}
+	p.fields = fields
+
+	for _, field := range p.fields {
+		if field.name != "" {
+			return p, nil
+		}
+	}
+	return nil, errors.New("pattern does not capture any fields")
+}
+
+// expand replaces all references to named patterns with their regular
+// expression. References naming a field are turned into capture groups.
+func (p *pattern) expand(s string, depth int) (string, error) {
+	if depth > maxPatternDepth {
+		return "", errors.New("pattern nested too deeply")
+	}
+
+	var (
+		sb   strings.Builder
+		last int
+	)
+	for _, m := range grokRefRegexp.FindAllStringSubmatchIndex(s, -1) {
+		_, _ = sb.WriteString(s[last:m[0]])
+		last = m[1]
+
+		name := s[m[2]:m[3]]
+		def, ok := grokPatterns[name]
+		if !ok {
+			return "", fmt.Errorf("unknown pattern %q", name)
+		}
+		expr, err := p.expand(def, depth+1)
+		if err != nil {
+			return "", err
+		}
+
+		if m[4] < 0 {
+			fmt.Fprintf(&sb, "(?:%s)", expr)
+			continue
+		}
+
+		field := patternField{name: s[m[4]:m[5]]}
+		if m[6] >= 0 {
+			field.typ = s[m[6]:m[7]]
+		}
+		fmt.Fprintf(&sb, "(?P<%s>%s)", grokGroupName(len(p.fields)), expr)
+		p.fields = append(p.fields, field)
+	}
+	_, _ = sb.WriteString(s[last:])
+
+	return sb.String(), nil
+}
+
+// grokGroupName returns the name of the capture group created for the n-th
+// field reference. Field names are not used directly as they might contain
+// characters not valid in group names.
+func grokGroupName(n int) string {
+	return "__grok" + strconv.Itoa(n)
+}
+
+func grokGroupIndex(name string) (int, bool) {
+	if !strings.HasPrefix(name, "__grok") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "__grok"))
+	return n, err == nil
+}
+
+// match extracts the fields from the line. It returns false, if the line
+// doesn't match the pattern. Optional fields which didn't capture anything are
+// omitted.
+func (p *pattern) match(line string) (map[string]any, bool) {
+	m := p.re.FindStringSubmatchIndex(line)
+	if m == nil {
+		return nil, false
+	}
+
+	event := make(map[string]any)
+	for i, field := range p.fields {
+		if field.name == "" || m[2*i] < 0 {
+			continue
+		}
+
+		v := line[m[2*i]:m[2*i+1]]
+		switch field.typ {
+		case "int":
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				event[field.name] = n
+				continue
+			}
+		case "float":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				event[field.name] = f
+				continue
+			}
+		}
+		event[field.name] = v
+	}
+
+	return event, true
+}
+
+// rejectsFile collects lines which could not be turned into an event or
+// events which don't match the schema. It is safe for concurrent use.
+type rejectsFile struct {
+	mu sync.Mutex
+	f  *os.File
+	n  int
+}
+
+// openRejectsFile opens the named file for appending rejected lines, creating
+// it if necessary.
+func openRejectsFile(filename string) (*rejectsFile, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &rejectsFile{f: f}, nil
+}
+
+// write appends the line to the file.
+func (rf *rejectsFile) write(line string) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if _, err := rf.f.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	rf.n++
+
+	return nil
+}
+
+// writeEvent appends the JSON representation of v to the file. It isn't
+// counted as a line.
+func (rf *rejectsFile) writeEvent(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	_, err = rf.f.Write(append(b, '\n'))
+	return err
+}
+
+// count returns the amount of lines written.
+func (rf *rejectsFile) count() int {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	return rf.n
+}
+
+// Close closes the underlying file.
+func (rf *rejectsFile) Close() error {
+	return rf.f.Close()
+}
@@ -0,0 +1,94 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		line    string
+		want    map[string]any
+	}{
+		{
+			name:    "grok",
+			pattern: "%{IP:client} %{WORD:method} %{URIPATH:path}",
+			line:    "10.0.0.1 GET /index.html",
+			want: map[string]any{
+				"client": "10.0.0.1",
+				"method": "GET",
+				"path":   "/index.html",
+			},
+		},
+		{
+			name:    "nginx combined",
+			pattern: "%{NGINX_COMBINED}",
+			line:    `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?x=1 HTTP/1.1" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`,
+			want: map[string]any{
+				"client":       "127.0.0.1",
+				"ident":        "-",
+				"auth":         "frank",
+				"timestamp":    "10/Oct/2000:13:55:36 -0700",
+				"method":       "GET",
+				"path":         "/apache_pb.gif?x=1",
+				"http_version": "1.1",
+				"status":       int64(200),
+				"bytes":        int64(2326),
+				"referrer":     "http://www.example.com/start.html",
+				"user_agent":   "Mozilla/4.08",
+			},
+		},
+		{
+			name:    "postgres",
+			pattern: "%{POSTGRES}",
+			line:    "2022-03-01 10:11:12.345 UTC [4242] app@orders ERROR:  relation \"foo\" does not exist",
+			want: map[string]any{
+				"timestamp": "2022-03-01 10:11:12.345",
+				"timezone":  "UTC",
+				"pid":       int64(4242),
+				"user":      "app",
+				"database":  "orders",
+				"level":     "ERROR",
+				"message":   `relation "foo" does not exist`,
+			},
+		},
+		{
+			name:    "regular expression",
+			pattern: `^(?P<level>[A-Z]+) \[(?P<thread>[^\]]+)\] (?P<msg>.*)$`,
+			line:    "WARN [main] disk almost full",
+			want: map[string]any{
+				"level":  "WARN",
+				"thread": "main",
+				"msg":    "disk almost full",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := compilePattern(tt.pattern)
+			require.NoError(t, err)
+
+			event, ok := p.match(tt.line)
+			require.True(t, ok)
+			assert.Equal(t, tt.want, event)
+
+			_, ok = p.match("does not match")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestCompilePattern_Invalid(t *testing.T) {
+	_, err := compilePattern("%{NOPE:field}")
+	assert.EqualError(t, err, `unknown pattern "NOPE"`)
+
+	_, err = compilePattern("%{WORD} %{INT}")
+	assert.EqualError(t, err, "pattern does not capture any fields")
+
+	_, err = compilePattern("(?P<a>")
+	assert.Error(t, err)
+}
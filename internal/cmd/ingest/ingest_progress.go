@@ -0,0 +1,277 @@
+package ingest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/dustin/go-humanize"
+
+	"github.com/axiomhq/cli/pkg/iofmt"
+	"github.com/axiomhq/cli/pkg/terminal"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+// progressInterval is the interval at which the progress display is redrawn.
+const progressInterval = 200 * time.Millisecond
+
+// fileProgress tracks the progress of ingesting a file. It is safe for
+// concurrent use. A nil fileProgress tracks nothing.
+type fileProgress struct {
+	// filename as given, "-" for stdin.
+	filename string
+	// name of the file, as displayed.
+	name string
+
+	mu      sync.Mutex
+	started bool
+	done    bool
+	bytes   int64
+	events  int
+	dropped int
+	res     *axiom.IngestStatus
+	err     error
+}
+
+func newFileProgress(filename string) *fileProgress {
+	p := &fileProgress{
+		filename: filename,
+		name:     filename,
+	}
+	if filename == "-" {
+		p.name = "stdin"
+	}
+	return p
+}
+
+func (p *fileProgress) start() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.started = true
+}
+
+// read adds to the amount of bytes read from the file.
+func (p *fileProgress) read(n int) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.bytes += int64(n)
+}
+
+// event adds an event read from the file.
+func (p *fileProgress) event() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events++
+}
+
+// drop adds an event read from the file that has been dropped.
+func (p *fileProgress) drop() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events++
+	p.dropped++
+}
+
+// finish records the result of ingesting the file.
+func (p *fileProgress) finish(res *axiom.IngestStatus, err error) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done, p.res, p.err = true, res, err
+}
+
+// progressReader counts the bytes read from a file.
+type progressReader struct {
+	io.Reader
+	progress *fileProgress
+}
+
+func (r progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.progress.read(n)
+	return n, err
+}
+
+// startProgress draws the progress of the files being ingested to the
+// standard error, every progress interval, until the returned function is
+// called. The display is cleared once stopped.
+func startProgress(io *terminal.IO, files []*fileProgress) func() {
+	var (
+		stop = make(chan struct{})
+		done = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+
+		var lines int
+		for {
+			lines = drawProgress(io, files, lines)
+
+			select {
+			case <-stop:
+				clearLines(io.ErrOut(), lines)
+				return
+			case <-t.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// drawProgress replaces the given amount of lines previously drawn with a
+// line for every file being ingested and a total. It returns the amount of
+// lines drawn.
+func drawProgress(io *terminal.IO, files []*fileProgress, prevLines int) int {
+	var (
+		cs      = io.ColorScheme()
+		width   = io.TerminalWidth()
+		buf     strings.Builder
+		lines   int
+		done    int
+		bytes   int64
+		events  int
+		nameLen int
+	)
+	for _, p := range files {
+		if len(p.name) > nameLen {
+			nameLen = len(p.name)
+		}
+	}
+	if maxLen := width - 40; nameLen > maxLen {
+		nameLen = maxLen
+	}
+	if nameLen < 10 {
+		nameLen = 10
+	}
+
+	for _, p := range files {
+		p.mu.Lock()
+		started, finished := p.started, p.done
+		fileBytes, fileEvents := p.bytes, p.events
+		p.mu.Unlock()
+
+		bytes += fileBytes
+		events += fileEvents
+		if finished {
+			done++
+			continue
+		} else if !started {
+			continue
+		}
+
+		name := p.name
+		if len(name) > nameLen {
+			name = "..." + name[len(name)-nameLen+3:]
+		}
+		fmt.Fprintf(&buf, "%-*s  %10s  %s\n", nameLen, name,
+			humanize.Bytes(uint64(fileBytes)),
+			cs.Gray(utils.Pluralize(cs, "event", fileEvents)))
+		lines++
+	}
+
+	fmt.Fprintf(&buf, "%s/%s files done, read %s and %s\n",
+		cs.Bold(fmt.Sprint(done)), cs.Bold(fmt.Sprint(len(files))),
+		humanize.Bytes(uint64(bytes)),
+		utils.Pluralize(cs, "event", events))
+	lines++
+
+	clearLines(io.ErrOut(), prevLines)
+	fmt.Fprint(io.ErrOut(), buf.String())
+
+	return lines
+}
+
+// clearLines moves the cursor up the given amount of lines and clears them.
+func clearLines(w io.Writer, lines int) {
+	if lines > 0 {
+		fmt.Fprintf(w, "\x1b[%dA\x1b[J", lines)
+	}
+}
+
+// printFilesSummary prints the ingest status of each file.
+func printFilesSummary(opts *options, files []*fileProgress) error {
+	cs := opts.IO.ColorScheme()
+
+	var header iofmt.HeaderBuilderFunc
+	if opts.IO.IsStdoutTTY() {
+		header = func(w io.Writer, trb iofmt.TableRowBuilder) {
+			fmt.Fprintf(w, "Ingested %s:\n\n", utils.Pluralize(cs, "file", len(files)))
+			trb.AddField("File", cs.Bold)
+			trb.AddField("Ingested", cs.Bold)
+			trb.AddField("Failed", cs.Bold)
+			trb.AddField("Dropped", cs.Bold)
+			trb.AddField("Processed", cs.Bold)
+			trb.AddField("Status", cs.Bold)
+		}
+	}
+
+	contentRow := func(trb iofmt.TableRowBuilder, k int) {
+		p := files[k]
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		res := p.res
+		if res == nil {
+			res = new(axiom.IngestStatus)
+		}
+
+		trb.AddField(p.name, nil)
+		trb.AddField(fmt.Sprint(res.Ingested), nil)
+		if res.Failed > 0 {
+			trb.AddField(fmt.Sprint(res.Failed), cs.Red)
+		} else {
+			trb.AddField(fmt.Sprint(res.Failed), nil)
+		}
+		trb.AddField(fmt.Sprint(p.dropped), nil)
+		trb.AddField(humanize.Bytes(res.ProcessedBytes), cs.Gray)
+
+		switch {
+		case p.err != nil:
+			trb.AddField(p.err.Error(), cs.Red)
+		case !p.done:
+			trb.AddField("skipped", cs.Gray)
+		default:
+			trb.AddField("done", cs.Gray)
+		}
+	}
+
+	return iofmt.FormatToTable(opts.IO, len(files), header, nil, contentRow)
+}
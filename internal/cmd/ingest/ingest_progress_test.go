@@ -0,0 +1,59 @@
+package ingest
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileProgress(t *testing.T) {
+	p := newFileProgress("app.log")
+	assert.Equal(t, "app.log", p.name)
+	assert.Equal(t, "stdin", newFileProgress("-").name)
+
+	p.start()
+
+	// Bytes are counted as they are read.
+	n, err := io.Copy(io.Discard, progressReader{Reader: strings.NewReader("hello\nworld\n"), progress: p})
+	require.NoError(t, err)
+
+	p.event()
+	p.event()
+	p.drop()
+
+	res := &axiom.IngestStatus{Ingested: 2}
+	p.finish(res, nil)
+
+	assert.True(t, p.started)
+	assert.True(t, p.done)
+	assert.Equal(t, n, p.bytes)
+	assert.Equal(t, 3, p.events)
+	assert.Equal(t, 1, p.dropped)
+	assert.Equal(t, res, p.res)
+	assert.NoError(t, p.err)
+
+	p.finish(nil, errors.New("failed"))
+	assert.EqualError(t, p.err, "failed")
+}
+
+func TestFileProgress_Nil(t *testing.T) {
+	var p *fileProgress
+
+	// A nil fileProgress tracks nothing.
+	assert.NotPanics(t, func() {
+		p.start()
+		p.read(1)
+		p.event()
+		p.drop()
+		p.finish(nil, nil)
+	})
+
+	b, err := io.ReadAll(progressReader{Reader: strings.NewReader("hello"), progress: p})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
@@ -0,0 +1,357 @@
+package ingest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/axiomhq/cli/internal/config"
+	"github.com/axiomhq/cli/pkg/terminal"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+// redactedValue replaces masked values.
+const redactedValue = "[REDACTED]"
+
+// Redaction modes.
+const (
+	redactMask = "mask"
+	redactHash = "hash"
+	redactDrop = "drop"
+)
+
+var validRedactModes = []string{redactMask, redactHash, redactDrop}
+
+// redactRule matches sensitive values. If the expression has a capture group,
+// only the captured part of a match is redacted.
+type redactRule struct {
+	name  string
+	re    *regexp.Regexp
+	valid func(s string) bool
+}
+
+// redactRules are the built-in rules.
+var redactRules = []redactRule{
+	{
+		name: "email",
+		re:   regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	{
+		name:  "ip",
+		re:    regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|(?i:[0-9a-f]{0,4}:){2,7}(?:(?:\d{1,3}\.){3}\d{1,3}|[0-9a-f]{1,4})?`),
+		valid: func(s string) bool { return net.ParseIP(s) != nil },
+	},
+	{
+		name:  "card",
+		re:    regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		valid: luhn,
+	},
+	{
+		name: "token",
+		re:   regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`),
+	},
+}
+
+func redactRuleNames() []string {
+	names := make([]string, len(redactRules))
+	for i, rule := range redactRules {
+		names[i] = rule.name
+	}
+	return names
+}
+
+// redactor redacts sensitive values from the string fields of events. It is
+// safe for concurrent use.
+type redactor struct {
+	rules []redactRule
+	mode  string
+	salt  string
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// newRedactor creates the redactor configured by the options. Rules from the
+// named redaction of the configuration file are merged with the ones given by
+// flags. It returns nil, if no rules are configured.
+func newRedactor(opts *options) (*redactor, error) {
+	var red config.Redaction
+	if opts.RedactProfile != "" {
+		var ok bool
+		if red, ok = opts.Config.Redactions[opts.RedactProfile]; !ok {
+			return nil, fmt.Errorf("redaction %q is not configured in %s", opts.RedactProfile, opts.Config.ConfigFilePath)
+		}
+	}
+
+	r := &redactor{
+		mode:   red.Mode,
+		salt:   red.Salt,
+		counts: make(map[string]int),
+	}
+	if opts.RedactMode != "" {
+		r.mode = opts.RedactMode
+	}
+	if opts.RedactSalt != "" {
+		r.salt = opts.RedactSalt
+	}
+	if r.mode == "" {
+		r.mode = redactMask
+	}
+
+	if !contains(validRedactModes, r.mode) {
+		return nil, fmt.Errorf("invalid redaction mode %q: must be one of %s", r.mode, strings.Join(validRedactModes, ", "))
+	} else if r.mode == redactHash && r.salt == "" {
+		return nil, errors.New("hashing redacted values requires a salt")
+	}
+
+	names := append(append([]string{}, red.Rules...), opts.Redact...)
+	for _, name := range names {
+		i := indexOfRule(name)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid redaction rule %q: must be one of %s", name, strings.Join(redactRuleNames(), ", "))
+		}
+		r.rules = append(r.rules, redactRules[i])
+	}
+
+	exprs := append(append([]string{}, red.Regexes...), opts.RedactRegex...)
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction regex %q: %w", expr, err)
+		}
+		r.rules = append(r.rules, redactRule{name: expr, re: re})
+	}
+
+	if len(r.rules) == 0 {
+		return nil, nil
+	}
+	return r, nil
+}
+
+func indexOfRule(name string) int {
+	for i, rule := range redactRules {
+		if rule.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// apply redacts the string values of the event, including the ones of nested
+// objects and arrays.
+func (r *redactor) apply(event map[string]any) (map[string]any, error) {
+	counts := make(map[string]int)
+	r.redactObject(event, counts)
+
+	r.mu.Lock()
+	for name, n := range counts {
+		r.counts[name] += n
+	}
+	r.mu.Unlock()
+
+	return event, nil
+}
+
+func (r *redactor) redactObject(m map[string]any, counts map[string]int) {
+	for k, v := range m {
+		if v, ok := r.redactValue(v, counts); ok {
+			m[k] = v
+		} else {
+			delete(m, k)
+		}
+	}
+}
+
+// redactValue returns the redacted value and false, if the value should be
+// dropped.
+func (r *redactor) redactValue(v any, counts map[string]int) (any, bool) {
+	switch v := v.(type) {
+	case string:
+		return r.redactString(v, counts)
+	case map[string]any:
+		r.redactObject(v, counts)
+	case []any:
+		res := v[:0]
+		for _, e := range v {
+			if e, ok := r.redactValue(e, counts); ok {
+				res = append(res, e)
+			}
+		}
+		return res, true
+	default:
+		// Numbers like credit card numbers are sensitive, too.
+		if n, ok := formatNumber(v); ok {
+			if s, ok := r.redactString(n, counts); !ok {
+				return nil, false
+			} else if s != n {
+				return s, true
+			}
+		}
+	}
+	return v, true
+}
+
+// formatNumber formats a numeric value the way it is written in JSON. It
+// returns false, if the value isn't a number.
+func formatNumber(v any) (string, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.String(), true
+	case int:
+		return strconv.FormatInt(int64(v), 10), true
+	case int8:
+		return strconv.FormatInt(int64(v), 10), true
+	case int16:
+		return strconv.FormatInt(int64(v), 10), true
+	case int32:
+		return strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+func (r *redactor) redactString(s string, counts map[string]int) (string, bool) {
+	for _, rule := range r.rules {
+		var n int
+		s = replaceAllSubmatch(rule.re, s, func(match string) string {
+			if rule.valid != nil && !rule.valid(match) {
+				return match
+			}
+			n++
+
+			if r.mode == redactHash {
+				sum := sha256.Sum256([]byte(r.salt + match))
+				return hex.EncodeToString(sum[:])
+			}
+			return redactedValue
+		})
+
+		if n > 0 {
+			counts[rule.name] += n
+			if r.mode == redactDrop {
+				return "", false
+			}
+		}
+	}
+	return s, true
+}
+
+// replaceAllSubmatch replaces the matches of the expression in s by the result
+// of repl. If the expression has a capture group, only the first one is
+// replaced.
+func replaceAllSubmatch(re *regexp.Regexp, s string, repl func(string) string) string {
+	var (
+		sb       strings.Builder
+		last     int
+		replaced bool
+	)
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		start, end := m[0], m[1]
+		if len(m) > 2 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		if start == end {
+			continue
+		}
+		_, _ = sb.WriteString(s[last:start])
+		_, _ = sb.WriteString(repl(s[start:end]))
+		last, replaced = end, true
+	}
+	if !replaced {
+		return s
+	}
+	_, _ = sb.WriteString(s[last:])
+	return sb.String()
+}
+
+// summary returns the amount of values redacted by each rule, sorted by rule.
+func (r *redactor) summary() ([]string, []int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.counts))
+	for name := range r.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	counts := make([]int, len(names))
+	for i, name := range names {
+		counts[i] = r.counts[name]
+	}
+	return names, counts
+}
+
+// printSummary writes the amount of values redacted by each rule to the
+// standard error.
+func (r *redactor) printSummary(io *terminal.IO) {
+	var (
+		cs            = io.ColorScheme()
+		names, counts = r.summary()
+		total         int
+		perRule       = make([]string, len(names))
+	)
+	for i, name := range names {
+		total += counts[i]
+		perRule[i] = fmt.Sprintf("%s by %s", cs.Bold(strconv.Itoa(counts[i])), name)
+	}
+
+	if total == 0 {
+		fmt.Fprintf(io.ErrOut(), "%s Redacted no values\n", cs.SuccessIcon())
+		return
+	}
+	fmt.Fprintf(io.ErrOut(), "%s Redacted %s (%s)\n",
+		cs.SuccessIcon(),
+		utils.Pluralize(cs, "value", total),
+		strings.Join(perRule, ", "),
+	)
+}
+
+// luhn returns true if the digits in s pass the Luhn checksum, like credit card
+// numbers do.
+func luhn(s string) bool {
+	var (
+		sum    int
+		double bool
+		digits int
+	)
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
@@ -0,0 +1,118 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axiomhq/cli/internal/cmdutil"
+	"github.com/axiomhq/cli/internal/config"
+)
+
+func TestRedactor(t *testing.T) {
+	event := func() map[string]any {
+		event, err := decodeEvent(`{
+			"msg": "login of jane@example.com from 10.0.0.1 and ::1 at 12:30:45",
+			"auth": "Bearer abc.def-123",
+			"card": 4111111111111111,
+			"order": "4111 1111 1111 1112",
+			"nested": {"tags": ["secret=hunter2", "ok"]}
+		}`)
+		require.NoError(t, err)
+		return event
+	}
+
+	opts := &options{
+		Factory:     &cmdutil.Factory{Config: &config.Config{}},
+		Redact:      []string{"email", "ip", "card", "token"},
+		RedactRegex: []string{`secret=\S+`},
+	}
+
+	r, err := newRedactor(opts)
+	require.NoError(t, err)
+
+	res, err := r.apply(event())
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]any{
+		"msg":    "login of [REDACTED] from [REDACTED] and [REDACTED] at 12:30:45",
+		"auth":   "Bearer [REDACTED]",
+		"card":   "[REDACTED]",
+		"order":  "4111 1111 1111 1112",
+		"nested": map[string]any{"tags": []any{"[REDACTED]", "ok"}},
+	}, res)
+
+	names, counts := r.summary()
+	assert.Equal(t, []string{"card", "email", "ip", `secret=\S+`, "token"}, names)
+	assert.Equal(t, []int{1, 1, 2, 1, 1}, counts)
+
+	// Dropping removes the fields holding sensitive values.
+	opts.RedactMode = redactDrop
+	r, err = newRedactor(opts)
+	require.NoError(t, err)
+
+	res, err = r.apply(event())
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]any{
+		"order":  "4111 1111 1111 1112",
+		"nested": map[string]any{"tags": []any{"ok"}},
+	}, res)
+}
+
+func TestRedactor_Numbers(t *testing.T) {
+	r, err := newRedactor(&options{
+		Factory: &cmdutil.Factory{Config: &config.Config{}},
+		Redact:  []string{"card"},
+	})
+	require.NoError(t, err)
+
+	// Events not decoded from JSON, like those of CSV records or OTLP logs,
+	// hold numbers of other types.
+	res, err := r.apply(map[string]any{
+		"cc":     int64(4111111111111111),
+		"card":   uint64(4111111111111111),
+		"ratio":  float64(4111111111111111),
+		"count":  42,
+		"amount": 12.5,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]any{
+		"cc":     "[REDACTED]",
+		"card":   "[REDACTED]",
+		"ratio":  "[REDACTED]",
+		"count":  42,
+		"amount": 12.5,
+	}, res)
+}
+
+func TestNewRedactor_Profile(t *testing.T) {
+	opts := &options{
+		Factory: &cmdutil.Factory{Config: &config.Config{
+			Redactions: map[string]config.Redaction{
+				"compliance": {Rules: []string{"email"}, Mode: redactHash, Salt: "pepper"},
+			},
+		}},
+		RedactProfile: "compliance",
+	}
+
+	r, err := newRedactor(opts)
+	require.NoError(t, err)
+
+	res, err := r.apply(map[string]any{"user": "jane@example.com"})
+	require.NoError(t, err)
+
+	// Hex encoded SHA-256 of "pepperjane@example.com".
+	assert.Equal(t, "2daebb07b6fe2686ef59cd504c8b776ad69262a4e97fbc978e5d7851bdc6fe15", res["user"])
+
+	opts.RedactSalt = ""
+	opts.Config.Redactions["compliance"] = config.Redaction{Mode: redactHash}
+	_, err = newRedactor(opts)
+	assert.EqualError(t, err, "hashing redacted values requires a salt")
+
+	opts.RedactProfile = "missing"
+	_, err = newRedactor(opts)
+	assert.Error(t, err)
+}
@@ -0,0 +1,143 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/axiomhq/cli/internal/client"
+)
+
+const (
+	// retryBaseDelay is the delay before the first retry. It doubles with
+	// every further retry.
+	retryBaseDelay = time.Second / 2
+	// retryMaxDelay caps the delay between two retries.
+	retryMaxDelay = time.Second * 30
+)
+
+// ingestBatch compresses the given batch and ingests it into the dataset.
+// Failed requests are retried with exponential backoff, if the error is
+// considered transient.
+func ingestBatch(ctx context.Context, client *axiom.Client, dataset string, batch []byte, typ axiom.ContentType, opts *options) (*axiom.IngestStatus, error) {
+	compressed, err := zstdCompress(batch)
+	if err != nil {
+		return nil, err
+	}
+	return ingestCompressedBatch(ctx, client, dataset, compressed, typ, axiom.IngestOptions{
+		CSVDelimiter: opts.Delimiter,
+	}, opts)
+}
+
+// ingestCompressedBatch ingests the given zstd compressed batch into the
+// dataset using the given ingest options, retrying failed requests like
+// ingestBatch.
+func ingestCompressedBatch(ctx context.Context, client *axiom.Client, dataset string, compressed []byte, typ axiom.ContentType, ingestOpts axiom.IngestOptions, opts *options) (*axiom.IngestStatus, error) {
+	return withRetries(ctx, opts, func(ctx context.Context) (*axiom.IngestStatus, error) {
+		return client.Datasets.Ingest(ctx, dataset, bytes.NewReader(compressed), typ, axiom.Zstd, ingestOpts)
+	})
+}
+
+// zstdCompress returns the zstd compressed batch.
+func zstdCompress(batch []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zsw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	} else if _, err = zsw.Write(batch); err != nil {
+		return nil, err
+	} else if err = zsw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// withRetries calls fn until it succeeds, returns a permanent error, the
+// configured amount of retries is exhausted or the retry timeout has passed.
+// Between two calls, it waits for an exponentially growing delay with jitter
+// applied or for the delay requested by the server.
+func withRetries(ctx context.Context, opts *options, fn func(context.Context) (*axiom.IngestStatus, error)) (*axiom.IngestStatus, error) {
+	ctx, retryAfter := client.WithRetryAfter(ctx)
+
+	var deadline time.Time
+	if opts.RetryTimeout > 0 {
+		deadline = time.Now().Add(opts.RetryTimeout)
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := fn(ctx)
+		if err == nil || attempt >= opts.MaxRetries || !isRetryable(err) {
+			return res, err
+		}
+
+		delay := retryAfter()
+		if delay == 0 {
+			delay = backoff(attempt)
+		}
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			return res, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return res, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff returns the delay before the given retry attempt: The base delay is
+// doubled with every attempt and capped. Half of the delay is randomized to
+// prevent clients from retrying in lockstep.
+func backoff(attempt int) time.Duration {
+	d := retryMaxDelay
+	if attempt < 16 {
+		if exp := retryBaseDelay << attempt; exp < d {
+			d = exp
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)) //nolint:gosec // Jitter doesn't need to be cryptographically secure.
+}
+
+// isRetryable returns true if the error is transient and the request should be
+// retried: The server asked for it, the request timed out or the connection
+// failed. Other network errors, like failed TLS handshakes, invalid
+// certificates or unknown hosts, are permanent.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	} else if errors.Is(err, axiom.ErrRateLimitExceeded) {
+		return true
+	}
+
+	var apiErr axiom.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Status >= http.StatusInternalServerError || apiErr.Status == http.StatusTooManyRequests
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// Temporary is deprecated on net.Error, but still reported by DNS
+	// errors, like a server failure.
+	var tempErr interface{ Temporary() bool }
+	if errors.As(err, &tempErr) && tempErr.Temporary() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
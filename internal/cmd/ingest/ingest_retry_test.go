@@ -0,0 +1,107 @@
+package ingest
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://api.axiom.co", Err: err}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"canceled", urlErr(context.Canceled), false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"rate limited", fmt.Errorf("ingest: %w", axiom.ErrRateLimitExceeded), true},
+		{"server error", axiom.Error{Status: 503}, true},
+		{"too many requests", axiom.Error{Status: 429}, true},
+		{"bad request", axiom.Error{Status: 400}, false},
+		{"timeout", urlErr(&net.OpError{Op: "dial", Err: timeoutError{}}), true},
+		{"connection refused", urlErr(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), true},
+		{"connection reset", urlErr(&net.OpError{Op: "read", Err: syscall.ECONNRESET}), true},
+		{"unexpected EOF", urlErr(io.ErrUnexpectedEOF), true},
+		{"temporary DNS failure", urlErr(&net.DNSError{Err: "server misbehaving", IsTemporary: true}), true},
+		{"unknown host", urlErr(&net.DNSError{Err: "no such host", IsNotFound: true}), false},
+		{"invalid certificate", urlErr(x509.UnknownAuthorityError{}), false},
+		{"other", errors.New("invalid dataset"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isRetryable(tt.err))
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, retryBaseDelay},
+		{3, retryBaseDelay * 8},
+		{10, retryMaxDelay},
+		{100, retryMaxDelay},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				d := backoff(tt.attempt)
+				assert.GreaterOrEqual(t, d, tt.max/2)
+				assert.LessOrEqual(t, d, tt.max)
+			}
+		})
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      *options
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", &options{MaxRetries: 3}, nil, 1, false},
+		{"transient", &options{MaxRetries: 3}, []error{axiom.Error{Status: 502}}, 2, false},
+		{"permanent", &options{MaxRetries: 3}, []error{axiom.Error{Status: 403}}, 1, true},
+		{"exhausted", &options{MaxRetries: 0}, []error{axiom.Error{Status: 502}}, 1, true},
+		// The delay before the first retry exceeds the retry timeout.
+		{"timeout", &options{MaxRetries: 3, RetryTimeout: time.Millisecond}, []error{axiom.Error{Status: 502}}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			_, err := withRetries(context.Background(), tt.opts, func(context.Context) (*axiom.IngestStatus, error) {
+				calls++
+				if calls <= len(tt.errs) {
+					return nil, tt.errs[calls-1]
+				}
+				return new(axiom.IngestStatus), nil
+			})
+			assert.Equal(t, tt.wantCalls, calls)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,228 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/dustin/go-humanize"
+
+	"github.com/axiomhq/cli/pkg/iofmt"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+// maxRoutes is the maximum amount of distinct datasets events are routed to.
+// Events which would be routed to further datasets are ingested into the
+// default one, so a field with many distinct values doesn't create as many
+// datasets.
+const maxRoutes = 100
+
+// datasetNameRegexp matches valid dataset names.
+var datasetNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,127}$`)
+
+// router determines the dataset each event is ingested into. It is safe for
+// concurrent use.
+type router struct {
+	field  string
+	tmpl   *template.Template
+	def    string
+	create bool
+
+	mu       sync.Mutex
+	routes   map[string]bool
+	created  map[string]bool
+	statuses map[string]*axiom.IngestStatus
+	// invalid and exceeded count the events ingested into the default
+	// dataset, as the name they were routed to is invalid or the maximum
+	// amount of routes was exceeded.
+	invalid  int
+	exceeded int
+}
+
+// newRouter creates the router configured by the options. It returns nil, if
+// events are not routed.
+func newRouter(opts *options) (*router, error) {
+	if opts.RouteBy == "" {
+		if opts.RouteTemplate != "" || opts.RouteDefault != "" || opts.CreateDatasets {
+			return nil, errors.New("--route-template, --route-default and --create-datasets are only valid with --route-by")
+		}
+		return nil, nil
+	}
+
+	r := &router{
+		field:    opts.RouteBy,
+		def:      opts.RouteDefault,
+		create:   opts.CreateDatasets,
+		routes:   make(map[string]bool),
+		created:  make(map[string]bool),
+		statuses: make(map[string]*axiom.IngestStatus),
+	}
+	if r.def == "" {
+		r.def = opts.Dataset
+	}
+	if r.def == "" {
+		return nil, errors.New("--route-by requires --route-default or a dataset to ingest events into which can't be routed")
+	}
+
+	if opts.RouteTemplate != "" {
+		var err error
+		if r.tmpl, err = template.New("route").Option("missingkey=error").Parse(opts.RouteTemplate); err != nil {
+			return nil, fmt.Errorf("invalid --route-template: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+// route returns the dataset the event is ingested into: The value of the
+// routing field, formatted using the template, if configured. Events without
+// the routing field, for which the template fails, which are routed to an
+// invalid dataset name or to more than the maximum amount of datasets are
+// ingested into the default dataset.
+func (r *router) route(event map[string]any) string {
+	m, k, ok := lookupField(event, r.field)
+	if !ok || m[k] == nil {
+		return r.def
+	}
+
+	dataset := fmt.Sprint(m[k])
+	if r.tmpl != nil {
+		var sb strings.Builder
+		if err := r.tmpl.Execute(&sb, event); err != nil || sb.Len() == 0 {
+			return r.def
+		}
+		dataset = sb.String()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case r.routes[dataset]:
+	case !datasetNameRegexp.MatchString(dataset):
+		r.invalid++
+		return r.def
+	case len(r.routes) >= maxRoutes:
+		r.exceeded++
+		return r.def
+	default:
+		r.routes[dataset] = true
+	}
+	return dataset
+}
+
+// ingest ingests the batch into the dataset and records the result. If
+// configured, a missing dataset is created before retrying.
+func (r *router) ingest(ctx context.Context, client *axiom.Client, dataset string, batch []byte, typ axiom.ContentType, opts *options) (*axiom.IngestStatus, error) {
+	res, err := ingestBatch(ctx, client, dataset, batch, typ, opts)
+	if errors.Is(err, axiom.ErrNotFound) && r.create && !r.wasCreated(dataset) {
+		if _, err = client.Datasets.Create(ctx, axiom.DatasetCreateRequest{
+			Name:        dataset,
+			Description: "Created by the Axiom CLI when routing ingested events",
+		}); err == nil {
+			r.markCreated(dataset)
+		} else if !errors.Is(err, axiom.ErrExists) {
+			return nil, fmt.Errorf("could not create dataset %q: %w", dataset, err)
+		}
+		res, err = ingestBatch(ctx, client, dataset, batch, typ, opts)
+	}
+	if err != nil {
+		return res, fmt.Errorf("dataset %q: %w", dataset, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.statuses[dataset] == nil {
+		r.statuses[dataset] = new(axiom.IngestStatus)
+	}
+	mergeIngestStatuses(r.statuses[dataset], res)
+
+	return res, nil
+}
+
+// wasCreated reports whether the dataset has been created.
+func (r *router) wasCreated(dataset string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.created[dataset]
+}
+
+// markCreated marks the dataset as created.
+func (r *router) markCreated(dataset string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.created[dataset] = true
+}
+
+// printSummary writes the ingest status of each dataset to the standard
+// output.
+func (r *router) printSummary(opts *options) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	datasets := make([]string, 0, len(r.statuses))
+	for dataset := range r.statuses {
+		datasets = append(datasets, dataset)
+	}
+	sort.Strings(datasets)
+
+	cs := opts.IO.ColorScheme()
+
+	var header iofmt.HeaderBuilderFunc
+	if opts.IO.IsStdoutTTY() {
+		header = func(w io.Writer, trb iofmt.TableRowBuilder) {
+			fmt.Fprintf(w, "Ingested into %s:\n\n", utils.Pluralize(cs, "dataset", len(datasets)))
+			trb.AddField("Dataset", cs.Bold)
+			trb.AddField("Ingested", cs.Bold)
+			trb.AddField("Failed", cs.Bold)
+			trb.AddField("Processed", cs.Bold)
+			trb.AddField("Created", cs.Bold)
+		}
+	}
+
+	contentRow := func(trb iofmt.TableRowBuilder, k int) {
+		var (
+			dataset = datasets[k]
+			status  = r.statuses[dataset]
+			created = "no"
+		)
+		if r.created[dataset] {
+			created = "yes"
+		}
+
+		trb.AddField(dataset, nil)
+		trb.AddField(fmt.Sprint(status.Ingested), nil)
+		if status.Failed > 0 {
+			trb.AddField(fmt.Sprint(status.Failed), cs.Red)
+		} else {
+			trb.AddField(fmt.Sprint(status.Failed), nil)
+		}
+		trb.AddField(humanize.Bytes(status.ProcessedBytes), cs.Gray)
+		trb.AddField(created, cs.Gray)
+	}
+
+	if err := iofmt.FormatToTable(opts.IO, len(datasets), header, nil, contentRow); err != nil {
+		return err
+	}
+
+	if r.invalid > 0 {
+		fmt.Fprintf(opts.IO.ErrOut(), "%s Ingested %s routed to an invalid dataset name into %s\n",
+			cs.WarningIcon(), utils.Pluralize(cs, "event", r.invalid), cs.Bold(r.def))
+	}
+	if r.exceeded > 0 {
+		fmt.Fprintf(opts.IO.ErrOut(), "%s Ingested %s routed to more than %d datasets into %s\n",
+			cs.WarningIcon(), utils.Pluralize(cs, "event", r.exceeded), maxRoutes, cs.Bold(r.def))
+	}
+
+	return nil
+}
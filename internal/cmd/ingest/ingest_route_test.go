@@ -0,0 +1,57 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_Route(t *testing.T) {
+	r, err := newRouter(&options{RouteBy: "service", RouteDefault: "logs-misc"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "api", r.route(map[string]any{"service": "api"}))
+	assert.Equal(t, "logs-misc", r.route(map[string]any{"message": "hi"}))
+
+	r, err = newRouter(&options{RouteBy: "k8s.app", RouteTemplate: "logs-{{.k8s.app}}", Dataset: "logs"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "logs-web", r.route(map[string]any{"k8s": map[string]any{"app": "web"}}))
+	assert.Equal(t, "logs", r.route(map[string]any{"k8s": map[string]any{}}))
+}
+
+func TestNewRouter_Invalid(t *testing.T) {
+	_, err := newRouter(&options{RouteBy: "service"})
+	assert.EqualError(t, err, "--route-by requires --route-default or a dataset to ingest events into which can't be routed")
+
+	_, err = newRouter(&options{RouteDefault: "logs"})
+	assert.EqualError(t, err, "--route-template, --route-default and --create-datasets are only valid with --route-by")
+
+	_, err = newRouter(&options{RouteBy: "service", RouteDefault: "logs", RouteTemplate: "{{"})
+	assert.Error(t, err)
+
+	r, err := newRouter(&options{})
+	require.NoError(t, err)
+	assert.Nil(t, r)
+}
+
+func TestRouter_Route_Invalid(t *testing.T) {
+	r, err := newRouter(&options{RouteBy: "service", RouteDefault: "logs-misc"})
+	require.NoError(t, err)
+
+	for _, name := range []string{"../x", "a/b", "x?y", ".hidden", "", strings.Repeat("a", 129)} {
+		assert.Equal(t, "logs-misc", r.route(map[string]any{"service": name}), name)
+	}
+	assert.Equal(t, 6, r.invalid)
+
+	// Events are routed to at most the maximum amount of datasets.
+	for i := 0; i < maxRoutes; i++ {
+		assert.Equal(t, fmt.Sprintf("svc-%d", i), r.route(map[string]any{"service": fmt.Sprintf("svc-%d", i)}))
+	}
+	assert.Equal(t, "logs-misc", r.route(map[string]any{"service": "one-too-many"}))
+	assert.Equal(t, "svc-0", r.route(map[string]any{"service": "svc-0"}))
+	assert.Equal(t, 1, r.exceeded)
+}
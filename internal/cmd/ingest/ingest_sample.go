@@ -0,0 +1,109 @@
+package ingest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/cli/pkg/terminal"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+// sampler keeps a fraction of the events and drops the others. It is safe for
+// concurrent use.
+type sampler struct {
+	rate  float64
+	field string
+
+	mu      sync.Mutex
+	rnd     *rand.Rand
+	dropped int
+}
+
+// newSampler returns the sampler for the configured sample rate or nil, if all
+// events are kept.
+func newSampler(opts *options) (*sampler, error) {
+	if opts.Sample <= 0 || opts.Sample > 1 || math.IsNaN(opts.Sample) {
+		return nil, fmt.Errorf("invalid --sample %v: must be greater than 0 and at most 1", opts.Sample)
+	} else if opts.Sample == 1 {
+		if opts.SampleBy != "" {
+			return nil, errors.New("--sample-by requires --sample")
+		}
+		return nil, nil
+	}
+
+	return &sampler{
+		rate:  opts.Sample,
+		field: opts.SampleBy,
+		rnd:   rand.New(rand.NewSource(time.Now().UnixNano())), //nolint:gosec // Sampling doesn't need to be cryptographically secure.
+	}, nil
+}
+
+// apply drops the event, unless it is sampled.
+func (s *sampler) apply(event map[string]any) (map[string]any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keep(event) {
+		return event, nil
+	}
+	s.dropped++
+	return nil, nil
+}
+
+// keep reports whether the event is sampled. If the sampler is keyed by a
+// field, the decision is derived from the hash of its value, so all events
+// sharing a value are either kept or dropped. Events without the field are
+// sampled randomly. The caller must hold the lock.
+func (s *sampler) keep(event map[string]any) bool {
+	if s.field != "" {
+		if m, k, ok := lookupField(event, s.field); ok {
+			return sampleHash(m[k]) < s.rate
+		}
+	}
+	return s.rnd.Float64() < s.rate
+}
+
+// count returns the amount of events dropped.
+func (s *sampler) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.dropped
+}
+
+// printSummary writes the amount of events dropped to the standard error, if
+// any.
+func (s *sampler) printSummary(io *terminal.IO) {
+	if s == nil || !io.IsStderrTTY() {
+		return
+	}
+
+	if dropped := s.count(); dropped > 0 {
+		cs := io.ColorScheme()
+		fmt.Fprintf(io.ErrOut(), "%s Dropped %s by sampling, keeping %s\n",
+			cs.SuccessIcon(),
+			utils.Pluralize(cs, "event", dropped),
+			cs.Bold(fmt.Sprintf("%g%%", s.rate*100)),
+		)
+	}
+}
+
+// sampleHash maps the value to a number in [0, 1) using the SHA-256 hash of
+// its JSON representation, which is uniformly distributed even for similar
+// values.
+func sampleHash(v any) float64 {
+	h := sha256.New()
+	if b, err := json.Marshal(v); err == nil {
+		_, _ = h.Write(b)
+	} else {
+		_, _ = fmt.Fprint(h, v)
+	}
+	return float64(binary.BigEndian.Uint64(h.Sum(nil))>>11) / (1 << 53)
+}
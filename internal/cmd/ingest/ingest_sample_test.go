@@ -0,0 +1,63 @@
+package ingest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSampler(t *testing.T) {
+	s, err := newSampler(&options{Sample: 1})
+	require.NoError(t, err)
+	assert.Nil(t, s)
+
+	_, err = newSampler(&options{Sample: 1, SampleBy: "trace_id"})
+	assert.EqualError(t, err, "--sample-by requires --sample")
+
+	for _, rate := range []float64{0, -0.5, 1.5} {
+		_, err = newSampler(&options{Sample: rate})
+		assert.Error(t, err, rate)
+	}
+}
+
+func TestSampler(t *testing.T) {
+	s, err := newSampler(&options{Sample: 0.1})
+	require.NoError(t, err)
+
+	var kept int
+	for i := 0; i < 10000; i++ {
+		if event, err := s.apply(map[string]any{"n": i}); assert.NoError(t, err) && event != nil {
+			kept++
+		}
+	}
+
+	assert.InDelta(t, 1000, kept, 200)
+	assert.Equal(t, 10000-kept, s.count())
+}
+
+func TestSampler_SampleBy(t *testing.T) {
+	s, err := newSampler(&options{Sample: 0.5, SampleBy: "trace.id"})
+	require.NoError(t, err)
+
+	var kept int
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("trace-%d", i)
+
+		first, err := s.apply(map[string]any{"trace": map[string]any{"id": id}})
+		require.NoError(t, err)
+		if first != nil {
+			kept++
+		}
+
+		// Events sharing the value are kept or dropped together.
+		for j := 0; j < 3; j++ {
+			event, err := s.apply(map[string]any{"trace": map[string]any{"id": id}, "n": j})
+			require.NoError(t, err)
+			assert.Equal(t, first != nil, event != nil, id)
+		}
+	}
+
+	assert.InDelta(t, 500, kept, 100)
+}
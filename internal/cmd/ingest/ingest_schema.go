@@ -0,0 +1,1120 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/axiomhq/cli/pkg/terminal"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+// Schema modes.
+const (
+	schemaStrict = "strict"
+	schemaWarn   = "warn"
+)
+
+var validSchemaModes = []string{schemaStrict, schemaWarn}
+
+// plainColors formats validation errors without colors, as they end up in
+// reject files.
+var plainColors = terminal.NewColorScheme(false)
+
+// schemaDialect is the URI of the only JSON Schema dialect supported.
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// unsupportedSchemaKeywords are the keywords of draft 2020-12 which are not
+// implemented. Schemas using them are refused instead of being validated only
+// partially.
+var unsupportedSchemaKeywords = []string{
+	"$dynamicRef",
+	"$dynamicAnchor",
+	"unevaluatedItems",
+	"unevaluatedProperties",
+}
+
+// schemaReject is a line of the reject file, written for an event which
+// doesn't match the schema.
+type schemaReject struct {
+	// Path is the JSON pointer to the value of the event which is invalid.
+	Path string `json:"path"`
+	// Error describes why the value is invalid.
+	Error string `json:"error"`
+	// Event is the invalid event.
+	Event map[string]any `json:"event"`
+}
+
+// schemaValidator validates events against a JSON Schema. Invalid events are
+// written to the reject file, if given, and dropped in strict mode. It is safe
+// for concurrent use.
+type schemaValidator struct {
+	schema *jsonSchema
+	strict bool
+
+	mu      sync.Mutex
+	invalid int
+	// example is the validation error of the first invalid event.
+	example error
+}
+
+// newSchemaValidator creates a validator for the JSON Schema in the file
+// configured by the options. It returns nil, if no schema is configured.
+func newSchemaValidator(opts *options) (*schemaValidator, error) {
+	if opts.Schema == "" {
+		return nil, nil
+	} else if !contains(validSchemaModes, opts.SchemaMode) {
+		return nil, fmt.Errorf("invalid --schema-mode %q: must be one of %s", opts.SchemaMode, strings.Join(validSchemaModes, ", "))
+	}
+
+	b, err := os.ReadFile(opts.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("could not read --schema: %w", err)
+	}
+	schema, err := parseSchema(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --schema %q: %w", opts.Schema, err)
+	}
+
+	return &schemaValidator{
+		schema: schema,
+		strict: opts.SchemaMode == schemaStrict,
+	}, nil
+}
+
+// apply validates the event. An invalid event is written to the reject file,
+// if not nil, and dropped in strict mode.
+func (sv *schemaValidator) apply(event map[string]any, rejects *rejectsFile) (map[string]any, error) {
+	verr := sv.schema.validate(event, "")
+	if verr == nil {
+		return event, nil
+	}
+
+	sv.mu.Lock()
+	if sv.invalid == 0 {
+		sv.example = verr
+	}
+	sv.invalid++
+	sv.mu.Unlock()
+
+	if rejects != nil {
+		if err := rejects.writeEvent(schemaReject{
+			Path:  displayPointer(verr.path),
+			Error: verr.msg,
+			Event: event,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if sv.strict {
+		return nil, nil
+	}
+	return event, nil
+}
+
+// printSummary writes the amount of invalid events to the standard error, if
+// any. It is always printed, so invalid events don't go unnoticed when running
+// non-interactively. It returns true, if invalid events were dropped in strict
+// mode.
+func (sv *schemaValidator) printSummary(io *terminal.IO, rejectFile string) bool {
+	if sv == nil {
+		return false
+	}
+
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if sv.invalid == 0 {
+		return false
+	}
+
+	var (
+		cs    = io.ColorScheme()
+		icon  = cs.WarningIcon()
+		verb  = "Ingested"
+		where string
+	)
+	if sv.strict {
+		icon, verb = cs.ErrorIcon(), "Rejected"
+	}
+	if rejectFile != "" {
+		where = fmt.Sprintf(", wrote them to %s", cs.Bold(rejectFile))
+	}
+	fmt.Fprintf(io.ErrOut(), "%s %s %s not matching the schema%s, e.g. %s\n",
+		icon, verb,
+		utils.Pluralize(cs, "event", sv.invalid),
+		where,
+		cs.Bold(sv.example.Error()),
+	)
+
+	return sv.strict
+}
+
+// schemaError is a validation error.
+type schemaError struct {
+	// path is the JSON pointer to the invalid value.
+	path string
+	msg  string
+}
+
+func (e *schemaError) Error() string {
+	return displayPointer(e.path) + ": " + e.msg
+}
+
+// schemaNumber is a number of a schema, exactly as given.
+type schemaNumber struct {
+	r *big.Rat
+	s string
+}
+
+// schemaPattern is a compiled pattern of "patternProperties".
+type schemaPattern struct {
+	re     *regexp.Regexp
+	schema *jsonSchema
+}
+
+// jsonSchema is a compiled JSON Schema.
+type jsonSchema struct {
+	// boolean is set for the schemas true and false, which hold no keywords.
+	boolean *bool
+
+	ref *jsonSchema
+
+	types    []string
+	enum     []any
+	constant any
+	hasConst bool
+
+	minimum          *schemaNumber
+	maximum          *schemaNumber
+	exclusiveMinimum *schemaNumber
+	exclusiveMaximum *schemaNumber
+	multipleOf       *schemaNumber
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	prefixItems []*jsonSchema
+	items       *jsonSchema
+	contains    *jsonSchema
+	minContains *int
+	maxContains *int
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	properties           map[string]*jsonSchema
+	patternProperties    []schemaPattern
+	additionalProperties *jsonSchema
+	propertyNames        *jsonSchema
+	required             []string
+	dependentRequired    map[string][]string
+	dependentSchemas     map[string]*jsonSchema
+	minProperties        *int
+	maxProperties        *int
+
+	allOf []*jsonSchema
+	anyOf []*jsonSchema
+	oneOf []*jsonSchema
+	not   *jsonSchema
+
+	ifSchema   *jsonSchema
+	thenSchema *jsonSchema
+	elseSchema *jsonSchema
+}
+
+// parseSchema parses and compiles a JSON Schema of draft 2020-12. References
+// are resolved within the schema, references to other documents are not
+// supported.
+func parseSchema(b []byte) (*jsonSchema, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if m, ok := doc.(map[string]any); ok {
+		if dialect, ok := m["$schema"]; ok && strings.TrimSuffix(fmt.Sprint(dialect), "#") != schemaDialect {
+			return nil, fmt.Errorf("unsupported $schema %q: must be %s", dialect, schemaDialect)
+		}
+	}
+
+	c := &schemaCompiler{
+		root:     doc,
+		compiled: make(map[string]*jsonSchema),
+		anchors:  make(map[string]string),
+	}
+	c.findAnchors(doc, "")
+	s, err := c.compile(doc, "")
+	if err != nil {
+		return nil, err
+	} else if err = c.checkCycles(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// schemaCompiler compiles the schemas of a document.
+type schemaCompiler struct {
+	root any
+	// compiled are the schemas compiled so far by their JSON pointer, so
+	// every schema is compiled once, even if referenced recursively.
+	compiled map[string]*jsonSchema
+	// anchors are the JSON pointers of the schemas by their anchor.
+	anchors map[string]string
+}
+
+// findAnchors records the JSON pointer of every schema holding an "$anchor".
+func (c *schemaCompiler) findAnchors(v any, ptr string) {
+	switch v := v.(type) {
+	case map[string]any:
+		if anchor, ok := v["$anchor"].(string); ok {
+			c.anchors[anchor] = ptr
+		}
+		for k, sub := range v {
+			// Values of these keywords are data, not schemas.
+			if k == "enum" || k == "const" || k == "default" || k == "examples" {
+				continue
+			}
+			c.findAnchors(sub, ptr+"/"+escapePointer(k))
+		}
+	case []any:
+		for i, sub := range v {
+			c.findAnchors(sub, ptr+"/"+strconv.Itoa(i))
+		}
+	}
+}
+
+// compile compiles the schema found at the JSON pointer.
+func (c *schemaCompiler) compile(v any, ptr string) (*jsonSchema, error) {
+	if s, ok := c.compiled[ptr]; ok {
+		return s, nil
+	}
+	s := new(jsonSchema)
+	c.compiled[ptr] = s
+
+	m, ok := v.(map[string]any)
+	if b, isBool := v.(bool); isBool {
+		s.boolean = &b
+		return s, nil
+	} else if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or a boolean", displayPointer(ptr))
+	}
+
+	for _, k := range unsupportedSchemaKeywords {
+		if _, ok := m[k]; ok {
+			return nil, fmt.Errorf("%s: unsupported keyword %q", displayPointer(ptr), k)
+		}
+	}
+
+	var err error
+	if ref, ok := m["$ref"]; ok {
+		if s.ref, err = c.resolve(ref, ptr); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = c.compileGeneric(s, m, ptr); err != nil {
+		return nil, err
+	} else if err = c.compileNumeric(s, m, ptr); err != nil {
+		return nil, err
+	} else if err = c.compileString(s, m, ptr); err != nil {
+		return nil, err
+	} else if err = c.compileArray(s, m, ptr); err != nil {
+		return nil, err
+	} else if err = c.compileObject(s, m, ptr); err != nil {
+		return nil, err
+	} else if err = c.compileApplicators(s, m, ptr); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// checkCycles rejects schemas which end up being applied to the same value
+// again, like a schema referencing itself. Validating them would never end.
+// Cycles through keywords which apply to a nested value, like "properties",
+// are fine, as validation stops at the leaves of the value.
+func (c *schemaCompiler) checkCycles() error {
+	ptrs := make([]string, 0, len(c.compiled))
+	for ptr := range c.compiled {
+		ptrs = append(ptrs, ptr)
+	}
+	sort.Strings(ptrs)
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*jsonSchema]int, len(c.compiled))
+	// visit returns the first schema found to be part of a cycle.
+	var visit func(s *jsonSchema) *jsonSchema
+	visit = func(s *jsonSchema) *jsonSchema {
+		switch state[s] {
+		case visiting:
+			return s
+		case visited:
+			return nil
+		}
+		state[s] = visiting
+		for _, sub := range s.inPlace() {
+			if cyclic := visit(sub); cyclic != nil {
+				return cyclic
+			}
+		}
+		state[s] = visited
+		return nil
+	}
+
+	for _, ptr := range ptrs {
+		cyclic := visit(c.compiled[ptr])
+		if cyclic == nil {
+			continue
+		}
+		for _, p := range ptrs {
+			if c.compiled[p] == cyclic {
+				return fmt.Errorf("%s: schema is applied to the same value again through a cycle of references", displayPointer(p))
+			}
+		}
+	}
+	return nil
+}
+
+// inPlace returns the subschemas applied to the same value as the schema.
+func (s *jsonSchema) inPlace() []*jsonSchema {
+	var res []*jsonSchema
+	for _, sub := range []*jsonSchema{s.ref, s.not, s.ifSchema, s.thenSchema, s.elseSchema} {
+		if sub != nil {
+			res = append(res, sub)
+		}
+	}
+	res = append(res, s.allOf...)
+	res = append(res, s.anyOf...)
+	res = append(res, s.oneOf...)
+	for _, sub := range s.dependentSchemas {
+		res = append(res, sub)
+	}
+	return res
+}
+
+// resolve compiles the schema the reference points to. Only JSON pointers
+// and anchors within the document are supported.
+func (c *schemaCompiler) resolve(ref any, ptr string) (*jsonSchema, error) {
+	s, ok := ref.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s/$ref: must be a string", ptr)
+	} else if !strings.HasPrefix(s, "#") {
+		return nil, fmt.Errorf("%s/$ref: unsupported reference %q: only references within the schema are supported", ptr, s)
+	}
+
+	target, err := url.PathUnescape(s[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%s/$ref: invalid reference %q: %w", ptr, s, err)
+	}
+	if target != "" && !strings.HasPrefix(target, "/") {
+		anchor, ok := c.anchors[target]
+		if !ok {
+			return nil, fmt.Errorf("%s/$ref: unknown anchor %q", ptr, target)
+		}
+		target = anchor
+	}
+
+	v, ok := lookupPointer(c.root, target)
+	if !ok {
+		return nil, fmt.Errorf("%s/$ref: reference %q points to nothing", ptr, s)
+	}
+	return c.compile(v, target)
+}
+
+func (c *schemaCompiler) compileGeneric(s *jsonSchema, m map[string]any, ptr string) error {
+	switch v := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{v}
+	case []any:
+		for _, t := range v {
+			s.types = append(s.types, fmt.Sprint(t))
+		}
+	default:
+		return fmt.Errorf("%s/type: must be a string or an array", ptr)
+	}
+	for _, t := range s.types {
+		switch t {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return fmt.Errorf("%s/type: unknown type %q", ptr, t)
+		}
+	}
+
+	if v, ok := m["enum"]; ok {
+		if s.enum, ok = v.([]any); !ok {
+			return fmt.Errorf("%s/enum: must be an array", ptr)
+		}
+	}
+	s.constant, s.hasConst = m["const"]
+
+	return nil
+}
+
+func (c *schemaCompiler) compileNumeric(s *jsonSchema, m map[string]any, ptr string) (err error) {
+	if s.minimum, err = schemaNumberOf(m, "minimum", ptr); err != nil {
+		return err
+	} else if s.maximum, err = schemaNumberOf(m, "maximum", ptr); err != nil {
+		return err
+	} else if s.exclusiveMinimum, err = schemaNumberOf(m, "exclusiveMinimum", ptr); err != nil {
+		return err
+	} else if s.exclusiveMaximum, err = schemaNumberOf(m, "exclusiveMaximum", ptr); err != nil {
+		return err
+	} else if s.multipleOf, err = schemaNumberOf(m, "multipleOf", ptr); err != nil {
+		return err
+	} else if s.multipleOf != nil && s.multipleOf.r.Sign() <= 0 {
+		return fmt.Errorf("%s/multipleOf: must be greater than 0", ptr)
+	}
+	return nil
+}
+
+func (c *schemaCompiler) compileString(s *jsonSchema, m map[string]any, ptr string) (err error) {
+	if s.minLength, err = schemaCountOf(m, "minLength", ptr); err != nil {
+		return err
+	} else if s.maxLength, err = schemaCountOf(m, "maxLength", ptr); err != nil {
+		return err
+	}
+
+	if v, ok := m["pattern"]; ok {
+		pattern, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s/pattern: must be a string", ptr)
+		}
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%s/pattern: %w", ptr, err)
+		}
+	}
+	return nil
+}
+
+func (c *schemaCompiler) compileArray(s *jsonSchema, m map[string]any, ptr string) (err error) {
+	if s.prefixItems, err = c.compileList(m, "prefixItems", ptr); err != nil {
+		return err
+	} else if s.items, err = c.compileSub(m, "items", ptr); err != nil {
+		return err
+	} else if s.contains, err = c.compileSub(m, "contains", ptr); err != nil {
+		return err
+	} else if s.minContains, err = schemaCountOf(m, "minContains", ptr); err != nil {
+		return err
+	} else if s.maxContains, err = schemaCountOf(m, "maxContains", ptr); err != nil {
+		return err
+	} else if s.minItems, err = schemaCountOf(m, "minItems", ptr); err != nil {
+		return err
+	} else if s.maxItems, err = schemaCountOf(m, "maxItems", ptr); err != nil {
+		return err
+	}
+	s.uniqueItems, _ = m["uniqueItems"].(bool)
+	return nil
+}
+
+func (c *schemaCompiler) compileObject(s *jsonSchema, m map[string]any, ptr string) (err error) {
+	if s.properties, err = c.compileMap(m, "properties", ptr); err != nil {
+		return err
+	} else if s.additionalProperties, err = c.compileSub(m, "additionalProperties", ptr); err != nil {
+		return err
+	} else if s.propertyNames, err = c.compileSub(m, "propertyNames", ptr); err != nil {
+		return err
+	} else if s.dependentSchemas, err = c.compileMap(m, "dependentSchemas", ptr); err != nil {
+		return err
+	} else if s.minProperties, err = schemaCountOf(m, "minProperties", ptr); err != nil {
+		return err
+	} else if s.maxProperties, err = schemaCountOf(m, "maxProperties", ptr); err != nil {
+		return err
+	}
+
+	patterns, err := c.compileMap(m, "patternProperties", ptr)
+	if err != nil {
+		return err
+	}
+	for pattern, sub := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s/patternProperties: %w", ptr, err)
+		}
+		s.patternProperties = append(s.patternProperties, schemaPattern{re: re, schema: sub})
+	}
+	sort.Slice(s.patternProperties, func(i, j int) bool {
+		return s.patternProperties[i].re.String() < s.patternProperties[j].re.String()
+	})
+
+	if s.required, err = schemaStringsOf(m["required"], ptr+"/required"); err != nil {
+		return err
+	}
+	if v, ok := m["dependentRequired"]; ok {
+		deps, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s/dependentRequired: must be an object", ptr)
+		}
+		s.dependentRequired = make(map[string][]string, len(deps))
+		for k, v := range deps {
+			if s.dependentRequired[k], err = schemaStringsOf(v, ptr+"/dependentRequired/"+escapePointer(k)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *schemaCompiler) compileApplicators(s *jsonSchema, m map[string]any, ptr string) (err error) {
+	if s.allOf, err = c.compileList(m, "allOf", ptr); err != nil {
+		return err
+	} else if s.anyOf, err = c.compileList(m, "anyOf", ptr); err != nil {
+		return err
+	} else if s.oneOf, err = c.compileList(m, "oneOf", ptr); err != nil {
+		return err
+	} else if s.not, err = c.compileSub(m, "not", ptr); err != nil {
+		return err
+	} else if s.ifSchema, err = c.compileSub(m, "if", ptr); err != nil {
+		return err
+	} else if s.thenSchema, err = c.compileSub(m, "then", ptr); err != nil {
+		return err
+	} else if s.elseSchema, err = c.compileSub(m, "else", ptr); err != nil {
+		return err
+	}
+	return nil
+}
+
+// compileSub compiles the schema held by the keyword, if present.
+func (c *schemaCompiler) compileSub(m map[string]any, keyword, ptr string) (*jsonSchema, error) {
+	v, ok := m[keyword]
+	if !ok {
+		return nil, nil
+	}
+	return c.compile(v, ptr+"/"+keyword)
+}
+
+// compileList compiles the array of schemas held by the keyword, if present.
+func (c *schemaCompiler) compileList(m map[string]any, keyword, ptr string) ([]*jsonSchema, error) {
+	v, ok := m[keyword]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s/%s: must be an array", ptr, keyword)
+	}
+
+	res := make([]*jsonSchema, len(list))
+	for i, sub := range list {
+		var err error
+		if res[i], err = c.compile(sub, ptr+"/"+keyword+"/"+strconv.Itoa(i)); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// compileMap compiles the object of schemas held by the keyword, if present.
+func (c *schemaCompiler) compileMap(m map[string]any, keyword, ptr string) (map[string]*jsonSchema, error) {
+	v, ok := m[keyword]
+	if !ok {
+		return nil, nil
+	}
+	subs, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s/%s: must be an object", ptr, keyword)
+	}
+
+	res := make(map[string]*jsonSchema, len(subs))
+	for k, sub := range subs {
+		var err error
+		if res[k], err = c.compile(sub, ptr+"/"+keyword+"/"+escapePointer(k)); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// schemaNumberOf returns the number held by the keyword, if present.
+func schemaNumberOf(m map[string]any, keyword, ptr string) (*schemaNumber, error) {
+	v, ok := m[keyword]
+	if !ok {
+		return nil, nil
+	}
+	if r, ok := toRat(v); ok {
+		return &schemaNumber{r: r, s: fmt.Sprint(v)}, nil
+	}
+	return nil, fmt.Errorf("%s/%s: must be a number", ptr, keyword)
+}
+
+// schemaCountOf returns the non-negative integer held by the keyword, if
+// present.
+func schemaCountOf(m map[string]any, keyword, ptr string) (*int, error) {
+	v, ok := m[keyword]
+	if !ok {
+		return nil, nil
+	}
+	if r, ok := toRat(v); ok && r.IsInt() && r.Sign() >= 0 && r.Num().IsInt64() {
+		n := int(r.Num().Int64())
+		return &n, nil
+	}
+	return nil, fmt.Errorf("%s/%s: must be a non-negative integer", ptr, keyword)
+}
+
+// schemaStringsOf returns the array of strings, if not nil.
+func schemaStringsOf(v any, ptr string) ([]string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be an array of strings", displayPointer(ptr))
+	}
+
+	res := make([]string, len(list))
+	for i, s := range list {
+		if res[i], ok = s.(string); !ok {
+			return nil, fmt.Errorf("%s: must be an array of strings", displayPointer(ptr))
+		}
+	}
+	return res, nil
+}
+
+// validate validates the value found at the JSON pointer against the schema.
+// It returns the first error found.
+func (s *jsonSchema) validate(v any, path string) *schemaError {
+	if s.boolean != nil {
+		if !*s.boolean {
+			return &schemaError{path, "is not allowed"}
+		}
+		return nil
+	}
+
+	v = normalizeJSON(v)
+
+	if s.ref != nil {
+		if err := s.ref.validate(v, path); err != nil {
+			return err
+		}
+	}
+
+	if len(s.types) > 0 && !hasSchemaType(v, s.types) {
+		return &schemaError{path, fmt.Sprintf("must be of type %s, not %s", strings.Join(s.types, " or "), jsonTypeOf(v))}
+	}
+	if s.hasConst && !jsonEqual(v, s.constant) {
+		return &schemaError{path, fmt.Sprintf("must be %s", jsonString(s.constant))}
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if found = jsonEqual(v, e); found {
+				break
+			}
+		}
+		if !found {
+			return &schemaError{path, fmt.Sprintf("must be one of %s", jsonString(s.enum))}
+		}
+	}
+
+	var err *schemaError
+	switch v := v.(type) {
+	case json.Number:
+		err = s.validateNumber(v, path)
+	case string:
+		err = s.validateString(v, path)
+	case []any:
+		err = s.validateArray(v, path)
+	case map[string]any:
+		err = s.validateObject(v, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.validateApplicators(v, path)
+}
+
+func (s *jsonSchema) validateNumber(v json.Number, path string) *schemaError {
+	r, ok := toRat(v)
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case s.minimum != nil && r.Cmp(s.minimum.r) < 0:
+		return &schemaError{path, "must be at least " + s.minimum.s}
+	case s.maximum != nil && r.Cmp(s.maximum.r) > 0:
+		return &schemaError{path, "must be at most " + s.maximum.s}
+	case s.exclusiveMinimum != nil && r.Cmp(s.exclusiveMinimum.r) <= 0:
+		return &schemaError{path, "must be greater than " + s.exclusiveMinimum.s}
+	case s.exclusiveMaximum != nil && r.Cmp(s.exclusiveMaximum.r) >= 0:
+		return &schemaError{path, "must be less than " + s.exclusiveMaximum.s}
+	case s.multipleOf != nil && !new(big.Rat).Quo(r, s.multipleOf.r).IsInt():
+		return &schemaError{path, "must be a multiple of " + s.multipleOf.s}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateString(v string, path string) *schemaError {
+	n := utf8.RuneCountInString(v)
+	switch {
+	case s.minLength != nil && n < *s.minLength:
+		return &schemaError{path, fmt.Sprintf("must be at least %d characters long", *s.minLength)}
+	case s.maxLength != nil && n > *s.maxLength:
+		return &schemaError{path, fmt.Sprintf("must be at most %d characters long", *s.maxLength)}
+	case s.pattern != nil && !s.pattern.MatchString(v):
+		return &schemaError{path, fmt.Sprintf("must match the pattern %q", s.pattern)}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateArray(v []any, path string) *schemaError {
+	switch {
+	case s.minItems != nil && len(v) < *s.minItems:
+		return &schemaError{path, fmt.Sprintf("must hold at least %s", utils.Pluralize(plainColors, "item", *s.minItems))}
+	case s.maxItems != nil && len(v) > *s.maxItems:
+		return &schemaError{path, fmt.Sprintf("must hold at most %s", utils.Pluralize(plainColors, "item", *s.maxItems))}
+	}
+
+	if s.uniqueItems {
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				if jsonEqual(v[i], v[j]) {
+					return &schemaError{path, fmt.Sprintf("must hold unique items, but items %d and %d are equal", i, j)}
+				}
+			}
+		}
+	}
+
+	for i, item := range v {
+		var sub *jsonSchema
+		if i < len(s.prefixItems) {
+			sub = s.prefixItems[i]
+		} else {
+			sub = s.items
+		}
+		if sub == nil {
+			continue
+		}
+		if err := sub.validate(item, path+"/"+strconv.Itoa(i)); err != nil {
+			return err
+		}
+	}
+
+	if s.contains != nil {
+		matches := 0
+		for i, item := range v {
+			if s.contains.validate(item, path+"/"+strconv.Itoa(i)) == nil {
+				matches++
+			}
+		}
+
+		minContains := 1
+		if s.minContains != nil {
+			minContains = *s.minContains
+		}
+		if matches < minContains {
+			return &schemaError{path, fmt.Sprintf("must hold at least %s matching the schema of contains", utils.Pluralize(plainColors, "item", minContains))}
+		} else if s.maxContains != nil && matches > *s.maxContains {
+			return &schemaError{path, fmt.Sprintf("must hold at most %s matching the schema of contains", utils.Pluralize(plainColors, "item", *s.maxContains))}
+		}
+	}
+
+	return nil
+}
+
+func (s *jsonSchema) validateObject(v map[string]any, path string) *schemaError {
+	switch {
+	case s.minProperties != nil && len(v) < *s.minProperties:
+		return &schemaError{path, fmt.Sprintf("must have at least %s", utils.Pluralize(plainColors, "field", *s.minProperties))}
+	case s.maxProperties != nil && len(v) > *s.maxProperties:
+		return &schemaError{path, fmt.Sprintf("must have at most %s", utils.Pluralize(plainColors, "field", *s.maxProperties))}
+	}
+
+	for _, name := range s.required {
+		if _, ok := v[name]; !ok {
+			return &schemaError{path + "/" + escapePointer(name), "is required"}
+		}
+	}
+
+	// Properties are validated in order, so the error reported for an
+	// event is always the same.
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, dep := range s.dependentRequired[name] {
+			if _, ok := v[dep]; !ok {
+				return &schemaError{path + "/" + escapePointer(dep), fmt.Sprintf("is required by %q", name)}
+			}
+		}
+		if sub := s.dependentSchemas[name]; sub != nil {
+			if err := sub.validate(v, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range names {
+		valuePath := path + "/" + escapePointer(name)
+
+		if s.propertyNames != nil {
+			if err := s.propertyNames.validate(name, valuePath); err != nil {
+				err.msg = "invalid field name: " + err.msg
+				return err
+			}
+		}
+
+		matched := false
+		if sub, ok := s.properties[name]; ok {
+			matched = true
+			if err := sub.validate(v[name], valuePath); err != nil {
+				return err
+			}
+		}
+		for _, p := range s.patternProperties {
+			if !p.re.MatchString(name) {
+				continue
+			}
+			matched = true
+			if err := p.schema.validate(v[name], valuePath); err != nil {
+				return err
+			}
+		}
+		if !matched && s.additionalProperties != nil {
+			if err := s.additionalProperties.validate(v[name], valuePath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *jsonSchema) validateApplicators(v any, path string) *schemaError {
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, path); err != nil {
+			return err
+		}
+	}
+
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if matched = sub.validate(v, path) == nil; matched {
+				break
+			}
+		}
+		if !matched {
+			return &schemaError{path, "must match at least one schema of anyOf"}
+		}
+	}
+
+	if len(s.oneOf) > 0 {
+		var matches []string
+		for i, sub := range s.oneOf {
+			if sub.validate(v, path) == nil {
+				matches = append(matches, strconv.Itoa(i))
+			}
+		}
+		if len(matches) == 0 {
+			return &schemaError{path, "must match exactly one schema of oneOf, but matches none"}
+		} else if len(matches) > 1 {
+			return &schemaError{path, fmt.Sprintf("must match exactly one schema of oneOf, but matches %s", strings.Join(matches, ", "))}
+		}
+	}
+
+	if s.not != nil && s.not.validate(v, path) == nil {
+		return &schemaError{path, "must not match the schema of not"}
+	}
+
+	if s.ifSchema != nil {
+		if s.ifSchema.validate(v, path) == nil {
+			if s.thenSchema != nil {
+				return s.thenSchema.validate(v, path)
+			}
+		} else if s.elseSchema != nil {
+			return s.elseSchema.validate(v, path)
+		}
+	}
+
+	return nil
+}
+
+// normalizeJSON converts the value to the types a JSON value decoded with
+// numbers kept as json.Number is made of. Maps and slices are converted
+// shallowly, as their elements are normalized when validated.
+func normalizeJSON(v any) any {
+	switch v := v.(type) {
+	case nil, bool, string, json.Number, map[string]any, []any:
+		return v
+	case int:
+		return json.Number(strconv.Itoa(v))
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10))
+	case float64:
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+
+	// Other types are converted by encoding them.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var res any
+	if err = dec.Decode(&res); err != nil {
+		return v
+	}
+	return res
+}
+
+// jsonTypeOf returns the JSON type of the normalized value.
+func jsonTypeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if r, ok := toRat(v); ok && r.IsInt() {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// hasSchemaType reports whether the normalized value is of one of the types.
+// Integers are numbers, too.
+func hasSchemaType(v any, types []string) bool {
+	typ := jsonTypeOf(v)
+	for _, t := range types {
+		if t == typ || (t == "number" && typ == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEqual reports whether the values are equal JSON values. Numbers are
+// compared by their value.
+func jsonEqual(a, b any) bool {
+	a, b = normalizeJSON(a), normalizeJSON(b)
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		ra, okA := toRat(a)
+		rb, okB := toRat(b)
+		return okA && okB && ra.Cmp(rb) == 0
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := b[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// toRat converts the number to a rational number.
+func toRat(v any) (*big.Rat, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		return new(big.Rat).SetString(string(v))
+	case int:
+		return new(big.Rat).SetInt64(int64(v)), true
+	case int64:
+		return new(big.Rat).SetInt64(v), true
+	case float64:
+		r := new(big.Rat).SetFloat64(v)
+		return r, r != nil
+	}
+	return nil, false
+}
+
+// jsonString returns the JSON representation of the value.
+func jsonString(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// escapePointer escapes the name for use as a JSON pointer token.
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+// displayPointer returns the JSON pointer for display, which is "/" for the
+// root.
+func displayPointer(ptr string) string {
+	if ptr == "" {
+		return "/"
+	}
+	return ptr
+}
+
+// lookupPointer returns the value the JSON pointer points to.
+func lookupPointer(v any, ptr string) (any, bool) {
+	if ptr == "" {
+		return v, true
+	}
+	for _, token := range strings.Split(ptr[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch cur := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = cur[token]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(cur) {
+				return nil, false
+			}
+			v = cur[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
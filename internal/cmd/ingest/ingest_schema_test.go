@@ -0,0 +1,202 @@
+package ingest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["level", "user"],
+	"properties": {
+		"level": {"enum": ["debug", "info", "error"]},
+		"status": {"type": "integer", "minimum": 100, "exclusiveMaximum": 600},
+		"ratio": {"type": "number", "multipleOf": 0.25},
+		"user": {"$ref": "#/$defs/user"},
+		"tags": {
+			"type": "array",
+			"items": {"type": "string", "pattern": "^[a-z]+$"},
+			"uniqueItems": true,
+			"maxItems": 3
+		},
+		"tree": {"$ref": "#/$defs/node"}
+	},
+	"patternProperties": {"^x-": {"type": "string"}},
+	"additionalProperties": false,
+	"dependentRequired": {"status": ["ratio"]},
+	"$defs": {
+		"user": {
+			"type": "object",
+			"required": ["id"],
+			"properties": {
+				"id": {"type": "string", "minLength": 3},
+				"email": {"type": ["string", "null"]}
+			}
+		},
+		"node": {
+			"type": "object",
+			"properties": {
+				"children": {"type": "array", "items": {"$ref": "#/$defs/node"}}
+			},
+			"oneOf": [{"required": ["leaf"]}, {"required": ["children"]}]
+		}
+	}
+}`
+
+func TestParseSchema_Validate(t *testing.T) {
+	schema, err := parseSchema([]byte(testSchema))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		event string
+		err   string
+	}{
+		{"valid", `{"level": "info", "user": {"id": "abc", "email": null}, "status": 200, "ratio": 0.75, "x-trace": "1", "tags": ["a", "b"], "tree": {"children": [{"leaf": 1}]}}`, ""},
+		{"missing", `{"level": "info"}`, "/user: is required"},
+		{"enum", `{"level": "warn", "user": {"id": "abc"}}`, `/level: must be one of ["debug","info","error"]`},
+		{"type", `{"level": "info", "user": {"id": 123}}`, "/user/id: must be of type string, not integer"},
+		{"min length", `{"level": "info", "user": {"id": "ab"}}`, "/user/id: must be at least 3 characters long"},
+		{"type list", `{"level": "info", "user": {"id": "abc", "email": 1}}`, "/user/email: must be of type string or null, not integer"},
+		{"integer", `{"level": "info", "user": {"id": "abc"}, "status": 200.5, "ratio": 1}`, "/status: must be of type integer, not number"},
+		{"minimum", `{"level": "info", "user": {"id": "abc"}, "status": 99, "ratio": 1}`, "/status: must be at least 100"},
+		{"exclusive maximum", `{"level": "info", "user": {"id": "abc"}, "status": 600, "ratio": 1}`, "/status: must be less than 600"},
+		{"multiple of", `{"level": "info", "user": {"id": "abc"}, "ratio": 0.3}`, "/ratio: must be a multiple of 0.25"},
+		{"dependent required", `{"level": "info", "user": {"id": "abc"}, "status": 200}`, `/ratio: is required by "status"`},
+		{"additional", `{"level": "info", "user": {"id": "abc"}, "foo": 1}`, "/foo: is not allowed"},
+		{"pattern property", `{"level": "info", "user": {"id": "abc"}, "x-trace": 1}`, "/x-trace: must be of type string, not integer"},
+		{"pattern", `{"level": "info", "user": {"id": "abc"}, "tags": ["a", "B"]}`, `/tags/1: must match the pattern "^[a-z]+$"`},
+		{"unique", `{"level": "info", "user": {"id": "abc"}, "tags": ["a", "a"]}`, "/tags: must hold unique items, but items 0 and 1 are equal"},
+		{"max items", `{"level": "info", "user": {"id": "abc"}, "tags": ["a", "b", "c", "d"]}`, "/tags: must hold at most 3 items"},
+		{"recursive", `{"level": "info", "user": {"id": "abc"}, "tree": {"children": [{"children": [{}]}]}}`, "/tree/children/0/children/0: must match exactly one schema of oneOf, but matches none"},
+		{"one of", `{"level": "info", "user": {"id": "abc"}, "tree": {"leaf": 1, "children": []}}`, "/tree: must match exactly one schema of oneOf, but matches 0, 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := decodeEvent(tt.event)
+			require.NoError(t, err)
+
+			if verr := schema.validate(event, ""); tt.err == "" {
+				assert.Nil(t, verr)
+			} else if assert.NotNil(t, verr) {
+				assert.Equal(t, tt.err, verr.Error())
+			}
+		})
+	}
+}
+
+func TestParseSchema_Keywords(t *testing.T) {
+	tests := []struct {
+		schema string
+		value  any
+		err    string
+	}{
+		{`{"const": 1}`, 1.0, ""},
+		{`{"const": {"a": [1]}}`, map[string]any{"a": []any{int64(2)}}, `/: must be {"a":[1]}`},
+		{`{"not": {"type": "string"}}`, "a", "/: must not match the schema of not"},
+		{`{"anyOf": [{"type": "string"}, {"minimum": 3}]}`, int64(2), "/: must match at least one schema of anyOf"},
+		{`{"allOf": [{"type": "integer"}, {"maximum": 3}]}`, 4, "/: must be at most 3"},
+		{`{"if": {"minimum": 10}, "then": {"multipleOf": 10}, "else": {"maximum": 5}}`, 7, "/: must be at most 5"},
+		{`{"if": {"minimum": 10}, "then": {"multipleOf": 10}, "else": {"maximum": 5}}`, 15, "/: must be a multiple of 10"},
+		{`{"prefixItems": [{"type": "string"}], "items": false}`, []any{"a", 1}, "/1: is not allowed"},
+		{`{"contains": {"type": "string"}, "minContains": 2}`, []any{"a", 1}, "/: must hold at least 2 items matching the schema of contains"},
+		{`{"propertyNames": {"maxLength": 2}}`, map[string]any{"abc": 1}, "/abc: invalid field name: must be at most 2 characters long"},
+		{`{"minProperties": 1}`, map[string]any{}, "/: must have at least 1 field"},
+		{`{"$ref": "#node", "$defs": {"n": {"$anchor": "node", "type": "boolean"}}}`, "a", "/: must be of type boolean, not string"},
+		{`{"properties": {"a/b": {"type": "null"}}}`, map[string]any{"a/b": false}, "/a~1b: must be of type null, not boolean"},
+		{`{"type": "array"}`, []string{"a"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			schema, err := parseSchema([]byte(tt.schema))
+			require.NoError(t, err)
+
+			if verr := schema.validate(tt.value, ""); tt.err == "" {
+				assert.Nil(t, verr)
+			} else if assert.NotNil(t, verr) {
+				assert.Equal(t, tt.err, verr.Error())
+			}
+		})
+	}
+}
+
+func TestParseSchema_Invalid(t *testing.T) {
+	tests := []struct {
+		schema string
+		err    string
+	}{
+		{`{"$schema": "http://json-schema.org/draft-07/schema#"}`, `unsupported $schema "http://json-schema.org/draft-07/schema#": must be https://json-schema.org/draft/2020-12/schema`},
+		{`{"type": "text"}`, `/type: unknown type "text"`},
+		{`{"properties": {"a": 1}}`, "/properties/a: schema must be an object or a boolean"},
+		{`{"minLength": -1}`, "/minLength: must be a non-negative integer"},
+		{`{"pattern": "("}`, "/pattern: error parsing regexp: missing closing ): `(`"},
+		{`{"$ref": "other.json#/a"}`, `/$ref: unsupported reference "other.json#/a": only references within the schema are supported`},
+		{`{"$ref": "#/$defs/missing"}`, `/$ref: reference "#/$defs/missing" points to nothing`},
+		{`{"unevaluatedProperties": false}`, `/: unsupported keyword "unevaluatedProperties"`},
+		{`{"$ref": "#"}`, "/: schema is applied to the same value again through a cycle of references"},
+		{`{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`, "/$defs/a: schema is applied to the same value again through a cycle of references"},
+		{`{"$defs": {"a": {"allOf": [{"$ref": "#/$defs/a"}]}}, "$ref": "#/$defs/a"}`, "/$defs/a: schema is applied to the same value again through a cycle of references"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			_, err := parseSchema([]byte(tt.schema))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestSchemaValidator(t *testing.T) {
+	dir := t.TempDir()
+
+	schemaFile := filepath.Join(dir, "event.schema.json")
+	require.NoError(t, os.WriteFile(schemaFile, []byte(`{"required": ["id"]}`), 0o600))
+
+	rejectFile := filepath.Join(dir, "rejects.ndjson")
+	rejects, err := openRejectsFile(rejectFile)
+	require.NoError(t, err)
+	defer rejects.Close()
+
+	for _, mode := range validSchemaModes {
+		sv, err := newSchemaValidator(&options{Schema: schemaFile, SchemaMode: mode})
+		require.NoError(t, err)
+
+		event, err := sv.apply(map[string]any{"id": 1}, rejects)
+		require.NoError(t, err)
+		assert.NotNil(t, event)
+
+		event, err = sv.apply(map[string]any{"name": "a"}, rejects)
+		require.NoError(t, err)
+		assert.Equal(t, mode == schemaWarn, event != nil, mode)
+		assert.Equal(t, 1, sv.invalid)
+	}
+	assert.Zero(t, rejects.count())
+
+	b, err := os.ReadFile(rejectFile)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		var rec schemaReject
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		assert.Equal(t, schemaReject{
+			Path:  "/id",
+			Error: "is required",
+			Event: map[string]any{"name": "a"},
+		}, rec)
+	}
+
+	_, err = newSchemaValidator(&options{Schema: schemaFile, SchemaMode: "block"})
+	assert.EqualError(t, err, `invalid --schema-mode "block": must be one of strict, warn`)
+
+	sv, err := newSchemaValidator(&options{})
+	require.NoError(t, err)
+	assert.Nil(t, sv)
+}
@@ -0,0 +1,544 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/spf13/cobra"
+
+	"github.com/axiomhq/cli/internal/cmd/auth"
+	"github.com/axiomhq/cli/internal/cmdutil"
+	"github.com/axiomhq/cli/pkg/terminal"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+const (
+	// scrapeAccept is the Accept header of scrape requests. The Prometheus
+	// text format is preferred, but OpenMetrics is understood, too.
+	scrapeAccept = "text/plain;version=0.0.4;q=1,application/openmetrics-text;version=1.0.0;q=0.5,*/*;q=0.1"
+	// maxScrapeLineSize is the maximum size of a line of a scrape response.
+	maxScrapeLineSize = 1024 * 1024
+)
+
+// Fields of the events created from samples.
+const (
+	metricField   = "metric"
+	valueField    = "value"
+	instanceField = "instance"
+
+	// exportedLabelPrefix is prepended to labels named like a field every
+	// event holds, like Prometheus does for labels clashing with its own.
+	exportedLabelPrefix = "exported_"
+)
+
+type scrapeOptions struct {
+	*options
+
+	// Targets are the URLs to scrape metrics from.
+	Targets []string
+	// Interval is the duration between two scrapes of a target.
+	Interval time.Duration
+}
+
+func newScrapeCmd(f *cmdutil.Factory) *cobra.Command {
+	opts := &scrapeOptions{
+		options: &options{
+			Factory: f,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "scrape [<dataset-name>] --target <url>,... [--interval <duration>] [--flush-every <duration>] [--max-retries <count>] [--retry-timeout <duration>] [--shutdown-timeout <duration>]",
+		Short: "Scrape Prometheus metrics and ingest them",
+		Long: heredoc.Doc(`
+			Scrape metrics from HTTP endpoints serving the Prometheus text
+			exposition format or OpenMetrics every "--interval" and ingest them
+			into an Axiom dataset, using the credentials of the configured
+			deployment.
+
+			Each sample becomes an event holding the name of the metric in the
+			"metric" field, the value in the "value" field and the labels in
+			fields of their own. Labels named like the "metric", "value" or
+			"_time" field are prefixed with "exported_". The "instance" field
+			holds the host and port of the target, unless set by a label. The
+			time of the scrape is written to the "_time" field, unless the
+			sample has a timestamp.
+			Samples whose value is not a finite number, like NaN, are skipped,
+			as JSON can't hold them.
+
+			A scrape times out after "--interval". Targets which fail to be
+			scraped are reported and scraped again on the next interval.
+
+			Events are sent in batches, just like "axiom ingest" does: They are
+			flushed every "--flush-every" interval or once they reach their
+			maximum size and failed batches are retried with exponential
+			backoff.
+
+			When the command is interrupted, pending events are flushed before
+			it exits, for no longer than "--shutdown-timeout". A second
+			interrupt aborts it immediately.
+		`),
+
+		DisableFlagsInUseLine: true,
+
+		Args:              cmdutil.PopulateFromArgs(f, &opts.Dataset),
+		ValidArgsFunction: cmdutil.DatasetCompletionFunc(f),
+
+		Example: heredoc.Doc(`
+			# Scrape the metrics of a node exporter every 15 seconds and ingest
+			# them into a dataset named "node-metrics":
+			$ axiom ingest scrape node-metrics --target=http://localhost:9100/metrics --interval=15s
+		`),
+
+		PreRunE: cmdutil.ChainRunFuncs(
+			cmdutil.AsksForSetup(f, auth.NewLoginCmd(f)),
+			cmdutil.NeedsActiveDeployment(f),
+			cmdutil.NeedsDatasets(f),
+		),
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if len(opts.Targets) == 0 {
+				return cmdutil.NewFlagErrorf("--target requires at least one URL")
+			}
+			for _, target := range opts.Targets {
+				if _, err := scrapeInstance(target); err != nil {
+					return cmdutil.NewFlagErrorf("invalid --target: %s", err)
+				}
+			}
+			if opts.Interval <= 0 {
+				return cmdutil.NewFlagErrorf("--interval must be positive")
+			} else if opts.MaxRetries < 0 {
+				return cmdutil.NewFlagErrorf("--max-retries must not be negative")
+			} else if opts.FlushEvery <= 0 {
+				return cmdutil.NewFlagErrorf("--flush-every must be positive")
+			}
+
+			if err := complete(cmd.Context(), opts.options); err != nil {
+				return err
+			}
+			return runScrape(cmd.Context(), opts)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&opts.Targets, "target", nil, "URLs to scrape metrics from")
+	cmd.Flags().DurationVar(&opts.Interval, "interval", time.Second*15, "Interval at which the targets are scraped")
+	cmd.Flags().DurationVar(&opts.FlushEvery, "flush-every", time.Second, "Interval at which scraped events are flushed")
+	cmd.Flags().IntVar(&opts.MaxRetries, "max-retries", 5, "Maximum amount of retries of a batch that failed to be ingested because of a transient error")
+	cmd.Flags().DurationVar(&opts.RetryTimeout, "retry-timeout", time.Minute, "Maximum duration to spend on retrying a single batch (0 to only limit by --max-retries)")
+	cmd.Flags().DurationVar(&opts.ShutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "Maximum duration to spend on flushing pending events when interrupted")
+
+	_ = cmd.RegisterFlagCompletionFunc("target", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("interval", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("flush-every", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("max-retries", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("retry-timeout", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("shutdown-timeout", cmdutil.NoCompletion)
+
+	return cmd
+}
+
+func runScrape(ctx context.Context, opts *scrapeOptions) error {
+	client, err := opts.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	var sc *scraper
+	err = serveSink(ctx, client, opts.options, func(s *sink) (func(context.Context) error, error) {
+		sc = newScraper(s, opts.IO, opts.Targets, opts.Interval)
+
+		runCtx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			sc.run(runCtx)
+		}()
+
+		if opts.IO.IsStderrTTY() {
+			cs := opts.IO.ColorScheme()
+			fmt.Fprintf(opts.IO.ErrOut(), "%s Scraping %s every %s, ingesting into dataset %s\n",
+				cs.SuccessIcon(), cs.Bold(strings.Join(opts.Targets, ", ")), opts.Interval, cs.Bold(opts.Dataset))
+		}
+
+		// Scrapes in flight are aborted, as their samples are outdated by
+		// the time they are sent.
+		return func(context.Context) error {
+			cancel()
+			<-done
+			return nil
+		}, nil
+	})
+	if sc != nil {
+		sc.printSummary()
+	}
+	return err
+}
+
+// scraper scrapes metrics from targets every interval and adds their samples to
+// a sink. It is safe for concurrent use.
+type scraper struct {
+	sink     *sink
+	io       *terminal.IO
+	client   *http.Client
+	targets  []string
+	interval time.Duration
+
+	mu       sync.Mutex
+	scrapes  int
+	failures int
+	samples  int
+}
+
+func newScraper(s *sink, io *terminal.IO, targets []string, interval time.Duration) *scraper {
+	return &scraper{
+		sink:     s,
+		io:       io,
+		client:   http.DefaultClient,
+		targets:  targets,
+		interval: interval,
+	}
+}
+
+// run scrapes all targets right away and then every interval, until the
+// context is canceled.
+func (sc *scraper) run(ctx context.Context) {
+	ticker := time.NewTicker(sc.interval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, target := range sc.targets {
+			wg.Add(1)
+			go func(target string) {
+				defer wg.Done()
+				sc.scrapeTarget(ctx, target)
+			}(target)
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrapeTarget scrapes the target and records the outcome. Failures are
+// reported, unless the scrape was aborted.
+func (sc *scraper) scrapeTarget(ctx context.Context, target string) {
+	samples, err := sc.scrape(ctx, target, time.Now())
+	if ctx.Err() != nil {
+		return
+	}
+
+	sc.mu.Lock()
+	sc.scrapes++
+	sc.samples += samples
+	if err != nil {
+		sc.failures++
+	}
+	sc.mu.Unlock()
+
+	if err != nil {
+		cs := sc.io.ColorScheme()
+		fmt.Fprintf(sc.io.ErrOut(), "%s Failed to scrape %s: %s\n", cs.WarningIcon(), cs.Bold(target), err)
+	}
+}
+
+// scrape scrapes the target and adds its samples to the sink. Samples without
+// a timestamp are assigned the given time. It returns the amount of samples
+// added. Nothing is added, if the response can't be parsed.
+func (sc *scraper) scrape(ctx context.Context, target string, now time.Time) (int, error) {
+	instance, err := scrapeInstance(target)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sc.interval)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", scrapeAccept)
+
+	resp, err := sc.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	openMetrics := mediaType == "application/openmetrics-text"
+
+	var events []map[string]any
+	if err = parsePrometheusText(resp.Body, openMetrics, func(sample promSample) error {
+		if math.IsNaN(sample.value) || math.IsInf(sample.value, 0) {
+			return nil
+		}
+		events = append(events, sample.event(instance, now))
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("invalid response: %w", err)
+	}
+
+	return len(events), sc.sink.add(events...)
+}
+
+// printSummary writes the amount of scrapes and samples to the standard
+// error. Failed scrapes are always reported.
+func (sc *scraper) printSummary() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	cs := sc.io.ColorScheme()
+	if sc.failures > 0 {
+		fmt.Fprintf(sc.io.ErrOut(), "%s Failed %s of %s\n",
+			cs.WarningIcon(),
+			utils.Pluralize(cs, "scrape", sc.failures),
+			utils.Pluralize(cs, "scrape", sc.scrapes),
+		)
+	}
+	if sc.io.IsStderrTTY() {
+		fmt.Fprintf(sc.io.ErrOut(), "%s Scraped %s\n",
+			cs.SuccessIcon(),
+			utils.Pluralize(cs, "sample", sc.samples),
+		)
+	}
+}
+
+// scrapeInstance validates the target and returns its host and port.
+func scrapeInstance(target string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("URL %q must use http or https", target)
+	} else if u.Host == "" {
+		return "", fmt.Errorf("URL %q must have a host", target)
+	}
+	return u.Host, nil
+}
+
+// promSample is a sample of the Prometheus text exposition format.
+type promSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+	// timestamp of the sample, if given.
+	timestamp time.Time
+}
+
+// event converts the sample into an event. The instance is only set, if the
+// sample doesn't have a label of that name. Samples without a timestamp are
+// assigned the given time.
+func (sample promSample) event(instance string, now time.Time) map[string]any {
+	event := make(map[string]any, len(sample.labels)+4)
+	event[instanceField] = instance
+	for k, v := range sample.labels {
+		// Labels never overwrite the fields every event is made of.
+		if k == metricField || k == valueField || k == defaultTimestampField {
+			k = exportedLabelPrefix + k
+		}
+		event[k] = v
+	}
+
+	ts := sample.timestamp
+	if ts.IsZero() {
+		ts = now
+	}
+	event[metricField] = sample.name
+	event[valueField] = sample.value
+	event[defaultTimestampField] = ts.UTC().Format(time.RFC3339Nano)
+
+	return event
+}
+
+// parsePrometheusText parses metrics in the Prometheus text exposition format
+// or, if openMetrics is set, the OpenMetrics text format and passes each
+// sample to fn. Comments, including HELP and TYPE lines, and the exemplars of
+// OpenMetrics are ignored.
+func parsePrometheusText(r io.Reader, openMetrics bool, fn func(sample promSample) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxScrapeLineSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if openMetrics && text == "# EOF" {
+			return nil
+		} else if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		sample, err := parsePrometheusSample(text, openMetrics)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		} else if err = fn(sample); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// parsePrometheusSample parses a line holding a sample: The metric name,
+// optionally followed by labels in braces, the value and, optionally, the
+// timestamp, which is in milliseconds for Prometheus and in seconds for
+// OpenMetrics.
+func parsePrometheusSample(s string, openMetrics bool) (promSample, error) {
+	sample := promSample{labels: make(map[string]string)}
+
+	i := strings.IndexAny(s, "{ \t")
+	if i < 0 {
+		return sample, fmt.Errorf("missing value of metric %q", s)
+	}
+	sample.name, s = s[:i], s[i:]
+	if !validPrometheusName(sample.name, true) {
+		return sample, fmt.Errorf("invalid metric name %q", sample.name)
+	}
+
+	if s[0] == '{' {
+		var err error
+		if s, err = parsePrometheusLabels(s[1:], sample.labels); err != nil {
+			return sample, fmt.Errorf("metric %q: %w", sample.name, err)
+		}
+	}
+
+	fields := strings.Fields(s)
+	if openMetrics {
+		// An exemplar follows the sample after a hash.
+		for i, f := range fields {
+			if f == "#" {
+				fields = fields[:i]
+				break
+			}
+		}
+	}
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample, fmt.Errorf("metric %q: must be followed by a value and an optional timestamp", sample.name)
+	}
+
+	var err error
+	if sample.value, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return sample, fmt.Errorf("metric %q: invalid value %q", sample.name, fields[0])
+	}
+
+	if len(fields) == 2 {
+		if openMetrics {
+			sec, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil || math.IsNaN(sec) || math.IsInf(sec, 0) {
+				return sample, fmt.Errorf("metric %q: invalid timestamp %q", sample.name, fields[1])
+			}
+			whole, frac := math.Modf(sec)
+			sample.timestamp = time.Unix(int64(whole), int64(frac*1e9))
+		} else {
+			msec, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return sample, fmt.Errorf("metric %q: invalid timestamp %q", sample.name, fields[1])
+			}
+			sample.timestamp = time.UnixMilli(msec)
+		}
+	}
+
+	return sample, nil
+}
+
+// parsePrometheusLabels parses the labels following the opening brace of a
+// sample into the map and returns what follows the closing brace.
+func parsePrometheusLabels(s string, labels map[string]string) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			return "", errors.New("unterminated labels")
+		}
+		name := strings.TrimSpace(s[:i])
+		if !validPrometheusName(name, false) {
+			return "", fmt.Errorf("invalid label name %q", name)
+		}
+
+		s = strings.TrimLeft(s[i+1:], " \t")
+		if !strings.HasPrefix(s, `"`) {
+			return "", fmt.Errorf("value of label %q must be quoted", name)
+		}
+
+		var (
+			value   strings.Builder
+			escaped bool
+			end     = -1
+		)
+		for i, c := range s[1:] {
+			switch {
+			case escaped:
+				switch c {
+				case 'n':
+					value.WriteByte('\n')
+				case '\\', '"':
+					value.WriteRune(c)
+				default:
+					value.WriteByte('\\')
+					value.WriteRune(c)
+				}
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				end = i + 1
+			default:
+				value.WriteRune(c)
+			}
+			if end >= 0 {
+				break
+			}
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated value of label %q", name)
+		}
+		labels[name] = value.String()
+
+		s = strings.TrimLeft(s[end+1:], " \t")
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if !strings.HasPrefix(s, "}") {
+			return "", fmt.Errorf("labels must be separated by commas after label %q", name)
+		}
+	}
+}
+
+// validPrometheusName reports whether the name is a valid label name or, if
+// metric is set, metric name. Only metric names can contain colons.
+func validPrometheusName(name string, metric bool) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c == ':' && metric:
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/axiomhq/cli/pkg/terminal"
+)
+
+const testMetrics = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{ method = "post", code="400", } 3
+
+# Escapes in label values.
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+node_load1 0.5
+go_gc_duration_seconds{quantile="0.5"} NaN
+`
+
+func TestParsePrometheusText(t *testing.T) {
+	var samples []promSample
+	err := parsePrometheusText(strings.NewReader(testMetrics), false, func(sample promSample) error {
+		samples = append(samples, sample)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, samples, 5)
+
+	assert.Equal(t, "http_requests_total", samples[0].name)
+	assert.Equal(t, map[string]string{"method": "post", "code": "200"}, samples[0].labels)
+	assert.Equal(t, 1027.0, samples[0].value)
+	assert.Equal(t, time.UnixMilli(1395066363000), samples[0].timestamp)
+
+	assert.Equal(t, map[string]string{"method": "post", "code": "400"}, samples[1].labels)
+	assert.True(t, samples[1].timestamp.IsZero())
+
+	assert.Equal(t, map[string]string{"path": `C:\DIR\FILE.TXT`, "error": "Cannot find file:\n\"FILE.TXT\""}, samples[2].labels)
+	assert.Equal(t, 1.458255915e9, samples[2].value)
+
+	assert.Equal(t, "node_load1", samples[3].name)
+	assert.Empty(t, samples[3].labels)
+	assert.Equal(t, 0.5, samples[3].value)
+}
+
+func TestParsePrometheusText_OpenMetrics(t *testing.T) {
+	const metrics = `# TYPE foo counter
+foo_total{a="b"} 17.0 1520879607.789 # {trace_id="KOO5S4vxi0o"} 0.67
+# EOF
+bar 1
+`
+
+	var samples []promSample
+	err := parsePrometheusText(strings.NewReader(metrics), true, func(sample promSample) error {
+		samples = append(samples, sample)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+
+	assert.Equal(t, "foo_total", samples[0].name)
+	assert.Equal(t, 17.0, samples[0].value)
+	assert.Equal(t, int64(1520879607789), samples[0].timestamp.UnixMilli())
+}
+
+func TestParsePrometheusText_Invalid(t *testing.T) {
+	tests := []struct {
+		line string
+		err  string
+	}{
+		{"foo", `line 1: missing value of metric "foo"`},
+		{"1foo 1", `line 1: invalid metric name "1foo"`},
+		{"foo bar", `line 1: metric "foo": invalid value "bar"`},
+		{"foo 1 2 3", `line 1: metric "foo": must be followed by a value and an optional timestamp`},
+		{"foo 1 1.5", `line 1: metric "foo": invalid timestamp "1.5"`},
+		{`foo{a:b="c"} 1`, `line 1: metric "foo": invalid label name "a:b"`},
+		{`foo{a=b} 1`, `line 1: metric "foo": value of label "a" must be quoted`},
+		{`foo{a="b} 1`, `line 1: metric "foo": unterminated value of label "a"`},
+		{`foo{a="b" c="d"} 1`, `line 1: metric "foo": labels must be separated by commas after label "a"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			err := parsePrometheusText(strings.NewReader(tt.line), false, func(promSample) error {
+				return nil
+			})
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestPromSample_Event(t *testing.T) {
+	now := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	sample := promSample{
+		name: "up",
+		labels: map[string]string{
+			"metric":   "a",
+			"value":    "b",
+			"_time":    "c",
+			"instance": "d",
+			"job":      "e",
+		},
+		value: 1,
+	}
+	assert.Equal(t, map[string]any{
+		"metric":          "up",
+		"value":           1.0,
+		"_time":           "2022-07-01T12:00:00Z",
+		"instance":        "d",
+		"job":             "e",
+		"exported_metric": "a",
+		"exported_value":  "b",
+		"exported__time":  "c",
+	}, sample.event("localhost:9100", now))
+}
+
+func TestScraper(t *testing.T) {
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Accept"), "text/plain;version=0.0.4")
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(testMetrics))
+	}))
+	defer exporter.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	// Failures are reported using the color scheme, which TestIO lacks.
+	io := terminal.NewIO()
+	s := newSink(context.Background(), nil, &options{Dataset: "test"})
+	sc := newScraper(s, io, []string{exporter.URL, failing.URL}, time.Second)
+
+	now := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+	n, err := sc.scrape(context.Background(), exporter.URL, now)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.EqualValues(t, 4, s.status().Received)
+
+	lines := strings.Split(strings.TrimSpace(s.bufs.get("test").String()), "\n")
+	require.Len(t, lines, 4)
+
+	events := make([]map[string]any, len(lines))
+	for i, line := range lines {
+		require.NoError(t, json.Unmarshal([]byte(line), &events[i]))
+	}
+	assert.Equal(t, map[string]any{
+		"metric":   "http_requests_total",
+		"value":    1027.0,
+		"method":   "post",
+		"code":     "200",
+		"instance": strings.TrimPrefix(exporter.URL, "http://"),
+		"_time":    "2014-03-17T14:26:03Z",
+	}, events[0])
+	assert.Equal(t, map[string]any{
+		"metric":   "node_load1",
+		"value":    0.5,
+		"instance": strings.TrimPrefix(exporter.URL, "http://"),
+		"_time":    "2022-07-01T12:00:00Z",
+	}, events[3])
+
+	_, err = sc.scrape(context.Background(), failing.URL, now)
+	assert.EqualError(t, err, "unexpected status 503 Service Unavailable")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sc.scrapeTarget(ctx, failing.URL)
+	cancel()
+	assert.Equal(t, 1, sc.failures)
+}
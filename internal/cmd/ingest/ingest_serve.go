@@ -0,0 +1,580 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/cobra"
+
+	"github.com/axiomhq/cli/internal/cmd/auth"
+	"github.com/axiomhq/cli/internal/cmdutil"
+)
+
+const (
+	// defaultShutdownTimeout is the maximum duration spent on finishing
+	// requests in flight and flushing pending events when stopped, if not
+	// configured otherwise.
+	defaultShutdownTimeout = time.Second * 10
+	// maxRequestSize is the maximum size of an uncompressed request body.
+	maxRequestSize = 64 * 1024 * 1024
+	// sinkChunkSize is the maximum amount of events of a request added to the
+	// sink at once.
+	sinkChunkSize = 1000
+)
+
+type serveOptions struct {
+	*options
+
+	// Listen is the address to listen on for HTTP requests.
+	Listen string
+}
+
+func newServeCmd(f *cmdutil.Factory) *cobra.Command {
+	opts := &serveOptions{
+		options: &options{
+			Factory: f,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "serve [<dataset-name>] [--listen <address>] [--flush-every <duration>] [--max-retries <count>] [--retry-timeout <duration>] [--shutdown-timeout <duration>] [--timestamp-field <timestamp-field>,...] [--timestamp-format <timestamp-format> ...] [--timestamp-timezone <timezone>]",
+		Short: "Run a local HTTP gateway that ingests what it receives",
+		Long: heredoc.Doc(`
+			Run a local HTTP gateway that ingests the events it receives into an
+			Axiom dataset, using the credentials of the configured deployment.
+			Applications only need to know the URL of the gateway instead of an
+			Axiom token.
+
+			The following endpoints are served:
+
+			  POST /ingest               Newline delimited JSON or an array of
+			                             JSON objects
+			  POST /_bulk                Elasticsearch bulk requests
+			  POST /<index>/_bulk        (index and create actions)
+			  POST /loki/api/v1/push     Loki push requests in JSON
+			  GET  /healthz              Counters of the gateway as JSON
+
+			Requests are not authenticated, so anyone who can reach the gateway
+			can ingest into the dataset. It only listens on the loopback
+			interface by default and a warning is printed, if "--listen" is set
+			to an address reachable from other hosts.
+
+			Request bodies can be compressed using gzip or zstd, as indicated by
+			their Content-Encoding header. Requests with a body exceeding 64 MiB,
+			once decompressed, are rejected as a whole, as are requests holding
+			an invalid event. Received events are acknowledged
+			right away and sent in batches, just like "axiom ingest" does: They
+			are flushed every "--flush-every" interval or once they reach their
+			maximum size and failed batches are retried with exponential
+			backoff. Events of batches that can't be sent are reported and
+			counted as dropped.
+
+			Timestamps are parsed like "axiom ingest" does, if
+			"--timestamp-field", "--timestamp-format" or "--timestamp-timezone"
+			is given.
+
+			When the command is interrupted, requests in flight are finished and
+			pending events are flushed before it exits, for no longer than
+			"--shutdown-timeout". A second interrupt aborts it immediately.
+		`),
+
+		DisableFlagsInUseLine: true,
+
+		Args:              cmdutil.PopulateFromArgs(f, &opts.Dataset),
+		ValidArgsFunction: cmdutil.DatasetCompletionFunc(f),
+
+		Example: heredoc.Doc(`
+			# Accept events on port 8080 of the local host and ingest them into a
+			# dataset named "app-logs":
+			$ axiom ingest serve app-logs
+
+			# Send events to the gateway:
+			$ curl -X POST --data-binary @events.ndjson http://localhost:8080/ingest
+
+			# Point an Elasticsearch or Loki client to a gateway on port 9200 of
+			# the local host:
+			$ axiom ingest serve app-logs --listen=127.0.0.1:9200
+		`),
+
+		PreRunE: cmdutil.ChainRunFuncs(
+			cmdutil.AsksForSetup(f, auth.NewLoginCmd(f)),
+			cmdutil.NeedsActiveDeployment(f),
+			cmdutil.NeedsDatasets(f),
+		),
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if opts.MaxRetries < 0 {
+				return cmdutil.NewFlagErrorf("--max-retries must not be negative")
+			} else if opts.FlushEvery <= 0 {
+				return cmdutil.NewFlagErrorf("--flush-every must be positive")
+			}
+
+			var err error
+			if opts.timestamps, err = newTimestamps(opts.options); err != nil {
+				return cmdutil.NewFlagErrorf("%s", err)
+			} else if opts.timestamps != nil {
+				opts.processors = append(opts.processors, opts.timestamps.apply)
+			}
+
+			if err = complete(cmd.Context(), opts.options); err != nil {
+				return err
+			}
+			return runServe(cmd.Context(), opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Listen, "listen", "127.0.0.1:8080", "Address to listen on for HTTP requests")
+	cmd.Flags().DurationVar(&opts.FlushEvery, "flush-every", time.Second, "Interval at which received events are flushed")
+	cmd.Flags().IntVar(&opts.MaxRetries, "max-retries", 5, "Maximum amount of retries of a batch that failed to be ingested because of a transient error")
+	cmd.Flags().DurationVar(&opts.RetryTimeout, "retry-timeout", time.Minute, "Maximum duration to spend on retrying a single batch (0 to only limit by --max-retries)")
+	cmd.Flags().DurationVar(&opts.ShutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "Maximum duration to spend on finishing requests and flushing pending events when interrupted")
+	cmd.Flags().StringSliceVar(&opts.TimestampFields, "timestamp-field", nil, "Fields to take the ingestion time from, the first one present is used (defaults to _time)")
+	cmd.Flags().StringArrayVar(&opts.TimestampFormats, "timestamp-format", nil, "Format used in the the timestamp field, can be repeated to try multiple formats in order. Default uses a heuristic parser. Must be expressed using the reference time 'Mon Jan 2 15:04:05 -0700 MST 2006'")
+	cmd.Flags().StringVar(&opts.TimestampTimezone, "timestamp-timezone", "", "Time zone of timestamps which don't specify one, e.g. Europe/Berlin (defaults to UTC)")
+
+	_ = cmd.RegisterFlagCompletionFunc("listen", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("flush-every", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("max-retries", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("retry-timeout", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("shutdown-timeout", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("timestamp-field", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("timestamp-format", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("timestamp-timezone", cmdutil.NoCompletion)
+
+	return cmd
+}
+
+func runServe(ctx context.Context, opts *serveOptions) error {
+	client, err := opts.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return err
+	}
+
+	return serveSink(ctx, client, opts.options, func(s *sink) (func(context.Context) error, error) {
+		srv := &http.Server{
+			Handler:           newGateway(s),
+			ReadHeaderTimeout: time.Second * 10,
+		}
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				cs := opts.IO.ColorScheme()
+				fmt.Fprintf(opts.IO.ErrOut(), "%s Failed to serve: %s\n", cs.ErrorIcon(), err)
+			}
+		}()
+
+		// Anyone who can reach the gateway can ingest without a token.
+		if addr, ok := ln.Addr().(*net.TCPAddr); ok && !addr.IP.IsLoopback() {
+			cs := opts.IO.ColorScheme()
+			fmt.Fprintf(opts.IO.ErrOut(), "%s Listening on %s, which is reachable from other hosts without authentication\n",
+				cs.WarningIcon(), cs.Bold(ln.Addr().String()))
+		}
+
+		if opts.IO.IsStderrTTY() {
+			cs := opts.IO.ColorScheme()
+			fmt.Fprintf(opts.IO.ErrOut(), "%s Listening on %s, ingesting into dataset %s\n",
+				cs.SuccessIcon(), cs.Bold("http://"+ln.Addr().String()), cs.Bold(opts.Dataset))
+		}
+
+		return srv.Shutdown, nil
+	})
+}
+
+// serveSink creates a sink and calls start, which starts a server adding the
+// events it receives to the sink and returns a function that stops it. Once
+// the context is canceled, the server is stopped, pending events are flushed
+// and a summary is printed.
+func serveSink(ctx context.Context, client *axiom.Client, opts *options, start func(*sink) (func(context.Context) error, error)) error {
+	// Sending outlives the context, so pending events can be flushed on
+	// shutdown. It is aborted, if that takes longer than the shutdown timeout.
+	sendCtx, cancelSend := cmdutil.ShutdownContext(ctx, opts.ShutdownTimeout)
+	defer cancelSend()
+
+	s := newSink(sendCtx, client, opts)
+
+	stop, err := start(s)
+	if err != nil {
+		return err
+	}
+
+	s.run(ctx)
+
+	// Stopping the server takes no longer than half of the shutdown timeout,
+	// so there is time left to flush the events it received.
+	stopCtx, cancelStop := context.WithTimeout(sendCtx, opts.ShutdownTimeout/2)
+	defer cancelStop()
+	if err = stop(stopCtx); err != nil && stopCtx.Err() == nil {
+		return err
+	}
+	s.flush()
+
+	err = s.printSummary()
+	opts.timestamps.printSummary(opts.IO)
+	return err
+}
+
+// gateway is the HTTP handler of the ingest gateway.
+type gateway struct {
+	sink *sink
+	mux  *http.ServeMux
+
+	requests        uint64
+	invalidRequests uint64
+}
+
+func newGateway(s *sink) *gateway {
+	g := &gateway{
+		sink: s,
+		mux:  http.NewServeMux(),
+	}
+	g.mux.HandleFunc("/healthz", g.healthz)
+	g.mux.HandleFunc("/ingest", g.post(g.ingestJSON))
+	g.mux.HandleFunc("/loki/api/v1/push", g.post(g.ingestLoki))
+	g.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_bulk" || strings.HasSuffix(r.URL.Path, "/_bulk") {
+			g.post(g.ingestBulk)(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	return g
+}
+
+// ServeHTTP implements http.Handler.
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// gatewayHandler handles a request by decoding its body and returns the
+// status code and response on success.
+type gatewayHandler func(r *http.Request, body io.Reader) (int, any, error)
+
+// post wraps a handler that accepts POST requests with a possibly compressed
+// body.
+func (g *gateway) post(h gatewayHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&g.requests, 1)
+
+		if r.Method != http.MethodPost {
+			atomic.AddUint64(&g.invalidRequests, 1)
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := decompressBody(r)
+		if err != nil {
+			atomic.AddUint64(&g.invalidRequests, 1)
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		defer body.Close()
+
+		code, res, err := h(r, &limitedBody{r: body, n: maxRequestSize})
+		if err != nil {
+			atomic.AddUint64(&g.invalidRequests, 1)
+			if errors.Is(err, errRequestTooLarge) {
+				code = http.StatusRequestEntityTooLarge
+			} else if code == 0 {
+				code = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		if res == nil {
+			w.WriteHeader(code)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+// errRequestTooLarge is returned when reading a request body exceeding the
+// maximum request size.
+var errRequestTooLarge = errors.New("request body too large")
+
+// limitedBody reads at most n bytes of a request body. Unlike an
+// io.LimitedReader, it fails with errRequestTooLarge if the body is larger, so
+// requests are rejected instead of silently being truncated. Unlike
+// http.MaxBytesReader, the error can be told apart from other ones.
+type limitedBody struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errRequestTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		return n + int(l.n), errRequestTooLarge
+	}
+	return n, err
+}
+
+// decompressBody returns the body of the request, decompressed as indicated
+// by its Content-Encoding header.
+func decompressBody(r *http.Request) (io.ReadCloser, error) {
+	switch enc := r.Header.Get("Content-Encoding"); enc {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip":
+		return gzip.NewReader(r.Body)
+	case "zstd":
+		dec, err := zstd.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+}
+
+// ingestJSON handles newline delimited JSON and arrays of JSON objects.
+func (g *gateway) ingestJSON(_ *http.Request, body io.Reader) (int, any, error) {
+	n, err := decodeJSONEvents(body, g.sink.add)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusAccepted, map[string]int{"received": n}, nil
+}
+
+// decodeJSONEvents decodes newline delimited JSON objects or an array of JSON
+// objects and passes them to fn in chunks, once all of them have been decoded,
+// so none are passed on if any is invalid. It returns the amount of events
+// decoded.
+func decodeJSONEvents(r io.Reader, fn func(...map[string]any) error) (int, error) {
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+
+	// Peek at the first non-whitespace byte to tell arrays apart.
+	var isArray bool
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		} else if bytes.ContainsAny(b, " \t\r\n") {
+			_, _ = br.ReadByte()
+			continue
+		}
+		isArray = b[0] == '['
+		break
+	}
+
+	if isArray {
+		if _, err := dec.Token(); err != nil {
+			return 0, err
+		}
+	}
+
+	var events []map[string]any
+	for !isArray || dec.More() {
+		var event map[string]any
+		if err := dec.Decode(&event); err == io.EOF && !isArray {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("invalid event %d: %w", len(events)+1, err)
+		}
+		events = append(events, event)
+	}
+
+	for chunk := events; len(chunk) > 0; {
+		n := len(chunk)
+		if n > sinkChunkSize {
+			n = sinkChunkSize
+		}
+		if err := fn(chunk[:n]...); err != nil {
+			return 0, err
+		}
+		chunk = chunk[n:]
+	}
+
+	return len(events), nil
+}
+
+// bulkItem is the result of an action of an Elasticsearch bulk request.
+type bulkItem struct {
+	Index  string         `json:"_index,omitempty"`
+	Status int            `json:"status"`
+	Result string         `json:"result,omitempty"`
+	Error  map[string]any `json:"error,omitempty"`
+}
+
+// ingestBulk handles Elasticsearch bulk requests. The source documents of
+// index and create actions are ingested. Other actions are rejected.
+func (g *gateway) ingestBulk(_ *http.Request, body io.Reader) (int, any, error) {
+	start := time.Now()
+
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64*1024), maxRequestSize)
+
+	var (
+		items  []map[string]bulkItem
+		events []map[string]any
+		errs   bool
+	)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var action map[string]struct {
+			Index string `json:"_index"`
+		}
+		if err := json.Unmarshal(line, &action); err != nil || len(action) != 1 {
+			return 0, nil, fmt.Errorf("invalid bulk action %q", line)
+		}
+
+		for name, meta := range action {
+			item := bulkItem{Index: meta.Index}
+			switch name {
+			case "index", "create":
+				if !sc.Scan() {
+					return 0, nil, fmt.Errorf("missing source of bulk action %q", line)
+				}
+				event, err := decodeEvent(sc.Text())
+				if err != nil {
+					return 0, nil, fmt.Errorf("invalid bulk source: %w", err)
+				}
+				events = append(events, event)
+				item.Status, item.Result = http.StatusCreated, "created"
+			case "update":
+				// Skip the partial document.
+				_ = sc.Scan()
+				fallthrough
+			case "delete":
+				errs = true
+				item.Status = http.StatusBadRequest
+				item.Error = map[string]any{
+					"type":   "action_request_validation_exception",
+					"reason": fmt.Sprintf("%s actions are not supported", name),
+				}
+			default:
+				return 0, nil, fmt.Errorf("invalid bulk action %q", name)
+			}
+			items = append(items, map[string]bulkItem{name: item})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	if err := g.sink.add(events...); err != nil {
+		return 0, nil, err
+	}
+
+	return http.StatusOK, map[string]any{
+		"took":   time.Since(start).Milliseconds(),
+		"errors": errs,
+		"items":  items,
+	}, nil
+}
+
+// lokiPushRequest is the JSON representation of a Loki push request.
+type lokiPushRequest struct {
+	Streams []struct {
+		Stream map[string]string `json:"stream"`
+		Values [][]any           `json:"values"`
+	} `json:"streams"`
+}
+
+// ingestLoki handles Loki push requests in JSON. Each entry becomes an event
+// holding the line in the "message" field, the labels of its stream and its
+// structured metadata, if any.
+func (g *gateway) ingestLoki(r *http.Request, body io.Reader) (int, any, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+		return http.StatusUnsupportedMediaType, nil, fmt.Errorf("unsupported content type %q: only JSON is supported", ct)
+	}
+
+	var req lokiPushRequest
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		return 0, nil, err
+	}
+
+	var events []map[string]any
+	for _, stream := range req.Streams {
+		for _, value := range stream.Values {
+			if len(value) < 2 {
+				return 0, nil, errors.New("invalid entry: must hold a timestamp and a line")
+			}
+			ts, ok := value[0].(string)
+			if !ok {
+				return 0, nil, fmt.Errorf("invalid timestamp %v: must be a string", value[0])
+			}
+			nsec, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return 0, nil, fmt.Errorf("invalid timestamp %q: %w", ts, err)
+			}
+
+			event := make(map[string]any, len(stream.Stream)+3)
+			for k, v := range stream.Stream {
+				event[k] = v
+			}
+			if len(value) > 2 {
+				if metadata, ok := value[2].(map[string]any); ok {
+					for k, v := range metadata {
+						event[k] = v
+					}
+				}
+			}
+			event[defaultTimestampField] = time.Unix(0, nsec).UTC().Format(time.RFC3339Nano)
+			event[messageField] = value[1]
+
+			events = append(events, event)
+		}
+	}
+
+	if err := g.sink.add(events...); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusNoContent, nil, nil
+}
+
+// healthz responds with the counters of the gateway.
+func (g *gateway) healthz(w http.ResponseWriter, _ *http.Request) {
+	res := struct {
+		Status          string `json:"status"`
+		Requests        uint64 `json:"requests"`
+		InvalidRequests uint64 `json:"invalidRequests"`
+		sinkStats
+	}{
+		Status:          "ok",
+		Requests:        atomic.LoadUint64(&g.requests),
+		InvalidRequests: atomic.LoadUint64(&g.invalidRequests),
+		sinkStats:       g.sink.status(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
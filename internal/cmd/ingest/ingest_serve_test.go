@@ -0,0 +1,123 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGateway(t *testing.T) {
+	s := newSink(context.Background(), nil, &options{Dataset: "test"})
+	g := newGateway(s)
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantCode int
+		want     string
+	}{
+		{
+			name:     "ndjson",
+			path:     "/ingest",
+			body:     "{\"a\":1}\n\n{\"a\":2}\n",
+			wantCode: http.StatusAccepted,
+			want:     "{\"a\":1}\n{\"a\":2}\n",
+		},
+		{
+			name:     "json array",
+			path:     "/ingest",
+			body:     `[{"a":1},{"a":2}]`,
+			wantCode: http.StatusAccepted,
+			want:     "{\"a\":1}\n{\"a\":2}\n",
+		},
+		{
+			name:     "elasticsearch bulk",
+			path:     "/logs/_bulk",
+			body:     "{\"index\":{\"_index\":\"logs\"}}\n{\"a\":1}\n{\"delete\":{\"_id\":\"1\"}}\n{\"create\":{}}\n{\"a\":2}\n",
+			wantCode: http.StatusOK,
+			want:     "{\"a\":1}\n{\"a\":2}\n",
+		},
+		{
+			name:     "loki push",
+			path:     "/loki/api/v1/push",
+			body:     `{"streams":[{"stream":{"app":"web"},"values":[["1640995200000000000","hello",{"trace":"t1"}]]}]}`,
+			wantCode: http.StatusNoContent,
+			want:     "{\"_time\":\"2022-01-01T00:00:00Z\",\"app\":\"web\",\"message\":\"hello\",\"trace\":\"t1\"}\n",
+		},
+		{
+			name:     "invalid json",
+			path:     "/ingest",
+			body:     `{"a":`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			// Events of a request are only added, if all of them are valid.
+			name:     "partially invalid json",
+			path:     "/ingest",
+			body:     "{\"a\":1}\n{\"a\":",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "unknown path",
+			path:     "/unknown",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.bufs.get("test").Reset()
+
+			rec := httptest.NewRecorder()
+			g.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+
+			require.Equal(t, tt.wantCode, rec.Code, rec.Body.String())
+			assert.Equal(t, tt.want, s.bufs.get("test").String())
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"requests":6,"invalidRequests":2,"received":7`)
+}
+
+func TestGateway_RequestTooLarge(t *testing.T) {
+	s := newSink(context.Background(), nil, &options{Dataset: "test"})
+	g := newGateway(s)
+
+	// The body only exceeds the maximum size once decompressed.
+	var body bytes.Buffer
+	gzw := gzip.NewWriter(&body)
+	_, err := gzw.Write([]byte(`{"a":"` + strings.Repeat("x", maxRequestSize) + `"}`))
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/ingest", &body)
+	req.Header.Set("Content-Encoding", "gzip")
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Zero(t, s.bufs.get("test").Len())
+}
+
+func TestLimitedBody(t *testing.T) {
+	b, err := io.ReadAll(&limitedBody{r: strings.NewReader("abc"), n: 3})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(b))
+
+	b, err = io.ReadAll(&limitedBody{r: strings.NewReader("abcd"), n: 3})
+	assert.ErrorIs(t, err, errRequestTooLarge)
+	assert.Equal(t, "abc", string(b))
+}
@@ -0,0 +1,335 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom"
+
+	"github.com/axiomhq/cli/internal/cmdutil"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+// sinkStats are the counters of a sink.
+type sinkStats struct {
+	// Received is the amount of events added to the sink.
+	Received uint64 `json:"received"`
+	// Ingested is the amount of events ingested by the server.
+	Ingested uint64 `json:"ingested"`
+	// Failed is the amount of events rejected by the server.
+	Failed uint64 `json:"failed"`
+	// Dropped is the amount of events lost, because their batch couldn't be
+	// sent.
+	Dropped uint64 `json:"dropped"`
+	// Spooled is the amount of events written to the spool, because their
+	// batch couldn't be sent.
+	Spooled uint64 `json:"spooled"`
+	// ProcessedBytes is the amount of bytes processed by the server.
+	ProcessedBytes uint64 `json:"processedBytes"`
+	// Batches is the amount of batches sent.
+	Batches uint64 `json:"batches"`
+	// PendingBytes is the size of the events not yet sent.
+	PendingBytes int `json:"pendingBytes"`
+	// LastFlush is the time the last batch was sent.
+	LastFlush time.Time `json:"lastFlush"`
+	// LastError is the error the last batch failed to be sent with.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// sink batches events that are received concurrently, e.g. by a server, and
+// ingests them every flush interval or once a batch reaches the configured
+// limits. Events are processed and routed like the ones read from files.
+// Batches are sent one after another and adding events blocks while a full
+// batch is sent, which keeps memory bounded if the server is slow. It is safe
+// for concurrent use.
+type sink struct {
+	// ctx is used for sending, so sending is neither aborted when a request
+	// that added events is finished nor when the events that are pending on
+	// shutdown are flushed.
+	ctx    context.Context
+	client *axiom.Client
+	opts   *options
+
+	mu      sync.Mutex
+	bufs    *batches
+	counts  map[string]int
+	stats   sinkStats
+	started time.Time
+
+	sendMu sync.Mutex
+}
+
+// newSink creates a sink which ingests into the configured dataset or routes
+// events, if configured. Sending is aborted once the context is canceled.
+func newSink(ctx context.Context, client *axiom.Client, opts *options) *sink {
+	return &sink{
+		ctx:     ctx,
+		client:  client,
+		opts:    opts,
+		bufs:    newBatches(),
+		counts:  make(map[string]int),
+		started: time.Now(),
+	}
+}
+
+// add processes the events and buffers them. Full batches are sent right away.
+func (s *sink) add(events ...map[string]any) error {
+	var batches []sinkBatch
+
+	s.mu.Lock()
+	for _, event := range events {
+		s.stats.Received++
+
+		s.opts.metadata.apply(event, "", 0)
+		event, err := processEvent(event, s.opts.processors)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		} else if event == nil {
+			continue
+		}
+
+		dataset := s.opts.Dataset
+		if s.opts.router != nil {
+			dataset = s.opts.router.route(event)
+		}
+
+		buf := s.bufs.get(dataset)
+		n := buf.Len()
+		if err = appendEvent(buf, event); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.counts[dataset]++
+		s.stats.PendingBytes += buf.Len() - n
+
+		batches = append(batches, s.take(true)...)
+	}
+	s.mu.Unlock()
+
+	s.send(batches)
+
+	return nil
+}
+
+// sinkBatch is a batch of newline delimited JSON taken from a sink.
+type sinkBatch struct {
+	dataset string
+	data    []byte
+	events  int
+}
+
+// take removes the buffered batches and returns them. If onlyFull is true,
+// only the batches that reached the configured limits are taken. The caller
+// must hold the lock.
+func (s *sink) take(onlyFull bool) []sinkBatch {
+	var res []sinkBatch
+	for _, dataset := range s.bufs.datasets() {
+		buf := s.bufs.get(dataset)
+		if buf.Len() == 0 || (onlyFull && !s.opts.batchFull(buf.Len(), s.counts[dataset])) {
+			continue
+		}
+
+		res = append(res, sinkBatch{
+			dataset: dataset,
+			data:    append([]byte(nil), buf.Bytes()...),
+			events:  s.counts[dataset],
+		})
+		s.stats.PendingBytes -= buf.Len()
+		s.counts[dataset] = 0
+		buf.Reset()
+	}
+	return res
+}
+
+// send ingests the batches. Errors are recorded in the stats and printed, as
+// the events might have been added by someone who isn't around anymore.
+func (s *sink) send(batches []sinkBatch) {
+	if len(batches) == 0 {
+		return
+	}
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	for _, batch := range batches {
+		if _, err := s.sendBatch(batch); err != nil {
+			cs := s.opts.IO.ColorScheme()
+			fmt.Fprintf(s.opts.IO.ErrOut(), "%s Failed to ingest %s into dataset %s: %s\n",
+				cs.ErrorIcon(),
+				utils.Pluralize(cs, "event", batch.events),
+				cs.Bold(batch.dataset),
+				err,
+			)
+		}
+	}
+}
+
+// sendBatch ingests the batch and records the result in the stats.
+func (s *sink) sendBatch(batch sinkBatch) (*axiom.IngestStatus, error) {
+	var res *axiom.IngestStatus
+	err := s.opts.throttle.wait(s.ctx, batch.events, len(batch.data))
+	if err == nil && s.opts.router != nil {
+		res, err = s.opts.router.ingest(s.ctx, s.client, batch.dataset, batch.data, axiom.NDJSON, s.opts)
+	} else if err == nil {
+		res, err = ingestBatch(s.ctx, s.client, batch.dataset, batch.data, axiom.NDJSON, s.opts)
+	}
+
+	// Batches spooled before are sent once sending succeeds again. A batch
+	// that failed is sent later, if it can be spooled.
+	spooled := false
+	if err == nil {
+		s.opts.spool.tryDrain(s.ctx, s.client, s.opts)
+	} else if s.opts.spool != nil && spoolable(err) {
+		if spoolErr := s.opts.spool.write(batch.dataset, batch.data, axiom.NDJSON, batch.events, s.opts); spoolErr != nil {
+			err = fmt.Errorf("%w (could not spool batch: %s)", err, spoolErr)
+		} else {
+			spooled = true
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Batches++
+	s.stats.LastFlush = time.Now()
+	if spooled {
+		s.stats.Spooled += uint64(batch.events)
+		s.stats.LastError = err.Error()
+		return new(axiom.IngestStatus), nil
+	} else if err != nil {
+		s.stats.Dropped += uint64(batch.events)
+		s.stats.LastError = err.Error()
+		return nil, err
+	}
+	s.stats.Ingested += res.Ingested
+	s.stats.Failed += res.Failed
+	s.stats.ProcessedBytes += res.ProcessedBytes
+
+	return res, nil
+}
+
+// ingest processes the events and ingests them right away, bypassing the
+// buffer. It is used by servers that report the result to their clients.
+// Events are split into batches according to the configured limits.
+func (s *sink) ingest(events ...map[string]any) (*axiom.IngestStatus, error) {
+	var (
+		bufs    = newBatches()
+		counts  = make(map[string]int)
+		batches []sinkBatch
+	)
+	takeBatch := func(dataset string) {
+		buf := bufs.get(dataset)
+		batches = append(batches, sinkBatch{
+			dataset: dataset,
+			data:    append([]byte(nil), buf.Bytes()...),
+			events:  counts[dataset],
+		})
+		counts[dataset] = 0
+		buf.Reset()
+	}
+
+	s.mu.Lock()
+	s.stats.Received += uint64(len(events))
+	s.mu.Unlock()
+
+	for _, event := range events {
+		s.opts.metadata.apply(event, "", 0)
+		event, err := processEvent(event, s.opts.processors)
+		if err != nil {
+			return nil, err
+		} else if event == nil {
+			continue
+		}
+
+		dataset := s.opts.Dataset
+		if s.opts.router != nil {
+			dataset = s.opts.router.route(event)
+		}
+
+		buf := bufs.get(dataset)
+		if err = appendEvent(buf, event); err != nil {
+			return nil, err
+		}
+		if counts[dataset]++; s.opts.batchFull(buf.Len(), counts[dataset]) {
+			takeBatch(dataset)
+		}
+	}
+	for _, dataset := range bufs.datasets() {
+		if counts[dataset] > 0 {
+			takeBatch(dataset)
+		}
+	}
+
+	res := new(axiom.IngestStatus)
+	for _, batch := range batches {
+		batchRes, err := s.sendBatch(batch)
+		if err != nil {
+			return res, err
+		}
+		mergeIngestStatuses(res, batchRes)
+	}
+	return res, nil
+}
+
+// flush sends all buffered events.
+func (s *sink) flush() {
+	s.mu.Lock()
+	batches := s.take(false)
+	s.mu.Unlock()
+
+	s.send(batches)
+}
+
+// run flushes the sink every flush interval until the context is canceled.
+// Pending events are not flushed on return.
+func (s *sink) run(ctx context.Context) {
+	t := time.NewTicker(s.opts.FlushEvery)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.flush()
+		}
+	}
+}
+
+// status returns a snapshot of the counters.
+func (s *sink) status() sinkStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats
+}
+
+// printSummary writes the amount of events ingested, rejected and dropped to
+// the standard error. It returns cmdutil.ErrSilent, if events were lost.
+func (s *sink) printSummary() error {
+	var (
+		stats = s.status()
+		cs    = s.opts.IO.ColorScheme()
+	)
+
+	if s.opts.IO.IsStderrTTY() {
+		fmt.Fprintf(s.opts.IO.ErrOut(), "%s Ingested %s in %s\n",
+			cs.SuccessIcon(),
+			utils.Pluralize(cs, "event", int(stats.Ingested)),
+			time.Since(s.started).Round(time.Second),
+		)
+	}
+
+	if stats.Failed > 0 || stats.Dropped > 0 {
+		fmt.Fprintf(s.opts.IO.ErrOut(), "%s Failed to ingest %s, dropped %s\n",
+			cs.ErrorIcon(),
+			utils.Pluralize(cs, "event", int(stats.Failed)),
+			utils.Pluralize(cs, "event", int(stats.Dropped)),
+		)
+		return cmdutil.ErrSilent
+	}
+	return nil
+}
@@ -0,0 +1,622 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/axiomhq/cli/internal/cmd/auth"
+	"github.com/axiomhq/cli/internal/cmdutil"
+	"github.com/axiomhq/cli/pkg/iofmt"
+	"github.com/axiomhq/cli/pkg/terminal"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+const (
+	// spoolSegmentExt is the extension of the segment files of a spool.
+	spoolSegmentExt = ".seg"
+	// spoolLockName is the name of the file within the spool directory that
+	// is locked while the spool is drained.
+	spoolLockName = ".lock"
+	// defaultSpoolMaxSize is the maximum size of a spool, if not configured
+	// otherwise.
+	defaultSpoolMaxSize = 1024 * 1024 * 1024
+)
+
+type spoolOptions struct {
+	*options
+
+	// Format to output data in. Defaults to tabular output.
+	Format string
+}
+
+func newDrainCmd(f *cmdutil.Factory) *cobra.Command {
+	opts := &options{
+		Factory: f,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "drain --spool-dir <directory> [--max-retries <count>] [--retry-timeout <duration>]",
+		Short: "Send the batches held by a spool",
+		Long: heredoc.Doc(`
+			Send the batches held by a spool directory, which failed to be sent
+			by "axiom ingest --spool-dir", in the order they were written. Sent
+			batches are removed from the spool. Draining stops at the first
+			batch that fails to be sent, so it is kept for the next attempt.
+		`),
+
+		DisableFlagsInUseLine: true,
+
+		Example: heredoc.Doc(`
+			# Send the batches that failed to be ingested on an edge host:
+			$ axiom ingest drain --spool-dir=/var/spool/axiom
+		`),
+
+		PreRunE: cmdutil.ChainRunFuncs(
+			cmdutil.AsksForSetup(f, auth.NewLoginCmd(f)),
+			cmdutil.NeedsActiveDeployment(f),
+		),
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if opts.MaxRetries < 0 {
+				return cmdutil.NewFlagErrorf("--max-retries must not be negative")
+			}
+			return runDrain(cmd.Context(), opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.SpoolDir, "spool-dir", "", "Directory holding the spooled batches")
+	cmd.Flags().IntVar(&opts.MaxRetries, "max-retries", 5, "Maximum amount of retries of a batch that failed to be ingested because of a transient error")
+	cmd.Flags().DurationVar(&opts.RetryTimeout, "retry-timeout", time.Minute, "Maximum duration to spend on retrying a single batch (0 to only limit by --max-retries)")
+
+	_ = cmd.RegisterFlagCompletionFunc("max-retries", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("retry-timeout", cmdutil.NoCompletion)
+
+	_ = cmd.MarkFlagRequired("spool-dir")
+
+	return cmd
+}
+
+func runDrain(ctx context.Context, opts *options) error {
+	client, err := opts.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	s, err := openSpool(opts.SpoolDir, 0)
+	if err != nil {
+		return err
+	}
+
+	stop := opts.IO.StartActivityIndicator()
+	defer stop()
+
+	_, err = s.drain(ctx, client, opts)
+
+	stop()
+
+	if cs := opts.IO.ColorScheme(); s.drained == 0 && err == nil && opts.IO.IsStderrTTY() {
+		fmt.Fprintf(opts.IO.ErrOut(), "%s No batches pending in %s\n", cs.SuccessIcon(), cs.Bold(opts.SpoolDir))
+	}
+	s.printSummary(opts.IO)
+
+	return err
+}
+
+func newSpoolCmd(f *cmdutil.Factory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "spool <command>",
+		Short: "Inspect the spool of batches that failed to be sent",
+		Long:  "Inspect the spool of batches that failed to be sent.",
+
+		Example: heredoc.Doc(`
+			$ axiom ingest spool status --spool-dir=/var/spool/axiom
+		`),
+	}
+
+	cmd.AddCommand(newSpoolStatusCmd(f))
+
+	return cmd
+}
+
+func newSpoolStatusCmd(f *cmdutil.Factory) *cobra.Command {
+	opts := &spoolOptions{
+		options: &options{
+			Factory: f,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "status --spool-dir <directory> [(-f|--format)=json|table]",
+		Short: "Show the batches pending in a spool",
+
+		DisableFlagsInUseLine: true,
+
+		Example: heredoc.Doc(`
+			# Show the batches pending in a spool directory, oldest first:
+			$ axiom ingest spool status --spool-dir=/var/spool/axiom
+		`),
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runSpoolStatus(cmd.Context(), opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.SpoolDir, "spool-dir", "", "Directory holding the spooled batches")
+	cmd.Flags().StringVarP(&opts.Format, "format", "f", iofmt.Table.String(), "Format to output data in")
+
+	_ = cmd.RegisterFlagCompletionFunc("format", cmdutil.FormatCompletion)
+
+	_ = cmd.MarkFlagRequired("spool-dir")
+
+	return cmd
+}
+
+// spoolStatus is the status of a spool.
+type spoolStatus struct {
+	// Dir is the directory of the spool.
+	Dir string `json:"dir"`
+	// PendingBytes is the size of the segments pending.
+	PendingBytes int64 `json:"pendingBytes"`
+	// PendingEvents is the amount of events pending.
+	PendingEvents int `json:"pendingEvents"`
+	// Oldest is the time the oldest segment was written.
+	Oldest *time.Time `json:"oldest,omitempty"`
+	// Segments pending, oldest first.
+	Segments []spoolSegment `json:"segments"`
+}
+
+func runSpoolStatus(ctx context.Context, opts *spoolOptions) error {
+	// The status of a spool that doesn't exist yet is an empty one.
+	s := &spool{dir: opts.SpoolDir}
+	segs, err := s.segments()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	status := spoolStatus{
+		Dir:      opts.SpoolDir,
+		Segments: segs,
+	}
+	for _, seg := range segs {
+		status.PendingBytes += seg.Size
+		status.PendingEvents += seg.Events
+	}
+	if len(segs) > 0 {
+		status.Oldest = &segs[0].Created
+	}
+
+	pagerStop, err := opts.IO.StartPager(ctx)
+	if err != nil {
+		return err
+	}
+	defer pagerStop()
+
+	if opts.Format == iofmt.JSON.String() {
+		return iofmt.FormatToJSON(opts.IO.Out(), status, opts.IO.ColorEnabled())
+	}
+
+	cs := opts.IO.ColorScheme()
+
+	if len(segs) == 0 {
+		if opts.IO.IsStdoutTTY() {
+			fmt.Fprintf(opts.IO.Out(), "No batches pending in %s\n", cs.Bold(opts.SpoolDir))
+		}
+		return nil
+	}
+
+	var header iofmt.HeaderBuilderFunc
+	if opts.IO.IsStdoutTTY() {
+		header = func(w io.Writer, trb iofmt.TableRowBuilder) {
+			fmt.Fprintf(w, "Showing %s pending in %s: %s, %s",
+				utils.Pluralize(cs, "segment", len(segs)), cs.Bold(opts.SpoolDir),
+				humanize.IBytes(uint64(status.PendingBytes)),
+				utils.Pluralize(cs, "event", status.PendingEvents))
+			if status.Oldest != nil {
+				fmt.Fprintf(w, ", oldest written %s", humanize.Time(*status.Oldest))
+			}
+			fmt.Fprint(w, "\n\n")
+			trb.AddField("Segment", cs.Bold)
+			trb.AddField("Dataset", cs.Bold)
+			trb.AddField("Events", cs.Bold)
+			trb.AddField("Size", cs.Bold)
+			trb.AddField("Age", cs.Bold)
+		}
+	}
+
+	contentRow := func(trb iofmt.TableRowBuilder, k int) {
+		seg := segs[k]
+
+		trb.AddField(seg.Name, nil)
+		trb.AddField(seg.Dataset, nil)
+		trb.AddField(fmt.Sprint(seg.Events), nil)
+		trb.AddField(humanize.IBytes(uint64(seg.Size)), nil)
+		trb.AddField(time.Since(seg.Created).Round(time.Second).String(), cs.Gray)
+	}
+
+	return iofmt.FormatToTable(opts.IO, len(segs), header, nil, contentRow)
+}
+
+// segmentHeader describes the batch held by a segment. It is written as a line
+// of JSON in front of the batch, which is compressed using zstd, just like it
+// is sent to the server.
+type segmentHeader struct {
+	// Dataset the batch is ingested into.
+	Dataset string `json:"dataset"`
+	// ContentType of the batch: json, ndjson or csv.
+	ContentType string `json:"contentType"`
+	// Events is the amount of events in the batch.
+	Events int `json:"events"`
+	// CSVDelimiter is the ingest option the batch is sent with.
+	CSVDelimiter string `json:"csvDelimiter,omitempty"`
+	// Created is the time the batch failed to be sent.
+	Created time.Time `json:"created"`
+}
+
+// spoolSegment is a segment file of a spool.
+type spoolSegment struct {
+	segmentHeader
+
+	// Name of the segment file within the spool directory.
+	Name string `json:"name"`
+	// Size of the segment file.
+	Size int64 `json:"size"`
+}
+
+// spool persists batches which failed to be sent to a directory, as segment
+// files which are sent in the order they were written by a later run. Once the
+// segments exceed the maximum size of the spool, the oldest ones are evicted.
+// It is safe for concurrent use.
+type spool struct {
+	dir     string
+	maxSize int64
+
+	mu       sync.Mutex
+	seq      int
+	pending  bool
+	spooled  int
+	evicted  int
+	drained  int
+	drainMu  sync.Mutex
+	drainErr error
+}
+
+// openSpool opens the spool in the given directory, creating it if needed.
+func openSpool(dir string, maxSize int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &spool{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+
+	segs, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	s.pending = len(segs) > 0
+
+	return s, nil
+}
+
+// write persists the batch as a new segment and evicts the oldest segments, if
+// the spool exceeds its maximum size.
+func (s *spool) write(dataset string, batch []byte, typ axiom.ContentType, events int, opts *options) error {
+	compressed, err := zstdCompress(batch)
+	if err != nil {
+		return err
+	}
+
+	header, err := json.Marshal(segmentHeader{
+		Dataset:      dataset,
+		ContentType:  contentTypeName(typ),
+		Events:       events,
+		CSVDelimiter: opts.Delimiter,
+		Created:      time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Segment names sort in the order the segments were written.
+	s.seq++
+	name := fmt.Sprintf("%019d-%08d-%06d%s", time.Now().UnixNano(), os.Getpid(), s.seq, spoolSegmentExt)
+	path := filepath.Join(s.dir, name)
+
+	// Write to a temporary file first and then rename it so a segment is never
+	// left half written.
+	tmp := path + ".tmp"
+	data := append(append(header, '\n'), compressed...)
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	} else if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	s.spooled += events
+	s.pending = true
+
+	return s.evict()
+}
+
+// evict removes the oldest segments until the spool doesn't exceed its
+// maximum size. The caller must hold the lock.
+func (s *spool) evict() error {
+	if s.maxSize <= 0 {
+		return nil
+	}
+
+	segs, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, seg := range segs {
+		size += seg.Size
+	}
+
+	for _, seg := range segs {
+		if size <= s.maxSize {
+			break
+		}
+		if err = os.Remove(filepath.Join(s.dir, seg.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		size -= seg.Size
+		s.evicted += seg.Events
+	}
+
+	return nil
+}
+
+// segments returns the segments of the spool, oldest first.
+func (s *spool) segments() ([]spoolSegment, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	segs := make([]spoolSegment, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || filepath.Ext(entry.Name()) != spoolSegmentExt {
+			continue
+		}
+
+		seg, err := readSegmentHeader(filepath.Join(s.dir, entry.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			// Sent or evicted in the meantime.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		segs = append(segs, seg)
+	}
+
+	sort.Slice(segs, func(i, j int) bool {
+		return segs[i].Name < segs[j].Name
+	})
+
+	return segs, nil
+}
+
+// readSegmentHeader reads the header of the segment file at the given path.
+func readSegmentHeader(path string) (spoolSegment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return spoolSegment{}, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return spoolSegment{}, err
+	}
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return spoolSegment{}, err
+	}
+
+	seg := spoolSegment{
+		Name: filepath.Base(path),
+		Size: fi.Size(),
+	}
+	if err = json.Unmarshal(line, &seg.segmentHeader); err != nil {
+		return spoolSegment{}, fmt.Errorf("invalid segment %q: %w", seg.Name, err)
+	}
+	return seg, nil
+}
+
+// readSegment reads the header and the compressed batch of the segment file
+// at the given path.
+func readSegment(path string) (segmentHeader, []byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return segmentHeader{}, nil, err
+	}
+
+	var header segmentHeader
+	line, data, ok := bytes.Cut(b, []byte{'\n'})
+	if !ok {
+		return segmentHeader{}, nil, fmt.Errorf("invalid segment %q: missing header", filepath.Base(path))
+	} else if err = json.Unmarshal(line, &header); err != nil {
+		return segmentHeader{}, nil, fmt.Errorf("invalid segment %q: %w", filepath.Base(path), err)
+	}
+	return header, data, nil
+}
+
+// drain sends the segments of the spool in the order they were written and
+// removes them once sent. It stops at the first segment that fails to be sent,
+// so the order is kept.
+func (s *spool) drain(ctx context.Context, client *axiom.Client, opts *options) (*axiom.IngestStatus, error) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+
+	return s.drainLocked(ctx, client, opts)
+}
+
+// tryDrain drains the spool, if it holds segments and isn't drained already.
+// It is called once sending succeeded, so segments written during an outage
+// are sent as soon as it is over. Errors are recorded to be reported later.
+func (s *spool) tryDrain(ctx context.Context, client *axiom.Client, opts *options) {
+	if s == nil || !s.drainMu.TryLock() {
+		return
+	}
+	defer s.drainMu.Unlock()
+
+	s.mu.Lock()
+	pending := s.pending
+	s.mu.Unlock()
+
+	if !pending {
+		return
+	}
+
+	_, err := s.drainLocked(ctx, client, opts)
+	if errors.Is(err, errLocked) {
+		// The spool is left to another process draining it.
+		return
+	}
+
+	s.mu.Lock()
+	s.drainErr = err
+	s.mu.Unlock()
+}
+
+// drainLocked drains the spool. The caller must hold the drain lock. It fails
+// with errLocked, if another process is draining the spool, as the segments
+// would be sent twice otherwise.
+func (s *spool) drainLocked(ctx context.Context, client *axiom.Client, opts *options) (*axiom.IngestStatus, error) {
+	lock, err := tryLockFile(filepath.Join(s.dir, spoolLockName))
+	if err != nil {
+		return nil, fmt.Errorf("could not drain spool %q: %w", s.dir, err)
+	}
+	defer lock.unlock()
+
+	// Segments written while draining are only sent by the next drain, so
+	// the spool is still pending, if any are.
+	s.mu.Lock()
+	seq := s.seq
+	segs, err := s.segments()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	res := new(axiom.IngestStatus)
+	for _, seg := range segs {
+		path := filepath.Join(s.dir, seg.Name)
+
+		header, data, err := readSegment(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// Evicted in the meantime.
+			continue
+		} else if err != nil {
+			return res, err
+		}
+
+		typ, err := contentTypeFromString(header.ContentType)
+		if err != nil {
+			return res, fmt.Errorf("invalid segment %q: %w", seg.Name, err)
+		}
+
+		ingestRes, err := ingestCompressedBatch(ctx, client, header.Dataset, data, typ, axiom.IngestOptions{
+			CSVDelimiter: header.CSVDelimiter,
+		}, opts)
+		if err != nil {
+			return res, fmt.Errorf("could not send segment %q to dataset %q: %w", seg.Name, header.Dataset, err)
+		}
+		mergeIngestStatuses(res, ingestRes)
+
+		if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return res, err
+		}
+
+		s.mu.Lock()
+		s.drained += header.Events
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	if s.seq == seq {
+		s.pending = false
+	}
+	s.mu.Unlock()
+
+	return res, nil
+}
+
+// printSummary writes the amount of events spooled, evicted and sent from the
+// spool to the standard error, if any. It returns true, if events were lost by
+// evicting them.
+func (s *spool) printSummary(io *terminal.IO) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	spooled, evicted, drained, drainErr := s.spooled, s.evicted, s.drained, s.drainErr
+	s.mu.Unlock()
+
+	cs := io.ColorScheme()
+	if drained > 0 && io.IsStderrTTY() {
+		fmt.Fprintf(io.ErrOut(), "%s Sent %s from the spool\n",
+			cs.SuccessIcon(), utils.Pluralize(cs, "event", drained))
+	}
+	if drainErr != nil {
+		fmt.Fprintf(io.ErrOut(), "%s Failed to send spooled events: %s\n",
+			cs.WarningIcon(), drainErr)
+	}
+	if spooled > 0 {
+		fmt.Fprintf(io.ErrOut(), "%s Spooled %s to %s, to be sent by the next run or by \"axiom ingest drain\"\n",
+			cs.WarningIcon(), utils.Pluralize(cs, "event", spooled), cs.Bold(s.dir))
+	}
+	if evicted > 0 {
+		fmt.Fprintf(io.ErrOut(), "%s Evicted %s from the spool, as it exceeded its maximum size of %s\n",
+			cs.ErrorIcon(), utils.Pluralize(cs, "event", evicted), humanize.IBytes(uint64(s.maxSize)))
+	}
+
+	return evicted > 0
+}
+
+// contentTypeName returns the name of the content type, as parsed by
+// contentTypeFromString.
+func contentTypeName(typ axiom.ContentType) string {
+	switch typ {
+	case axiom.JSON:
+		return "json"
+	case axiom.CSV:
+		return "csv"
+	default:
+		return "ndjson"
+	}
+}
+
+// spoolable returns true, if a batch that failed to be sent with the error
+// can be sent later: The error is transient or sending was canceled. Batches
+// rejected because of a permanent error would fail again and block the spool.
+func spoolable(err error) bool {
+	return isRetryable(err) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,171 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	opts := &options{Delimiter: ";"}
+	require.NoError(t, s.write("foo", []byte(`{"n":1}`+"\n"), axiom.NDJSON, 1, opts))
+	require.NoError(t, s.write("bar", []byte(`{"n":2}`+"\n"+`{"n":3}`+"\n"), axiom.NDJSON, 2, opts))
+	require.NoError(t, s.write("foo", []byte("n\n4\n"), axiom.CSV, 1, opts))
+
+	segs, err := s.segments()
+	require.NoError(t, err)
+	require.Len(t, segs, 3)
+	assert.Equal(t, "foo", segs[0].Dataset)
+	assert.Equal(t, "bar", segs[1].Dataset)
+	assert.Equal(t, 2, segs[1].Events)
+	assert.Equal(t, "csv", segs[2].ContentType)
+	assert.Equal(t, ";", segs[2].CSVDelimiter)
+
+	type request struct {
+		dataset, contentType, csvDelimiter, body string
+	}
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := zstd.NewReader(r.Body)
+		require.NoError(t, err)
+		defer zr.Close()
+
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+
+		requests = append(requests, request{
+			dataset:      path.Base(path.Dir(r.URL.Path)),
+			contentType:  r.Header.Get("Content-Type"),
+			csvDelimiter: r.URL.Query().Get("csv-delimiter"),
+			body:         string(body),
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(axiom.IngestStatus{Ingested: 1})
+	}))
+	defer srv.Close()
+
+	client, err := axiom.NewClient(
+		axiom.SetNoEnv(),
+		axiom.SetURL(srv.URL),
+		axiom.SetAccessToken("xaat-test"),
+		axiom.SetOrgID("test"),
+	)
+	require.NoError(t, err)
+
+	res, err := s.drain(context.Background(), client, &options{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, res.Ingested)
+	assert.Equal(t, 4, s.drained)
+
+	assert.Equal(t, []request{
+		{"foo", "application/x-ndjson", ";", `{"n":1}` + "\n"},
+		{"bar", "application/x-ndjson", ";", `{"n":2}` + "\n" + `{"n":3}` + "\n"},
+		{"foo", "text/csv", ";", "n\n4\n"},
+	}, requests)
+
+	segs, err = s.segments()
+	require.NoError(t, err)
+	assert.Empty(t, segs)
+}
+
+func TestSpool_Evict(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	opts := new(options)
+	require.NoError(t, s.write("foo", []byte(`{"n":1}`+"\n"), axiom.NDJSON, 1, opts))
+
+	segs, err := s.segments()
+	require.NoError(t, err)
+	require.Len(t, segs, 1)
+
+	// Room for two segments of the same size.
+	s.maxSize = segs[0].Size*2 + segs[0].Size/2
+
+	require.NoError(t, s.write("foo", []byte(`{"n":2}`+"\n"), axiom.NDJSON, 1, opts))
+	require.NoError(t, s.write("foo", []byte(`{"n":3}`+"\n"), axiom.NDJSON, 1, opts))
+
+	segs2, err := s.segments()
+	require.NoError(t, err)
+	require.Len(t, segs2, 2)
+	assert.NotEqual(t, segs[0].Name, segs2[0].Name)
+	assert.Equal(t, 1, s.evicted)
+	assert.Equal(t, 3, s.spooled)
+}
+
+func TestSpool_WriteWhileDraining(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	opts := new(options)
+	require.NoError(t, s.write("foo", []byte(`{"n":1}`+"\n"), axiom.NDJSON, 1, opts))
+
+	// Another batch fails to be sent while the spool is drained.
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests++; requests == 1 {
+			assert.NoError(t, s.write("foo", []byte(`{"n":2}`+"\n"), axiom.NDJSON, 1, opts))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(axiom.IngestStatus{Ingested: 1})
+	}))
+	defer srv.Close()
+
+	client, err := axiom.NewClient(
+		axiom.SetNoEnv(),
+		axiom.SetURL(srv.URL),
+		axiom.SetAccessToken("xaat-test"),
+		axiom.SetOrgID("test"),
+	)
+	require.NoError(t, err)
+
+	s.tryDrain(context.Background(), client, opts)
+	require.NoError(t, s.drainErr)
+	assert.Equal(t, 1, requests)
+	assert.True(t, s.pending)
+
+	// The segment written while draining is sent by the next drain.
+	s.tryDrain(context.Background(), client, opts)
+	require.NoError(t, s.drainErr)
+	assert.Equal(t, 2, requests)
+	assert.False(t, s.pending)
+}
+
+func TestSpool_Locked(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := openSpool(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.write("foo", []byte(`{"n":1}`+"\n"), axiom.NDJSON, 1, new(options)))
+
+	// Another process is draining the spool.
+	lock, err := tryLockFile(filepath.Join(dir, spoolLockName))
+	require.NoError(t, err)
+	defer lock.unlock()
+
+	_, err = s.drain(context.Background(), nil, new(options))
+	assert.ErrorIs(t, err, errLocked)
+
+	// Draining is left to the other process.
+	s.tryDrain(context.Background(), nil, new(options))
+	assert.NoError(t, s.drainErr)
+	assert.True(t, s.pending)
+
+	segs, err := s.segments()
+	require.NoError(t, err)
+	assert.Len(t, segs, 1)
+}
@@ -0,0 +1,425 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/spf13/cobra"
+
+	"github.com/axiomhq/cli/internal/cmd/auth"
+	"github.com/axiomhq/cli/internal/cmdutil"
+)
+
+const (
+	// maxSyslogMessageSize is the maximum size of a syslog message.
+	maxSyslogMessageSize = 64 * 1024
+	// syslogShutdownGrace is the duration TCP connections are read from once
+	// the server is shut down, so messages already sent are received.
+	syslogShutdownGrace = time.Second
+	// maxReceiveDelay is the maximum delay before receiving again, after
+	// receiving failed repeatedly.
+	maxReceiveDelay = time.Second
+)
+
+type syslogServerOptions struct {
+	*options
+
+	// Listen are the addresses to listen on for syslog messages, given as
+	// "udp://<address>" or "tcp://<address>".
+	Listen []string
+}
+
+func newSyslogServerCmd(f *cmdutil.Factory) *cobra.Command {
+	opts := &syslogServerOptions{
+		options: &options{
+			Factory: f,
+		},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "syslog-server [<dataset-name>] [--listen <network>://<address>,...] [--flush-every <duration>] [--max-retries <count>] [--retry-timeout <duration>] [--shutdown-timeout <duration>]",
+		Short: "Receive syslog messages and ingest them",
+		Long: heredoc.Doc(`
+			Receive syslog messages via UDP and TCP and ingest them into an Axiom
+			dataset, using the credentials of the configured deployment.
+
+			Messages as specified by RFC 5424 and BSD syslog messages as
+			described by RFC 3164 are parsed into events just like when
+			ingesting files with "-t=syslog-rfc5424" or "-t=syslog-rfc3164":
+			The facility, severity, hostname, appname and message end up in
+			fields of their own and the timestamp in the "_time" field.
+			Messages that can't be parsed are kept as they are in the "message"
+			field. The address of the sender is stored in the "remote_addr"
+			field.
+
+			Each UDP datagram holds a single message. TCP messages are either
+			terminated by a newline or prefixed by their length (octet counting),
+			as described by RFC 6587.
+
+			Events are sent in batches, just like "axiom ingest" does: They are
+			flushed every "--flush-every" interval or once they reach their
+			maximum size and failed batches are retried with exponential
+			backoff.
+
+			When the command is interrupted, the listeners are closed, messages
+			already sent over open TCP connections are read for another second
+			and pending events are flushed before it exits, for no longer than
+			"--shutdown-timeout". A second interrupt aborts it immediately.
+		`),
+
+		DisableFlagsInUseLine: true,
+
+		Args:              cmdutil.PopulateFromArgs(f, &opts.Dataset),
+		ValidArgsFunction: cmdutil.DatasetCompletionFunc(f),
+
+		Example: heredoc.Doc(`
+			# Receive syslog messages via UDP and TCP on port 5514 and ingest
+			# them into a dataset named "syslog":
+			$ axiom ingest syslog-server syslog --listen=udp://:5514,tcp://:5514
+		`),
+
+		PreRunE: cmdutil.ChainRunFuncs(
+			cmdutil.AsksForSetup(f, auth.NewLoginCmd(f)),
+			cmdutil.NeedsActiveDeployment(f),
+			cmdutil.NeedsDatasets(f),
+		),
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if len(opts.Listen) == 0 {
+				return cmdutil.NewFlagErrorf("--listen requires at least one address")
+			}
+			for _, s := range opts.Listen {
+				if _, _, err := parseListenAddress(s); err != nil {
+					return cmdutil.NewFlagErrorf("invalid --listen: %s", err)
+				}
+			}
+			if opts.MaxRetries < 0 {
+				return cmdutil.NewFlagErrorf("--max-retries must not be negative")
+			} else if opts.FlushEvery <= 0 {
+				return cmdutil.NewFlagErrorf("--flush-every must be positive")
+			}
+
+			if err := complete(cmd.Context(), opts.options); err != nil {
+				return err
+			}
+			return runSyslogServer(cmd.Context(), opts)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&opts.Listen, "listen", []string{"udp://:5514", "tcp://:5514"}, "Addresses to listen on for syslog messages, prefixed by udp:// or tcp://")
+	cmd.Flags().DurationVar(&opts.FlushEvery, "flush-every", time.Second, "Interval at which received events are flushed")
+	cmd.Flags().IntVar(&opts.MaxRetries, "max-retries", 5, "Maximum amount of retries of a batch that failed to be ingested because of a transient error")
+	cmd.Flags().DurationVar(&opts.RetryTimeout, "retry-timeout", time.Minute, "Maximum duration to spend on retrying a single batch (0 to only limit by --max-retries)")
+	cmd.Flags().DurationVar(&opts.ShutdownTimeout, "shutdown-timeout", defaultShutdownTimeout, "Maximum duration to spend on reading messages and flushing pending events when interrupted")
+
+	_ = cmd.RegisterFlagCompletionFunc("listen", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("flush-every", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("max-retries", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("retry-timeout", cmdutil.NoCompletion)
+	_ = cmd.RegisterFlagCompletionFunc("shutdown-timeout", cmdutil.NoCompletion)
+
+	return cmd
+}
+
+func runSyslogServer(ctx context.Context, opts *syslogServerOptions) error {
+	client, err := opts.Client(ctx)
+	if err != nil {
+		return err
+	}
+
+	return serveSink(ctx, client, opts.options, func(s *sink) (func(context.Context) error, error) {
+		srv := newSyslogServer(s)
+
+		addrs := make([]string, 0, len(opts.Listen))
+		for _, listen := range opts.Listen {
+			network, address, _ := parseListenAddress(listen)
+			addr, err := srv.listen(network, address)
+			if err != nil {
+				_ = srv.shutdown(context.Background())
+				return nil, err
+			}
+			addrs = append(addrs, network+"://"+addr.String())
+		}
+
+		if opts.IO.IsStderrTTY() {
+			cs := opts.IO.ColorScheme()
+			fmt.Fprintf(opts.IO.ErrOut(), "%s Listening on %s, ingesting into dataset %s\n",
+				cs.SuccessIcon(), cs.Bold(strings.Join(addrs, ", ")), cs.Bold(opts.Dataset))
+		}
+
+		return srv.shutdown, nil
+	})
+}
+
+// parseListenAddress splits an address given as "<network>://<address>" into
+// its network and address.
+func parseListenAddress(s string) (string, string, error) {
+	network, address, ok := strings.Cut(s, "://")
+	if !ok {
+		return "", "", fmt.Errorf("address %q must be prefixed by udp:// or tcp://", s)
+	} else if network != "udp" && network != "tcp" {
+		return "", "", fmt.Errorf("unsupported network %q of address %q: must be udp or tcp", network, s)
+	}
+	return network, address, nil
+}
+
+// syslogServer receives syslog messages and adds them to a sink.
+type syslogServer struct {
+	sink *sink
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	closers []io.Closer
+	conns   map[net.Conn]struct{}
+	closing bool
+}
+
+func newSyslogServer(s *sink) *syslogServer {
+	return &syslogServer{
+		sink:  s,
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// listen starts receiving messages on the address of the network, which is
+// either "udp" or "tcp", and returns the address listened on.
+func (srv *syslogServer) listen(network, address string) (net.Addr, error) {
+	switch network {
+	case "udp":
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return nil, err
+		}
+		srv.track(conn)
+
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			srv.serveUDP(conn)
+		}()
+		return conn.LocalAddr(), nil
+	case "tcp":
+		ln, err := net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+		srv.track(ln)
+
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			srv.serveTCP(ln)
+		}()
+		return ln.Addr(), nil
+	}
+	return nil, fmt.Errorf("unsupported network %q", network)
+}
+
+func (srv *syslogServer) track(c io.Closer) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.closers = append(srv.closers, c)
+}
+
+func (srv *syslogServer) serveUDP(conn net.PacketConn) {
+	var (
+		buf   = make([]byte, maxSyslogMessageSize)
+		delay time.Duration
+	)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if n > 0 {
+			srv.receive(string(buf[:n]), addr)
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			delay = receiveDelay(delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+	}
+}
+
+func (srv *syslogServer) serveTCP(ln net.Listener) {
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			delay = receiveDelay(delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		srv.mu.Lock()
+		srv.conns[conn] = struct{}{}
+		if srv.closing {
+			_ = conn.SetReadDeadline(time.Now().Add(syslogShutdownGrace))
+		}
+		srv.mu.Unlock()
+
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			defer func() {
+				srv.mu.Lock()
+				delete(srv.conns, conn)
+				srv.mu.Unlock()
+				_ = conn.Close()
+			}()
+
+			br := bufio.NewReader(conn)
+			for {
+				msg, err := readSyslogFrame(br)
+				if msg != "" {
+					srv.receive(msg, conn.RemoteAddr())
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// receiveDelay returns the delay before receiving again, after receiving failed
+// following the given delay. It doubles with every failure, so persistent
+// errors like running out of file descriptors don't make the server spin.
+func receiveDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return time.Millisecond * 5
+	}
+	if delay *= 2; delay > maxReceiveDelay {
+		delay = maxReceiveDelay
+	}
+	return delay
+}
+
+// receive parses the message and adds it to the sink.
+func (srv *syslogServer) receive(msg string, addr net.Addr) {
+	msg = strings.TrimRight(msg, "\r\n\x00")
+	if msg == "" {
+		return
+	}
+
+	event := parseSyslogMessage(msg, time.Now())
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		event["remote_addr"] = host
+	}
+
+	// Events are only rejected by processors, which aren't configured.
+	_ = srv.sink.add(event)
+}
+
+// shutdown closes the listeners and reads what has been sent over open TCP
+// connections for no longer than the shutdown grace period, so clients keeping
+// their connection open don't hold it up. If the context is done before, the
+// remaining connections are closed right away.
+func (srv *syslogServer) shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.closing = true
+	for _, c := range srv.closers {
+		_ = c.Close()
+	}
+	deadline := time.Now().Add(syslogShutdownGrace)
+	for conn := range srv.conns {
+		_ = conn.SetReadDeadline(deadline)
+	}
+	srv.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		srv.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	srv.mu.Lock()
+	for conn := range srv.conns {
+		_ = conn.Close()
+	}
+	srv.mu.Unlock()
+
+	<-done
+
+	return ctx.Err()
+}
+
+// readSyslogFrame reads a syslog message sent over TCP. Messages are either
+// prefixed by their length (octet counting) or terminated by a newline
+// (non-transparent framing), as described by RFC 6587.
+func readSyslogFrame(br *bufio.Reader) (string, error) {
+	b, err := br.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	if b[0] < '1' || b[0] > '9' {
+		line, err := readUntil(br, '\n', maxSyslogMessageSize)
+		if errors.Is(err, errFrameTooLarge) {
+			return "", fmt.Errorf("message exceeds %d bytes", maxSyslogMessageSize)
+		}
+		return line, err
+	}
+
+	// The length has no more digits than the maximum message size.
+	s, err := readUntil(br, ' ', len(strconv.Itoa(maxSyslogMessageSize))+1)
+	if errors.Is(err, errFrameTooLarge) {
+		return "", fmt.Errorf("invalid message length %q", s)
+	} else if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(s, " "))
+	if err != nil {
+		return "", fmt.Errorf("invalid message length %q", s)
+	} else if n > maxSyslogMessageSize {
+		return "", fmt.Errorf("message length %d exceeds %d bytes", n, maxSyslogMessageSize)
+	}
+
+	msg := make([]byte, n)
+	if _, err = io.ReadFull(br, msg); err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+// parseSyslogMessage parses a message as specified by RFC 5424 or, if it isn't
+// one, as described by RFC 3164. Messages that can't be parsed are kept as
+// they are in the message field.
+func parseSyslogMessage(msg string, now time.Time) map[string]any {
+	if event, err := parseSyslogRFC5424(msg); err == nil {
+		return event
+	} else if event, err = parseSyslogRFC3164(msg, now); err == nil {
+		return event
+	}
+
+	// Still make use of the priority, if present.
+	if strings.HasPrefix(msg, "<") {
+		if i := strings.IndexByte(msg, '>'); i > 1 {
+			if event, err := syslogPriority(msg[1:i]); err == nil {
+				event[messageField] = msg[i+1:]
+				return event
+			}
+		}
+	}
+	return map[string]any{messageField: msg}
+}
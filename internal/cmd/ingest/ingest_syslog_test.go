@@ -0,0 +1,143 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSyslogFrame(t *testing.T) {
+	br := bufio.NewReader(strings.NewReader("<13>Jan  2 15:04:05 host app: one\n17 <13>1 - - - - - -10 line\nbreak<13>last"))
+
+	var msgs []string
+	for {
+		msg, err := readSyslogFrame(br)
+		if msg != "" {
+			msgs = append(msgs, msg)
+		}
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{
+		"<13>Jan  2 15:04:05 host app: one\n",
+		"<13>1 - - - - - -",
+		"line\nbreak",
+		"<13>last",
+	}, msgs)
+
+	_, err := readSyslogFrame(bufio.NewReader(strings.NewReader("99999 x")))
+	assert.EqualError(t, err, "message length 99999 exceeds 65536 bytes")
+
+	// Frames are not read beyond the maximum message size.
+	_, err = readSyslogFrame(bufio.NewReader(strings.NewReader("99999999 x")))
+	assert.EqualError(t, err, `invalid message length "999999"`)
+
+	_, err = readSyslogFrame(bufio.NewReader(strings.NewReader("<13>" + strings.Repeat("x", maxSyslogMessageSize) + "\n")))
+	assert.EqualError(t, err, "message exceeds 65536 bytes")
+}
+
+func TestParseSyslogMessage(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	event := parseSyslogMessage("<34>1 2022-01-02T15:04:05Z host app 42 ID47 - hello", now)
+	assert.Equal(t, "auth", event["facility"])
+	assert.Equal(t, "crit", event["severity"])
+	assert.Equal(t, "host", event["hostname"])
+	assert.Equal(t, "app", event["appname"])
+	assert.Equal(t, "hello", event[messageField])
+
+	event = parseSyslogMessage("<13>Jan  2 15:04:05 host app[7]: hello", now)
+	assert.Equal(t, "user", event["facility"])
+	assert.Equal(t, "notice", event["severity"])
+	assert.Equal(t, "2022-01-02T15:04:05Z", event[defaultTimestampField])
+
+	event = parseSyslogMessage("<13>no header", now)
+	assert.Equal(t, map[string]any{"priority": 13, "facility": "user", "severity": "notice", messageField: "no header"}, event)
+}
+
+func TestSyslogServer(t *testing.T) {
+	s := newSink(context.Background(), nil, &options{Dataset: "test"})
+	srv := newSyslogServer(s)
+
+	udpAddr, err := srv.listen("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	tcpAddr, err := srv.listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	udpConn, err := net.Dial("udp", udpAddr.String())
+	require.NoError(t, err)
+	_, err = udpConn.Write([]byte("<13>1 - host udp - - - via udp\n"))
+	require.NoError(t, err)
+	require.NoError(t, udpConn.Close())
+
+	assert.Eventually(t, func() bool { return s.status().Received == 1 }, time.Second, time.Millisecond*10)
+
+	tcpConn, err := net.Dial("tcp", tcpAddr.String())
+	require.NoError(t, err)
+	_, err = tcpConn.Write([]byte("26 <13>1 - host tcp - - - one<13>1 - host tcp - - - two\n"))
+	require.NoError(t, err)
+	require.NoError(t, tcpConn.Close())
+
+	// Messages being received over TCP are read to their end on shutdown.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, srv.shutdown(ctx))
+
+	assert.EqualValues(t, 3, s.status().Received)
+
+	lines := strings.Split(strings.TrimSpace(s.bufs.get("test").String()), "\n")
+	if assert.Len(t, lines, 3) {
+		assert.Contains(t, lines[0], `"message":"via udp"`)
+		assert.Contains(t, lines[0], `"remote_addr":"127.0.0.1"`)
+		assert.Contains(t, lines[1], `"message":"one"`)
+		assert.Contains(t, lines[2], `"message":"two"`)
+	}
+}
+
+func TestSyslogServer_OpenConnection(t *testing.T) {
+	s := newSink(context.Background(), nil, &options{Dataset: "test"})
+	srv := newSyslogServer(s)
+
+	tcpAddr, err := srv.listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	tcpConn, err := net.Dial("tcp", tcpAddr.String())
+	require.NoError(t, err)
+	defer tcpConn.Close()
+
+	_, err = tcpConn.Write([]byte("<13>1 - host tcp - - - one\n"))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return s.status().Received == 1 }, time.Second, time.Millisecond*10)
+
+	// Messages sent right before shutting down are still received.
+	_, err = tcpConn.Write([]byte("<13>1 - host tcp - - - two\n"))
+	require.NoError(t, err)
+
+	// Shutting down doesn't wait for the client to close the connection, so
+	// the context isn't done before pending events are flushed.
+	ctx, cancel := context.WithTimeout(context.Background(), syslogShutdownGrace*5)
+	defer cancel()
+
+	start := time.Now()
+	require.NoError(t, srv.shutdown(ctx))
+	assert.Less(t, time.Since(start), syslogShutdownGrace*2)
+	assert.NoError(t, ctx.Err())
+
+	assert.EqualValues(t, 2, s.status().Received)
+
+	// The connection has been closed by the server.
+	_ = tcpConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = tcpConn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
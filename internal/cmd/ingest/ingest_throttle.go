@@ -0,0 +1,62 @@
+package ingest
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// throttle limits the rate at which events and bytes are ingested. Both are
+// token buckets holding up to one second worth of tokens, so batches are
+// spread evenly over time instead of being sent in bursts.
+type throttle struct {
+	events *rate.Limiter
+	bytes  *rate.Limiter
+}
+
+// newThrottle returns the throttle for the configured limits or nil, if
+// ingestion is not to be throttled.
+func newThrottle(opts *options) *throttle {
+	if opts.MaxEventsPerSecond <= 0 && opts.MaxBytesPerSecond == 0 {
+		return nil
+	}
+
+	var t throttle
+	if n := opts.MaxEventsPerSecond; n > 0 {
+		t.events = rate.NewLimiter(rate.Limit(n), n)
+	}
+	if n := int(opts.MaxBytesPerSecond); n > 0 {
+		t.bytes = rate.NewLimiter(rate.Limit(n), n)
+	}
+	return &t
+}
+
+// wait blocks until a batch holding the given amount of events and bytes may
+// be sent or the context is canceled. A nil throttle never blocks.
+func (t *throttle) wait(ctx context.Context, events, bytes int) error {
+	if t == nil {
+		return nil
+	} else if err := waitN(ctx, t.events, events); err != nil {
+		return err
+	}
+	return waitN(ctx, t.bytes, bytes)
+}
+
+// waitN takes n tokens from the limiter, if not nil. Amounts exceeding the
+// capacity of the limiter are taken in multiple steps.
+func waitN(ctx context.Context, l *rate.Limiter, n int) error {
+	if l == nil {
+		return nil
+	}
+	for n > 0 {
+		m := n
+		if burst := l.Burst(); m > burst {
+			m = burst
+		}
+		if err := l.WaitN(ctx, m); err != nil {
+			return err
+		}
+		n -= m
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestNewThrottle(t *testing.T) {
+	assert.Nil(t, newThrottle(&options{}))
+
+	th := newThrottle(&options{MaxEventsPerSecond: 10})
+	require.NotNil(t, th)
+	assert.EqualValues(t, 10, th.events.Limit())
+	assert.Nil(t, th.bytes)
+
+	th = newThrottle(&options{MaxBytesPerSecond: 1024})
+	require.NotNil(t, th)
+	assert.Nil(t, th.events)
+	assert.Equal(t, 1024, th.bytes.Burst())
+}
+
+func TestThrottle_Nil(t *testing.T) {
+	var th *throttle
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.NoError(t, th.wait(ctx, 1000, 1<<20))
+}
+
+func TestWaitN_ExceedsBurst(t *testing.T) {
+	l := rate.NewLimiter(1000, 10)
+
+	// The limiter refuses to take more tokens than its burst at once.
+	require.Error(t, l.WaitN(context.Background(), 50))
+
+	start := time.Now()
+	require.NoError(t, waitN(context.Background(), l, 50))
+
+	// The first 10 tokens are available right away, the remaining 40 are
+	// refilled at 1000 per second.
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+	assert.NoError(t, waitN(context.Background(), nil, 50))
+}
+
+func TestThrottle_Canceled(t *testing.T) {
+	th := newThrottle(&options{MaxEventsPerSecond: 1, MaxBytesPerSecond: 1})
+	require.NoError(t, th.wait(context.Background(), 1, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := th.wait(ctx, 1, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Waiting longer than the context allows fails right away.
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.Error(t, th.wait(ctx, 5, 0))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
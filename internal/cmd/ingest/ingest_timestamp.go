@@ -0,0 +1,142 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/araddon/dateparse"
+
+	"github.com/axiomhq/cli/pkg/terminal"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+// timestamps parses the timestamps of events on the client side and writes
+// them to the "_time" field in RFC 3339 format, so the server doesn't have to
+// guess their format or time zone. It is safe for concurrent use.
+type timestamps struct {
+	// fields are the fields the timestamp is taken from. The first one an
+	// event holds is used.
+	fields []string
+	// formats are the layouts string timestamps are parsed with, tried in
+	// order. If not set, a heuristic parser is used.
+	formats []string
+	// loc is the time zone of timestamps which don't specify one.
+	loc *time.Location
+
+	mu         sync.Mutex
+	unparsable int
+	// example is the first timestamp which couldn't be parsed.
+	example any
+}
+
+// newTimestamps creates the timestamp parser configured by the options. It
+// returns nil, if neither timestamp fields, formats nor a time zone are
+// configured and timestamps are left to the server.
+func newTimestamps(opts *options) (*timestamps, error) {
+	if len(opts.TimestampFields) == 0 && len(opts.TimestampFormats) == 0 && opts.TimestampTimezone == "" {
+		return nil, nil
+	}
+
+	ts := &timestamps{
+		fields:  opts.TimestampFields,
+		formats: opts.TimestampFormats,
+		loc:     time.UTC,
+	}
+	if len(ts.fields) == 0 {
+		ts.fields = []string{defaultTimestampField}
+	}
+	for _, field := range ts.fields {
+		if field == "" {
+			return nil, fmt.Errorf("invalid --timestamp-field: must not be empty")
+		}
+	}
+	for _, format := range ts.formats {
+		if format == "" {
+			return nil, fmt.Errorf("invalid --timestamp-format: must not be empty")
+		}
+	}
+
+	if opts.TimestampTimezone != "" {
+		var err error
+		if ts.loc, err = time.LoadLocation(opts.TimestampTimezone); err != nil {
+			return nil, fmt.Errorf("invalid --timestamp-timezone %q: %w", opts.TimestampTimezone, err)
+		}
+	}
+
+	return ts, nil
+}
+
+// apply writes the timestamp of the event to the "_time" field. Events without
+// a timestamp are passed on as they are, as are the ones whose timestamp can't
+// be parsed, which are counted.
+func (ts *timestamps) apply(event map[string]any) (map[string]any, error) {
+	for _, field := range ts.fields {
+		m, k, ok := lookupField(event, field)
+		if !ok || m[k] == nil {
+			continue
+		}
+
+		if t, ok := ts.parse(m[k]); ok {
+			event[defaultTimestampField] = t.UTC().Format(time.RFC3339Nano)
+		} else {
+			ts.mu.Lock()
+			if ts.unparsable == 0 {
+				ts.example = m[k]
+			}
+			ts.unparsable++
+			ts.mu.Unlock()
+		}
+		break
+	}
+	return event, nil
+}
+
+// parse parses the timestamp value. Numbers, also when given as strings
+// without configured formats, are Unix timestamps whose unit is detected by
+// their magnitude. Strings are parsed using the configured formats, the first
+// one matching wins, or the heuristic parser. Timestamps without a time zone
+// are in the configured one.
+func (ts *timestamps) parse(v any) (time.Time, bool) {
+	switch v := v.(type) {
+	case json.Number, int64, float64:
+		return parseTimestamp(v)
+	case string:
+		if len(ts.formats) == 0 {
+			if _, err := strconv.ParseFloat(v, 64); err == nil {
+				return parseTimestamp(v)
+			}
+			t, err := dateparse.ParseIn(v, ts.loc)
+			return t, err == nil
+		}
+		for _, format := range ts.formats {
+			if t, err := time.ParseInLocation(format, v, ts.loc); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// printSummary writes the amount of events whose timestamp couldn't be parsed
+// to the standard error, if any. It is always printed, as the time of those
+// events is most likely wrong.
+func (ts *timestamps) printSummary(io *terminal.IO) {
+	if ts == nil {
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.unparsable > 0 {
+		cs := io.ColorScheme()
+		fmt.Fprintf(io.ErrOut(), "%s Could not parse the timestamp of %s, e.g. %s\n",
+			cs.WarningIcon(),
+			utils.Pluralize(cs, "event", ts.unparsable),
+			cs.Bold(fmt.Sprintf("%q", fmt.Sprint(ts.example))),
+		)
+	}
+}
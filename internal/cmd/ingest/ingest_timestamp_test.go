@@ -0,0 +1,72 @@
+package ingest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestamps(t *testing.T) {
+	ts, err := newTimestamps(&options{
+		TimestampFields:   []string{"ts", "meta.time"},
+		TimestampFormats:  []string{"2006-01-02 15:04:05", "02.01.2006 15:04"},
+		TimestampTimezone: "Europe/Berlin",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, ts)
+
+	tests := []struct {
+		name  string
+		event map[string]any
+		want  any
+	}{
+		{"first format", map[string]any{"ts": "2022-07-01 12:00:00"}, "2022-07-01T10:00:00Z"},
+		{"second format", map[string]any{"ts": "01.01.2022 12:00"}, "2022-01-01T11:00:00Z"},
+		{"fallback field", map[string]any{"meta": map[string]any{"time": "2022-01-01 12:00:00"}}, "2022-01-01T11:00:00Z"},
+		{"zone given", map[string]any{"ts": "2022-01-01 12:00:00 +0000", "_time": "x"}, "x"},
+		{"seconds", map[string]any{"ts": json.Number("1656676800")}, "2022-07-01T12:00:00Z"},
+		{"milliseconds", map[string]any{"ts": int64(1656676800123)}, "2022-07-01T12:00:00.123Z"},
+		{"nanoseconds", map[string]any{"ts": float64(1656676800000000000)}, "2022-07-01T12:00:00Z"},
+		{"exact nanoseconds", map[string]any{"ts": json.Number("1656676800123456789")}, "2022-07-01T12:00:00.123456789Z"},
+		{"fractional seconds", map[string]any{"ts": json.Number("1656676800.5")}, "2022-07-01T12:00:00.5Z"},
+		{"missing", map[string]any{"foo": "bar"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := ts.apply(tt.event)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, event[defaultTimestampField])
+		})
+	}
+
+	// Only the timestamp given with a zone didn't match any format.
+	assert.Equal(t, 1, ts.unparsable)
+	assert.Equal(t, "2022-01-01 12:00:00 +0000", ts.example)
+}
+
+func TestTimestamps_Heuristic(t *testing.T) {
+	ts, err := newTimestamps(&options{TimestampTimezone: "America/New_York"})
+	require.NoError(t, err)
+
+	event, err := ts.apply(map[string]any{"_time": "2022-01-01 12:00:00"})
+	require.NoError(t, err)
+	assert.Equal(t, "2022-01-01T17:00:00Z", event[defaultTimestampField])
+
+	event, err = ts.apply(map[string]any{"_time": "1656676800123456"})
+	require.NoError(t, err)
+	assert.Equal(t, "2022-07-01T12:00:00.123456Z", event[defaultTimestampField])
+}
+
+func TestNewTimestamps_Invalid(t *testing.T) {
+	ts, err := newTimestamps(&options{})
+	require.NoError(t, err)
+	assert.Nil(t, ts)
+
+	_, err = newTimestamps(&options{TimestampTimezone: "Mars/Olympus"})
+	assert.ErrorContains(t, err, `invalid --timestamp-timezone "Mars/Olympus"`)
+
+	_, err = newTimestamps(&options{TimestampFields: []string{""}})
+	assert.EqualError(t, err, "invalid --timestamp-field: must not be empty")
+}
@@ -0,0 +1,279 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/cli/pkg/terminal"
+	"github.com/axiomhq/cli/pkg/utils"
+)
+
+// eventFunc processes an event before it is ingested. It returns the processed
+// event or nil, if the event is to be dropped.
+type eventFunc func(event map[string]any) (map[string]any, error)
+
+// validCasts are the types a field can be cast to.
+var validCasts = []string{"int", "float", "bool", "time"}
+
+// transform modifies the fields of events.
+type transform struct {
+	set     [][2]string
+	drop    []string
+	rename  [][2]string
+	cast    [][2]string
+	flatten bool
+}
+
+// newTransform creates the transform configured by the options. It returns
+// nil, if no transformation is configured.
+func newTransform(opts *options) (*transform, error) {
+	t := &transform{
+		drop:    opts.Drop,
+		flatten: opts.Flatten,
+	}
+
+	var err error
+	if t.set, err = splitAssignments("--set", opts.Set); err != nil {
+		return nil, err
+	} else if t.rename, err = splitAssignments("--rename", opts.Rename); err != nil {
+		return nil, err
+	} else if t.cast, err = splitAssignments("--cast", opts.Cast); err != nil {
+		return nil, err
+	}
+
+	for _, c := range t.cast {
+		if !contains(validCasts, c[1]) {
+			return nil, fmt.Errorf("invalid --cast %q: type must be one of %s", c[0]+"="+c[1], strings.Join(validCasts, ", "))
+		}
+	}
+
+	if len(t.set) == 0 && len(t.drop) == 0 && len(t.rename) == 0 && len(t.cast) == 0 && !t.flatten {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// splitAssignments splits the given "key=value" assignments.
+func splitAssignments(flag string, ss []string) ([][2]string, error) {
+	res := make([][2]string, 0, len(ss))
+	for _, s := range ss {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid %s %q: must be of the form key=value", flag, s)
+		}
+		res = append(res, [2]string{k, v})
+	}
+	return res, nil
+}
+
+// apply transforms the event. Fields are renamed, set, cast and dropped in
+// that order before the event is flattened.
+func (t *transform) apply(event map[string]any) (map[string]any, error) {
+	for _, r := range t.rename {
+		if m, k, ok := lookupField(event, r[0]); ok {
+			v := m[k]
+			delete(m, k)
+			setField(event, r[1], v)
+		}
+	}
+
+	for _, s := range t.set {
+		setField(event, s[0], s[1])
+	}
+
+	for _, c := range t.cast {
+		if m, k, ok := lookupField(event, c[0]); ok {
+			m[k] = castValue(m[k], c[1])
+		}
+	}
+
+	for _, name := range t.drop {
+		if m, k, ok := lookupField(event, name); ok {
+			delete(m, k)
+		}
+	}
+
+	if t.flatten {
+		flat := make(map[string]any, len(event))
+		flattenEvent("", event, flat)
+		event = flat
+	}
+
+	return event, nil
+}
+
+// lookupField returns the object holding the field with the given name and
+// the key of the field within it. Nested fields are addressed by joining their
+// names with a dot.
+func lookupField(event map[string]any, name string) (map[string]any, string, bool) {
+	if _, ok := event[name]; ok {
+		return event, name, true
+	}
+
+	for i := strings.IndexByte(name, '.'); i > 0; i = nextDot(name, i) {
+		if m, ok := event[name[:i]].(map[string]any); ok {
+			if m, k, ok := lookupField(m, name[i+1:]); ok {
+				return m, k, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+func nextDot(s string, i int) int {
+	if j := strings.IndexByte(s[i+1:], '.'); j >= 0 {
+		return i + 1 + j
+	}
+	return -1
+}
+
+// setField sets the field with the given name, which can address a nested
+// field by joining the names with a dot. Missing objects are created.
+func setField(event map[string]any, name string, v any) {
+	if m, k, ok := lookupField(event, name); ok {
+		m[k] = v
+		return
+	}
+
+	head, tail, ok := strings.Cut(name, ".")
+	if !ok {
+		event[name] = v
+		return
+	}
+
+	switch m := event[head].(type) {
+	case map[string]any:
+		setField(m, tail, v)
+	case nil:
+		nested := make(map[string]any)
+		event[head] = nested
+		setField(nested, tail, v)
+	default:
+		event[name] = v
+	}
+}
+
+// castValue converts the value to the given type. Values which can't be
+// converted are returned as they are.
+func castValue(v any, typ string) any {
+	s := fmt.Sprint(v)
+	switch typ {
+	case "int":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return int64(f)
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case "bool":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case "time":
+		if ts, ok := parseTimestamp(v); ok {
+			return ts.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return v
+}
+
+// flattenEvent copies the fields of the event to the flat object. Nested
+// fields are named by joining their names with a dot.
+func flattenEvent(prefix string, event, flat map[string]any) {
+	for k, v := range event {
+		if m, ok := v.(map[string]any); ok && len(m) > 0 {
+			flattenEvent(prefix+k+".", m, flat)
+			continue
+		}
+		flat[prefix+k] = v
+	}
+}
+
+// decodeEvent decodes a line of newline delimited JSON into an event. Numbers
+// are kept as they are.
+func decodeEvent(line string) (map[string]any, error) {
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+
+	var event map[string]any
+	if err := dec.Decode(&event); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return event, nil
+}
+
+// invalidLines counts the lines which are skipped, as they are not valid JSON
+// objects and can't be decoded to be processed. It is safe for concurrent use.
+type invalidLines struct {
+	mu sync.Mutex
+	n  int
+	// example is the error of the first invalid line.
+	example error
+}
+
+func (il *invalidLines) add(err error) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	if il.n == 0 {
+		il.example = err
+	}
+	il.n++
+}
+
+// printSummary writes the amount of skipped lines to the standard error, if
+// any.
+func (il *invalidLines) printSummary(io *terminal.IO) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	if il.n == 0 {
+		return
+	}
+
+	cs := io.ColorScheme()
+	fmt.Fprintf(io.ErrOut(), "%s Skipped %s which are not valid JSON, e.g. %s\n",
+		cs.WarningIcon(),
+		utils.Pluralize(cs, "line", il.n),
+		cs.Bold(il.example.Error()),
+	)
+}
+
+// processEvent runs the event through the given functions. It returns nil, if
+// the event is dropped.
+func processEvent(event map[string]any, fns []eventFunc) (map[string]any, error) {
+	for _, fn := range fns {
+		var err error
+		if event, err = fn(event); err != nil || event == nil {
+			return nil, err
+		}
+	}
+	return event, nil
+}
+
+// appendEvent appends the event to the buffer as newline delimited JSON.
+func appendEvent(buf *bytes.Buffer, event map[string]any) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, _ = buf.Write(b)
+	return buf.WriteByte('\n')
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package ingest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransform(t *testing.T) {
+	tr, err := newTransform(&options{
+		Set:    []string{"env=prod", "meta.region=eu"},
+		Drop:   []string{"request.headers"},
+		Rename: []string{"msg=message"},
+		Cast:   []string{"status=int", "ok=bool", "ts=time"},
+	})
+	require.NoError(t, err)
+
+	event, err := decodeEvent(`{"msg":"hi","status":"200","ok":"true","ts":1640995200,"request":{"path":"/","headers":{"a":"b"}}}`)
+	require.NoError(t, err)
+
+	event, err = tr.apply(event)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]any{
+		"message": "hi",
+		"env":     "prod",
+		"meta":    map[string]any{"region": "eu"},
+		"status":  int64(200),
+		"ok":      true,
+		"ts":      "2022-01-01T00:00:00Z",
+		"request": map[string]any{"path": "/"},
+	}, event)
+
+	tr.flatten = true
+	event, err = tr.apply(event)
+	require.NoError(t, err)
+
+	assert.Equal(t, "eu", event["meta.region"])
+	assert.Equal(t, "/", event["request.path"])
+	assert.NotContains(t, event, "meta")
+}
+
+func TestNewTransform_Invalid(t *testing.T) {
+	_, err := newTransform(&options{Set: []string{"env"}})
+	assert.EqualError(t, err, `invalid --set "env": must be of the form key=value`)
+
+	_, err = newTransform(&options{Cast: []string{"a=string"}})
+	assert.EqualError(t, err, `invalid --cast "a=string": type must be one of int, float, bool, time`)
+
+	tr, err := newTransform(&options{})
+	require.NoError(t, err)
+	assert.Nil(t, tr)
+}
+
+func TestIngestEvery_InvalidLines(t *testing.T) {
+	tr, err := newTransform(&options{Set: []string{"env=prod"}})
+	require.NoError(t, err)
+
+	opts := &options{
+		Dataset:    "test",
+		FlushEvery: time.Second,
+		processors: []eventFunc{tr.apply},
+		report:     newDryRunReport(nil),
+	}
+	_, err = ingestEvery(context.Background(), nil, &source{
+		name: "app.ndjson",
+		r:    strings.NewReader(`{"a":1}` + "\n" + `{"a":` + "\n" + `[1]` + "\n" + `{"a":2}` + "\n"),
+		typ:  axiom.NDJSON,
+	}, opts)
+	require.NoError(t, err)
+
+	// Invalid lines are skipped and don't stop ingestion.
+	assert.Equal(t, 2, opts.report.events)
+	assert.Equal(t, 2, opts.invalid.n)
+	assert.EqualError(t, opts.invalid.example, `line 2 of "app.ndjson": invalid JSON: unexpected EOF`)
+}
+
+func TestIngestEvery_CastCSV(t *testing.T) {
+	tr, err := newTransform(&options{Cast: []string{"ts=time"}})
+	require.NoError(t, err)
+
+	var events []map[string]any
+	opts := &options{
+		Dataset:    "test",
+		FlushEvery: time.Second,
+		processors: []eventFunc{tr.apply, func(event map[string]any) (map[string]any, error) {
+			events = append(events, event)
+			return event, nil
+		}},
+		report: newDryRunReport(nil),
+	}
+	_, err = ingestEvery(context.Background(), nil, &source{
+		name: "app.csv",
+		r:    strings.NewReader("ts,msg\n1656676800,a\n1656676800123,b\n"),
+		typ:  axiom.CSV,
+	}, opts)
+	require.NoError(t, err)
+
+	// Epochs inferred to be integers are cast, too.
+	require.Len(t, events, 2)
+	assert.Equal(t, "2022-07-01T12:00:00Z", events[0]["ts"])
+	assert.Equal(t, "2022-07-01T12:00:00.123Z", events[1]["ts"])
+}
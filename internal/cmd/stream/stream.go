@@ -40,7 +40,12 @@ func NewCmd(f *cmdutil.Factory) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "stream [<dataset-name>] [(-f|--format)=json|table]",
 		Short: "Livestream data",
-		Long:  `Livestream data from an Axiom dataset.`,
+		Long: heredoc.Doc(`
+			Livestream data from an Axiom dataset.
+
+			When interrupted, the events of the query in flight are printed
+			before the command exits. A second interrupt aborts it immediately.
+		`),
 
 		DisableFlagsInUseLine: true,
 
@@ -149,9 +154,13 @@ func run(ctx context.Context, opts *options) error {
 	t := time.NewTicker(streamingDuration)
 	defer t.Stop()
 
+	// Once interrupted, the query in flight is finished and its events are
+	// printed. It is only canceled, if the command is aborted.
+	abortCtx := cmdutil.AbortContext(ctx)
+
 	lastRequest := time.Now().Add(-time.Nanosecond)
 	for {
-		queryCtx, queryCancel := context.WithTimeout(ctx, streamingDuration)
+		queryCtx, queryCancel := context.WithTimeout(abortCtx, streamingDuration)
 
 		res, err := client.Datasets.Query(queryCtx, opts.Dataset, query.Query{
 			StartTime: lastRequest,
@@ -40,3 +40,14 @@ func (e FlagError) Error() string {
 func (e FlagError) Unwrap() error {
 	return e.err
 }
+
+// ExitError is an error that triggers the given exit code without any error
+// message, e.g. to pass on the exit code of a child process.
+type ExitError struct {
+	Code int
+}
+
+// Error implements the error interface.
+func (e ExitError) Error() string {
+	return fmt.Sprintf("exit code %d", e.Code)
+}
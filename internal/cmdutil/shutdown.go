@@ -0,0 +1,91 @@
+package cmdutil
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+type abortContextKey struct{}
+
+// NotifyShutdown returns a copy of the parent context which is canceled once
+// one of the given signals is received, asking the command to shut down
+// gracefully: Stop what it is doing, but finish the work already started, like
+// sending buffered data. The second signal aborts the command by canceling the
+// context returned by AbortContext. Any further signal gets the default
+// behaviour, usually terminating the process.
+func NotifyShutdown(parent context.Context, signals ...os.Signal) (context.Context, context.CancelFunc) {
+	abortCtx, abort := context.WithCancel(parent)
+	ctx, cancel := context.WithCancel(context.WithValue(abortCtx, abortContextKey{}, abortCtx))
+
+	var (
+		sigs = make(chan os.Signal, 1)
+		done = make(chan struct{})
+	)
+	signal.Notify(sigs, signals...)
+
+	go func() {
+		defer signal.Stop(sigs)
+
+		select {
+		case <-done:
+			return
+		case <-sigs:
+			cancel()
+		}
+
+		select {
+		case <-done:
+		case <-sigs:
+			abort()
+		}
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			close(done)
+			cancel()
+			abort()
+		})
+	}
+}
+
+// AbortContext returns the context which is canceled once the command is to
+// abort immediately. Work that must be finished when shutting down gracefully
+// uses it instead of the given context. If the given context is not derived
+// from one returned by NotifyShutdown, it is returned as is.
+func AbortContext(ctx context.Context) context.Context {
+	if abortCtx, ok := ctx.Value(abortContextKey{}).(context.Context); ok {
+		return abortCtx
+	}
+	return ctx
+}
+
+// ShutdownContext returns a context for finishing the work already started
+// once the given context is canceled. It is canceled once the command is
+// aborted or the timeout has passed since the given context was canceled.
+func ShutdownContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	shutdownCtx, cancel := context.WithCancel(AbortContext(ctx))
+
+	go func() {
+		select {
+		case <-shutdownCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+
+		select {
+		case <-shutdownCtx.Done():
+		case <-t.C:
+			cancel()
+		}
+	}()
+
+	return shutdownCtx, cancel
+}
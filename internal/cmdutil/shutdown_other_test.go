@@ -0,0 +1,87 @@
+//go:build !windows
+
+package cmdutil
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shutdownSignal is sent to the test process. Unlike an interrupt, it doesn't
+// stop "go test", if it isn't handled.
+const shutdownSignal = syscall.SIGUSR1
+
+func signalProcess(t *testing.T) {
+	t.Helper()
+	require.NoError(t, syscall.Kill(syscall.Getpid(), shutdownSignal))
+}
+
+func TestNotifyShutdown_Signals(t *testing.T) {
+	ctx, cancel := NotifyShutdown(context.Background(), shutdownSignal)
+	defer cancel()
+
+	abortCtx := AbortContext(ctx)
+
+	// The first signal asks for a graceful shutdown.
+	signalProcess(t)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("context not canceled by the first signal")
+	}
+	assert.NoError(t, abortCtx.Err())
+
+	// The second signal aborts.
+	signalProcess(t)
+	select {
+	case <-abortCtx.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("abort context not canceled by the second signal")
+	}
+}
+
+func TestShutdownContext_Signals(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := NotifyShutdown(context.Background(), shutdownSignal)
+		defer cancel()
+
+		shutdownCtx, cancelShutdown := ShutdownContext(ctx, time.Millisecond*100)
+		defer cancelShutdown()
+
+		signalProcess(t)
+		<-ctx.Done()
+
+		// Work can be finished until the timeout has passed.
+		assert.NoError(t, shutdownCtx.Err())
+		select {
+		case <-shutdownCtx.Done():
+		case <-time.After(time.Second * 5):
+			t.Fatal("shutdown context not canceled after the timeout")
+		}
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		ctx, cancel := NotifyShutdown(context.Background(), shutdownSignal)
+		defer cancel()
+
+		shutdownCtx, cancelShutdown := ShutdownContext(ctx, time.Hour)
+		defer cancelShutdown()
+
+		signalProcess(t)
+		<-ctx.Done()
+		assert.NoError(t, shutdownCtx.Err())
+
+		// Aborting doesn't wait for the timeout.
+		signalProcess(t)
+		select {
+		case <-shutdownCtx.Done():
+		case <-time.After(time.Second * 5):
+			t.Fatal("shutdown context not canceled when aborted")
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package cmdutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyShutdown_Parent(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+
+	ctx, cancel := NotifyShutdown(parent)
+	defer cancel()
+
+	abortCtx := AbortContext(ctx)
+	assert.NotEqual(t, ctx, abortCtx)
+
+	// Canceling the parent cancels both contexts.
+	cancelParent()
+	assert.Eventually(t, func() bool { return ctx.Err() != nil && abortCtx.Err() != nil }, time.Second, time.Millisecond)
+}
+
+func TestNotifyShutdown_Cancel(t *testing.T) {
+	ctx, cancel := NotifyShutdown(context.Background())
+
+	cancel()
+	assert.Error(t, ctx.Err())
+	assert.Error(t, AbortContext(ctx).Err())
+
+	// Calling it again is a no-op.
+	assert.NotPanics(t, func() { cancel() })
+}
+
+func TestAbortContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, ctx, AbortContext(ctx))
+
+	notifyCtx, cancel := NotifyShutdown(ctx)
+	defer cancel()
+
+	// Contexts derived from the one returned by NotifyShutdown share its
+	// abort context.
+	derivedCtx, cancelDerived := context.WithCancel(notifyCtx)
+	defer cancelDerived()
+	assert.Equal(t, AbortContext(notifyCtx), AbortContext(derivedCtx))
+}
+
+func TestShutdownContext(t *testing.T) {
+	// Without NotifyShutdown, there is no graceful shutdown, so the shutdown
+	// context is canceled along with the given one.
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdownCtx, cancelShutdown := ShutdownContext(ctx, time.Hour)
+	defer cancelShutdown()
+
+	cancel()
+	assert.Error(t, shutdownCtx.Err())
+
+	// Canceling it doesn't affect the given context.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	shutdownCtx, cancelShutdown = ShutdownContext(ctx, time.Hour)
+
+	cancelShutdown()
+	assert.Error(t, shutdownCtx.Err())
+	assert.NoError(t, ctx.Err())
+}
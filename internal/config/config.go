@@ -29,6 +29,7 @@ var defaultConfig = Config{
 type Config struct {
 	ActiveDeployment string                `toml:"active_deployment" envconfig:"deployment"`
 	Deployments      map[string]Deployment `toml:"deployments"`
+	Redactions       map[string]Redaction  `toml:"redactions,omitempty"`
 	Insecure         bool                  `toml:"-" envconfig:"insecure"`
 
 	URLOverride            string `toml:"-" envconfig:"url"`
@@ -83,6 +84,19 @@ type Deployment struct {
 	OrganizationID string `toml:"org_id"`
 }
 
+// Redaction is a named set of rules for redacting sensitive data from events
+// before they are ingested.
+type Redaction struct {
+	// Rules are the names of built-in rules to apply.
+	Rules []string `toml:"rules"`
+	// Regexes are regular expressions matching further values to redact.
+	Regexes []string `toml:"regexes"`
+	// Mode of redaction: "mask", "hash" or "drop".
+	Mode string `toml:"mode"`
+	// Salt prepended to values before they are hashed.
+	Salt string `toml:"salt"`
+}
+
 // LoadDefault tries to load the default configuration. It behaves like Load()
 // but doesn't fail if the configuration file doesn't exist.
 func LoadDefault() (*Config, error) {
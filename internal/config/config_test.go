@@ -29,6 +29,12 @@ org_id = ""
 url = "axiom-eu-west-2.aws.com"
 token = "this-is-obviously-more-stupid"
 org_id = ""
+
+[redactions.compliance]
+rules = ["email", "ip"]
+regexes = ['secret=\S+']
+mode = "hash"
+salt = "pepper"
 `
 
 type TestConfigSuite struct {
@@ -54,4 +60,10 @@ func (s *TestConfigSuite) TestLoad() {
 
 	s.Equal("axiom-eu-west-2", cfg.ActiveDeployment)
 	s.Len(cfg.Deployments, 3)
+	s.Equal(config.Redaction{
+		Rules:   []string{"email", "ip"},
+		Regexes: []string{`secret=\S+`},
+		Mode:    "hash",
+		Salt:    "pepper",
+	}, cfg.Redactions["compliance"])
 }